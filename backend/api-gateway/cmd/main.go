@@ -10,9 +10,12 @@ import (
 
 	"github.com/financial-analytics/api-gateway/internal/config"
 	"github.com/financial-analytics/api-gateway/internal/gateway"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
 	"github.com/financial-analytics/api-gateway/internal/middleware"
 	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/financial-analytics/api-gateway/internal/tracing"
 	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
@@ -23,13 +26,42 @@ func main() {
 	defer func() { _ = logger.Sync() }()
 
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
 		logger.Fatal("Failed to load configuration", zap.Error(err))
 	}
 
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		logger.Fatal("Failed to set up tracing", zap.Error(err))
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			logger.Warn("Failed to flush traces", zap.Error(err))
+		}
+	}()
+
+	watcher := config.NewWatcher(cfg, os.Args[1:], logger)
+	go watcher.Run(ctx)
+
 	// Initialize services
-	authService := services.NewAuthService(cfg)
+	var authService services.AuthService
+	switch cfg.Auth.Verifier {
+	case "grpc":
+		grpcAuth, err := services.NewGRPCAuthService(cfg)
+		if err != nil {
+			logger.Fatal("Failed to connect to auth service", zap.Error(err))
+		}
+		defer func() { _ = grpcAuth.Close() }()
+		authService = grpcAuth
+	default:
+		authService = services.NewAuthService(cfg)
+	}
 
 	// Initialize Redis
 	rdb := redis.NewClient(&redis.Options{
@@ -37,8 +69,19 @@ func main() {
 		Password: cfg.Redis.Password,
 		DB:       cfg.Redis.DB,
 	})
-
-	rateLimiter := middleware.NewRateLimiter(rdb)
+	if err := redisotel.InstrumentTracing(rdb); err != nil {
+		logger.Fatal("Failed to instrument Redis", zap.Error(err))
+	}
+	rdb.AddHook(metrics.RedisHook{})
+
+	rateLimiter := middleware.NewFailoverRateLimiter(middleware.NewRateLimiter(rdb), cfg.RateLimit, logger)
+	rateLimitPolicy := middleware.NewRateLimitPolicy(cfg.RateLimit)
+	corsPolicy := middleware.NewCORSPolicy(cfg.CORS, logger)
+	watcher.OnReload(func(c *config.Config) {
+		rateLimiter.SetConfig(c.RateLimit)
+		rateLimitPolicy.SetConfig(c.RateLimit)
+		corsPolicy.SetConfig(c.CORS)
+	})
 
 	// Create gateway
 	gw := gateway.New(
@@ -46,13 +89,23 @@ func main() {
 		gateway.WithLogger(logger),
 		gateway.WithAuthService(authService),
 		gateway.WithRateLimiter(rateLimiter),
+		gateway.WithRateLimitPolicy(rateLimitPolicy),
+		gateway.WithRedis(rdb),
+		gateway.WithCORSPolicy(corsPolicy),
 	)
+	defer gw.Close()
 
 	// Setup routes
 	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatal("Invalid trusted proxies", zap.Error(err))
+	}
 	router.Use(gin.Recovery())
-	router.Use(middleware.Logger(logger))
-	router.Use(middleware.CORS())
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Tracing())
+	router.Use(middleware.Metrics())
+	router.Use(middleware.Logger(logger, cfg.Logging))
+	router.Use(middleware.CORS(corsPolicy))
 
 	gw.SetupRoutes(router)
 
@@ -72,6 +125,24 @@ func main() {
 		}
 	}()
 
+	// Serve metrics on their own port if configured
+	var metricsSrv *http.Server
+	if cfg.Metrics.Enabled && cfg.Metrics.Address != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		metricsSrv = &http.Server{
+			Addr:              cfg.Metrics.Address,
+			Handler:           mux,
+			ReadHeaderTimeout: cfg.Server.ReadTimeout,
+		}
+		go func() {
+			logger.Info("Starting metrics server", zap.String("address", metricsSrv.Addr))
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Fatal("Failed to start metrics server", zap.Error(err))
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -80,12 +151,17 @@ func main() {
 	logger.Info("Shutting down server...")
 
 	// Graceful shutdown
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := srv.Shutdown(ctx); err != nil {
+	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Error("Server forced to shutdown", zap.Error(err))
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+			logger.Error("Metrics server forced to shutdown", zap.Error(err))
+		}
+	}
 
 	logger.Info("Server exited")
 }
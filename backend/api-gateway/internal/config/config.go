@@ -1,33 +1,369 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Server ServerConfig
-	Redis  RedisConfig
-	Auth   AuthConfig
+	Server    ServerConfig    `yaml:"server"`
+	Redis     RedisConfig     `yaml:"redis"`
+	Auth      AuthConfig      `yaml:"auth"`
+	Services  ServicesConfig  `yaml:"services"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	CORS      CORSConfig      `yaml:"cors"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Internal  InternalConfig  `yaml:"internal"`
+	Events    EventsConfig    `yaml:"events"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+	Health    HealthConfig    `yaml:"health"`
+	Upstreams UpstreamsConfig `yaml:"upstreams"`
+
+	// File is the YAML file the configuration was read from, if any.
+	File string `yaml:"-"`
+	// WatchInterval controls how often File is checked for changes.
+	WatchInterval time.Duration `yaml:"watch_interval"`
 }
 
 type ServerConfig struct {
-	Address      string
-	ReadTimeout  time.Duration
-	WriteTimeout time.Duration
+	Address      string        `yaml:"address"`
+	ReadTimeout  time.Duration `yaml:"read_timeout"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+	// TrustedProxies are the addresses or CIDR ranges of the load balancers
+	// in front of the gateway. X-Forwarded-For is only believed when they
+	// send it; otherwise the client address is the connection's.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
 
 type RedisConfig struct {
-	Address  string
-	Password string
-	DB       int
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
 }
 
 type AuthConfig struct {
-	ServiceURL string
+	ServiceURL string        `yaml:"service_url"`
+	JWTSecret  string        `yaml:"jwt_secret"`
+	Issuer     string        `yaml:"issuer"`
+	Audience   string        `yaml:"audience"`
+	ClockSkew  time.Duration `yaml:"clock_skew"`
+
+	// Verifier selects how access tokens are checked: "local" verifies the
+	// JWT signature in-process, "grpc" asks auth-service at GRPCAddress.
+	Verifier       string        `yaml:"verifier"`
+	GRPCAddress    string        `yaml:"grpc_address"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	PoolSize       int           `yaml:"pool_size"`
+	// CacheTTL is how long a verified token or API key is trusted without
+	// asking auth-service again. Sessions and keys revoked elsewhere stay
+	// usable on each replica for up to this long.
+	CacheTTL  time.Duration `yaml:"cache_ttl"`
+	CacheSize int           `yaml:"cache_size"`
+}
+
+// ServicesConfig holds the base URLs of the upstream services the gateway
+// proxies to.
+type ServicesConfig struct {
+	DashboardURL string `yaml:"dashboard_url"`
+}
+
+// RateLimitConfig is reloadable at runtime. Each user may make Requests
+// per Window to each route, and UserRequests per Window across all routes
+// (0 for no ceiling). Users whose plan claim names an entry in Plans get
+// that plan's limits instead.
+type RateLimitConfig struct {
+	Requests     int                      `yaml:"requests"`
+	UserRequests int                      `yaml:"user_requests"`
+	Window       time.Duration            `yaml:"window"`
+	Plans        map[string]RateLimitPlan `yaml:"plans"`
+	// Routes sets what requests to expensive routes count against both
+	// limits; other requests cost 1.
+	Routes []RouteCost `yaml:"routes"`
+	// ExemptUsers are the user IDs of internal service accounts, which are
+	// never rate limited.
+	ExemptUsers []string `yaml:"exempt_users"`
+
+	// FailureMode decides what happens while Redis is unreachable: "open"
+	// allows every request, "closed" rejects them with 503, and "local"
+	// enforces the limits in each replica, divided by LocalReplicas.
+	FailureMode   string `yaml:"failure_mode"`
+	LocalReplicas int    `yaml:"local_replicas"`
+	// Timeout bounds each check against Redis. After BreakerFailures
+	// consecutive failures the gateway stops calling Redis for
+	// BreakerCooldown, then lets a single request try it again.
+	Timeout         time.Duration `yaml:"timeout"`
+	BreakerFailures int           `yaml:"breaker_failures"`
+	BreakerCooldown time.Duration `yaml:"breaker_cooldown"`
+
+	Auth AuthRateLimitConfig `yaml:"auth"`
+}
+
+// AuthRateLimitConfig throttles login, registration and token refresh,
+// which are called before the user is known. Each client address may make
+// IPRequests and each email address EmailRequests per Window. After
+// FreeFailures failed attempts within FailureWindow, the address or email
+// is blocked for Backoff, doubling with each further failure up to
+// MaxBackoff.
+type AuthRateLimitConfig struct {
+	IPRequests    int           `yaml:"ip_requests"`
+	EmailRequests int           `yaml:"email_requests"`
+	Window        time.Duration `yaml:"window"`
+	FreeFailures  int           `yaml:"free_failures"`
+	FailureWindow time.Duration `yaml:"failure_window"`
+	Backoff       time.Duration `yaml:"backoff"`
+	MaxBackoff    time.Duration `yaml:"max_backoff"`
+}
+
+// RateLimitPlan holds the limits of one plan. A zero Window means the
+// top-level window.
+type RateLimitPlan struct {
+	Requests     int           `yaml:"requests"`
+	UserRequests int           `yaml:"user_requests"`
+	Window       time.Duration `yaml:"window"`
+}
+
+// RouteCost prices requests to a route template, as registered with the
+// router, e.g. "/api/v1/dashboards/:id". An empty Method matches any.
+type RouteCost struct {
+	Method string `yaml:"method"`
+	Path   string `yaml:"path"`
+	Cost   int    `yaml:"cost"`
+}
+
+// CORSConfig is reloadable at runtime. AllowedOrigins also decides which
+// browser origins may open WebSockets. An origin may use a wildcard
+// subdomain, e.g. "https://*.example.com", or be "*" to allow any origin.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+type WebSocketConfig struct {
+	// MaxConnectionsPerUser caps concurrent sockets per user across tabs and
+	// devices; 0 means unlimited.
+	MaxConnectionsPerUser int `yaml:"max_connections_per_user"`
+	// PresenceTTL is how long a connection stays "online" in Redis without
+	// being refreshed by its replica, e.g. after the replica crashes.
+	PresenceTTL time.Duration `yaml:"presence_ttl"`
+	// ReplayBufferSize is how many recent messages are kept per user for
+	// clients resuming after a reconnect; ReplayTTL is how long they are
+	// kept after the user's last message.
+	ReplayBufferSize int           `yaml:"replay_buffer_size"`
+	ReplayTTL        time.Duration `yaml:"replay_ttl"`
+
+	// A client with more than LagThreshold messages waiting is lagging:
+	// price ticks are conflated to the latest per symbol and broadcasts
+	// dropped. It is disconnected after lagging for MaxLag, or when
+	// MaxQueue messages are waiting.
+	LagThreshold int           `yaml:"lag_threshold"`
+	MaxLag       time.Duration `yaml:"max_lag"`
+	MaxQueue     int           `yaml:"max_queue"`
+
+	// MaxBatch caps how many messages share a frame when the client
+	// negotiated a batching subprotocol.
+	MaxBatch    int               `yaml:"max_batch"`
+	Compression CompressionConfig `yaml:"compression"`
+}
+
+// CompressionConfig controls permessage-deflate. Frames smaller than
+// MinSize are sent uncompressed, as deflate costs more than it saves on
+// them. Level is a compress/flate level from -2 to 9.
+type CompressionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	Level   int  `yaml:"level"`
+	MinSize int  `yaml:"min_size"`
+}
+
+// InternalConfig protects the /internal API other services use to push
+// messages to connected users, which also reports upstream circuit
+// breakers. The API is disabled when Token is empty.
+type InternalConfig struct {
+	Token string `yaml:"token"`
+}
+
+// EventsConfig controls the Kafka consumer that pushes domain events to
+// WebSocket clients. It is disabled when no brokers are set.
+type EventsConfig struct {
+	Brokers []string `yaml:"brokers"`
+	// GroupID is the consumer group shared by all gateway replicas, so each
+	// event is pushed once and offsets survive restarts.
+	GroupID string       `yaml:"group_id"`
+	Routes  []EventRoute `yaml:"routes"`
+}
+
+// EventRoute maps one event type on a topic to a WebSocket message.
+type EventRoute struct {
+	Topic string `yaml:"topic"`
+	Event string `yaml:"event"`
+	// Message is the WebSocket message type; defaults to Event.
+	Message string `yaml:"message"`
+	// Recipients names the event data fields holding the user IDs to push
+	// to. Each may be a single ID or a list.
+	Recipients []string `yaml:"recipients"`
+}
+
+// LoggingConfig controls the access log.
+type LoggingConfig struct {
+	// SampleRate is the fraction of successful requests logged, from 0 to
+	// 1. Failed requests are always logged.
+	SampleRate float64 `yaml:"sample_rate"`
+	// LogHeaders adds the request headers to each line, with the values of
+	// RedactHeaders masked.
+	LogHeaders    bool     `yaml:"log_headers"`
+	RedactHeaders []string `yaml:"redact_headers"`
+	// RedactQueryParams are masked in logged URLs, e.g. WebSocket tokens.
+	RedactQueryParams []string `yaml:"redact_query_params"`
+}
+
+// TracingConfig controls OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP to Endpoint, e.g. "http://otel-collector:4318"; tracing is a
+// no-op when it is empty.
+type TracingConfig struct {
+	Endpoint    string `yaml:"endpoint"`
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the fraction of new traces recorded. Requests that
+	// arrive with a sampled traceparent are always recorded.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// MetricsConfig controls the Prometheus endpoint at /metrics. It is served
+// on the main listener unless Address is set, which keeps it off the
+// public port.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Address string `yaml:"address"`
+}
+
+// HealthConfig controls the dependency checks behind /readyz: "redis",
+// "auth-service", "dashboard-service" and, when events are enabled,
+// "kafka". Each check is given Timeout. A failing check makes the gateway
+// unready unless it is listed in Optional, in which case it is only
+// reported as degraded.
+type HealthConfig struct {
+	Timeout  time.Duration `yaml:"timeout"`
+	Optional []string      `yaml:"optional"`
+}
+
+// UpstreamsConfig sets how the gateway calls each upstream service, "auth"
+// and "dashboard". A field left zero in Services takes its value from
+// Default.
+type UpstreamsConfig struct {
+	Default  UpstreamPolicy            `yaml:"default"`
+	Services map[string]UpstreamPolicy `yaml:"services"`
 }
 
-func Load() (*Config, error) {
-	// In a real app, we would load from env vars
+// UpstreamPolicy bounds the calls to one upstream. A call fails on a
+// connection error, a timeout or a 502, 503 or 504 response.
+type UpstreamPolicy struct {
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// ResponseTimeout bounds the wait for response headers once the
+	// request has been sent.
+	ResponseTimeout time.Duration `yaml:"response_timeout"`
+
+	// The circuit breaker opens after FailureThreshold consecutive failed
+	// calls and fails requests fast for OpenTimeout. It then lets
+	// HalfOpenRequests trial calls through, and closes if they all succeed.
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	HalfOpenRequests int           `yaml:"half_open_requests"`
+
+	// Failed calls with an idempotent method and no body are made up to
+	// MaxAttempts times, waiting a random time up to RetryBackoff, doubled
+	// with each attempt. Retries are limited to RetryBudget times the
+	// number of requests, so they can't multiply the load on a struggling
+	// upstream.
+	MaxAttempts  int           `yaml:"max_attempts"`
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	RetryBudget  float64       `yaml:"retry_budget"`
+
+	// Discovery finds the instances of the service: "static" uses
+	// Endpoints, or the service URL if there are none; "dns" resolves the
+	// service URL's host to its addresses, e.g. those of a Kubernetes
+	// headless service; "srv" looks up the SRV records of SRVName. Lookups
+	// are repeated every ResolveInterval. Endpoints and SRVName are never
+	// taken from Default.
+	Discovery       string        `yaml:"discovery"`
+	Endpoints       []string      `yaml:"endpoints"`
+	SRVName         string        `yaml:"srv_name"`
+	ResolveInterval time.Duration `yaml:"resolve_interval"`
+
+	// Balancer spreads requests over the healthy instances:
+	// "least-requests" or "round-robin". An instance that fails
+	// HealthFailures consecutive probes of HealthPath, made every
+	// HealthInterval within HealthTimeout, gets no requests until a probe
+	// succeeds.
+	Balancer       string        `yaml:"balancer"`
+	HealthPath     string        `yaml:"health_path"`
+	HealthInterval time.Duration `yaml:"health_interval"`
+	HealthTimeout  time.Duration `yaml:"health_timeout"`
+	HealthFailures int           `yaml:"health_failures"`
+}
+
+// Policy returns the policy for the named upstream.
+func (c UpstreamsConfig) Policy(name string) UpstreamPolicy {
+	p := c.Services[name]
+	d := c.Default
+	if p.ConnectTimeout == 0 {
+		p.ConnectTimeout = d.ConnectTimeout
+	}
+	if p.ResponseTimeout == 0 {
+		p.ResponseTimeout = d.ResponseTimeout
+	}
+	if p.FailureThreshold == 0 {
+		p.FailureThreshold = d.FailureThreshold
+	}
+	if p.OpenTimeout == 0 {
+		p.OpenTimeout = d.OpenTimeout
+	}
+	if p.HalfOpenRequests == 0 {
+		p.HalfOpenRequests = d.HalfOpenRequests
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = d.MaxAttempts
+	}
+	if p.RetryBackoff == 0 {
+		p.RetryBackoff = d.RetryBackoff
+	}
+	if p.RetryBudget == 0 {
+		p.RetryBudget = d.RetryBudget
+	}
+	if p.Discovery == "" {
+		p.Discovery = d.Discovery
+	}
+	if p.ResolveInterval == 0 {
+		p.ResolveInterval = d.ResolveInterval
+	}
+	if p.Balancer == "" {
+		p.Balancer = d.Balancer
+	}
+	if p.HealthPath == "" {
+		p.HealthPath = d.HealthPath
+	}
+	if p.HealthInterval == 0 {
+		p.HealthInterval = d.HealthInterval
+	}
+	if p.HealthTimeout == 0 {
+		p.HealthTimeout = d.HealthTimeout
+	}
+	if p.HealthFailures == 0 {
+		p.HealthFailures = d.HealthFailures
+	}
+	return p
+}
+
+func defaults() *Config {
 	return &Config{
 		Server: ServerConfig{
 			Address:      ":8080",
@@ -39,6 +375,174 @@ func Load() (*Config, error) {
 		},
 		Auth: AuthConfig{
 			ServiceURL: "http://localhost:8082",
+			Issuer:     "financial-analytics-auth",
+			Audience:   "financial-analytics-api",
+			ClockSkew:  30 * time.Second,
+
+			Verifier:       "grpc",
+			GRPCAddress:    "localhost:50051",
+			RequestTimeout: 2 * time.Second,
+			PoolSize:       4,
+			CacheTTL:       30 * time.Second,
+			CacheSize:      10000,
+		},
+		Services: ServicesConfig{
+			DashboardURL: "http://localhost:8084",
+		},
+		RateLimit: RateLimitConfig{
+			Requests:     100,
+			UserRequests: 300,
+			Window:       time.Minute,
+			Plans: map[string]RateLimitPlan{
+				"free": {Requests: 100, UserRequests: 300},
+				"pro":  {Requests: 1000, UserRequests: 3000},
+			},
+			Routes: []RouteCost{
+				{Method: "POST", Path: "/api/v1/analytics/calculate", Cost: 10},
+			},
+			FailureMode:     "local",
+			LocalReplicas:   1,
+			Timeout:         100 * time.Millisecond,
+			BreakerFailures: 5,
+			BreakerCooldown: 10 * time.Second,
+			Auth: AuthRateLimitConfig{
+				IPRequests:    20,
+				EmailRequests: 5,
+				Window:        time.Minute,
+				FreeFailures:  3,
+				FailureWindow: 15 * time.Minute,
+				Backoff:       time.Second,
+				MaxBackoff:    15 * time.Minute,
+			},
+		},
+		CORS: CORSConfig{
+			AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", "X-Request-ID"},
+			ExposedHeaders: []string{"X-Request-ID", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
+			MaxAge:         10 * time.Minute,
 		},
-	}, nil
+		WebSocket: WebSocketConfig{
+			MaxConnectionsPerUser: 5,
+			PresenceTTL:           time.Minute,
+			ReplayBufferSize:      256,
+			ReplayTTL:             15 * time.Minute,
+			LagThreshold:          256,
+			MaxLag:                30 * time.Second,
+			MaxQueue:              1024,
+			MaxBatch:              64,
+			Compression: CompressionConfig{
+				Enabled: true,
+				Level:   1,
+				MinSize: 512,
+			},
+		},
+		Events: EventsConfig{
+			GroupID: "api-gateway",
+			Routes: []EventRoute{
+				{Topic: "dashboard-events", Event: "dashboard.created", Recipients: []string{"user_id"}},
+				{Topic: "dashboard-events", Event: "dashboard.updated", Recipients: []string{"owner_id", "collaborators"}},
+				{Topic: "dashboard-events", Event: "dashboard.deleted", Recipients: []string{"owner_id", "collaborators"}},
+				{Topic: "dashboard-events", Event: "dashboard.shared", Recipients: []string{"shared_with"}},
+				{Topic: "dashboard-events", Event: "widget.added", Recipients: []string{"owner_id", "collaborators"}},
+			},
+		},
+		Logging: LoggingConfig{
+			SampleRate:        1,
+			RedactHeaders:     []string{"Authorization", "Cookie", "Sec-WebSocket-Protocol", "X-API-Key", "X-Internal-Token"},
+			RedactQueryParams: []string{"token", "access_token", "refresh_token"},
+		},
+		Tracing: TracingConfig{
+			ServiceName: "api-gateway",
+			SampleRatio: 1,
+		},
+		Metrics: MetricsConfig{
+			Enabled: true,
+		},
+		Health: HealthConfig{
+			Timeout:  2 * time.Second,
+			Optional: []string{"dashboard-service", "kafka"},
+		},
+		Upstreams: UpstreamsConfig{
+			Default: UpstreamPolicy{
+				ConnectTimeout:   2 * time.Second,
+				ResponseTimeout:  10 * time.Second,
+				FailureThreshold: 5,
+				OpenTimeout:      30 * time.Second,
+				HalfOpenRequests: 1,
+				MaxAttempts:      3,
+				RetryBackoff:     100 * time.Millisecond,
+				RetryBudget:      0.2,
+				Discovery:        "static",
+				ResolveInterval:  30 * time.Second,
+				Balancer:         "least-requests",
+				HealthPath:       "/livez",
+				HealthInterval:   5 * time.Second,
+				HealthTimeout:    2 * time.Second,
+				HealthFailures:   2,
+			},
+			Services: map[string]UpstreamPolicy{
+				"auth": {ResponseTimeout: 5 * time.Second},
+			},
+		},
+		WatchInterval: 10 * time.Second,
+	}
+}
+
+// Load builds the configuration in layers, each overriding the last:
+// built-in defaults, the YAML file named by -config or CONFIG_FILE,
+// environment variables, and finally command-line flags. The result is
+// validated before it is returned.
+func Load(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("api-gateway", flag.ContinueOnError)
+	file := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file")
+	addr := fs.String("addr", "", "address to listen on")
+	redisAddr := fs.String("redis-addr", "", "Redis address")
+	verifier := fs.String("auth-verifier", "", `token verifier, "local" or "grpc"`)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaults()
+
+	if *file != "" {
+		if err := loadFile(cfg, *file); err != nil {
+			return nil, err
+		}
+		cfg.File = *file
+	}
+
+	if err := applyEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Address = *addr
+		case "redis-addr":
+			cfg.Redis.Address = *redisAddr
+		case "auth-verifier":
+			cfg.Auth.Verifier = *verifier
+		}
+	})
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("parse config file %s: %w", path, err)
+	}
+	return nil
 }
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envVar binds an environment variable to the configuration field it
+// overrides. Variables are applied in order, so later entries win.
+type envVar struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}
+
+var envVars = []envVar{
+	{"SERVER_ADDRESS", setString(func(c *Config) *string { return &c.Server.Address })},
+	{"SERVER_READ_TIMEOUT", setDuration(func(c *Config) *time.Duration { return &c.Server.ReadTimeout })},
+	{"SERVER_WRITE_TIMEOUT", setDuration(func(c *Config) *time.Duration { return &c.Server.WriteTimeout })},
+	{"SERVER_TRUSTED_PROXIES", setList(func(c *Config) *[]string { return &c.Server.TrustedProxies })},
+
+	// REDIS_HOST comes from the app-config ConfigMap; REDIS_URL from the
+	// redis-credentials secret and also carries the password.
+	{"REDIS_HOST", setRedisHost},
+	{"REDIS_PASSWORD", setString(func(c *Config) *string { return &c.Redis.Password })},
+	{"REDIS_DB", setInt(func(c *Config) *int { return &c.Redis.DB })},
+	{"REDIS_URL", setRedisURL},
+
+	{"JWT_SECRET", setString(func(c *Config) *string { return &c.Auth.JWTSecret })},
+	{"JWT_ISSUER", setString(func(c *Config) *string { return &c.Auth.Issuer })},
+	{"JWT_AUDIENCE", setString(func(c *Config) *string { return &c.Auth.Audience })},
+	{"AUTH_SERVICE_URL", setString(func(c *Config) *string { return &c.Auth.ServiceURL })},
+	{"AUTH_GRPC_ADDRESS", setString(func(c *Config) *string { return &c.Auth.GRPCAddress })},
+	{"AUTH_VERIFIER", setString(func(c *Config) *string { return &c.Auth.Verifier })},
+
+	{"DASHBOARD_SERVICE_URL", setString(func(c *Config) *string { return &c.Services.DashboardURL })},
+
+	{"RATE_LIMIT_REQUESTS", setInt(func(c *Config) *int { return &c.RateLimit.Requests })},
+	{"RATE_LIMIT_USER_REQUESTS", setInt(func(c *Config) *int { return &c.RateLimit.UserRequests })},
+	{"RATE_LIMIT_WINDOW", setDuration(func(c *Config) *time.Duration { return &c.RateLimit.Window })},
+	{"RATE_LIMIT_EXEMPT_USERS", setList(func(c *Config) *[]string { return &c.RateLimit.ExemptUsers })},
+	{"RATE_LIMIT_FAILURE_MODE", setString(func(c *Config) *string { return &c.RateLimit.FailureMode })},
+	{"RATE_LIMIT_LOCAL_REPLICAS", setInt(func(c *Config) *int { return &c.RateLimit.LocalReplicas })},
+	{"AUTH_RATE_LIMIT_IP_REQUESTS", setInt(func(c *Config) *int { return &c.RateLimit.Auth.IPRequests })},
+	{"AUTH_RATE_LIMIT_EMAIL_REQUESTS", setInt(func(c *Config) *int { return &c.RateLimit.Auth.EmailRequests })},
+
+	{"CORS_ALLOWED_ORIGINS", setList(func(c *Config) *[]string { return &c.CORS.AllowedOrigins })},
+	{"CORS_ALLOWED_METHODS", setList(func(c *Config) *[]string { return &c.CORS.AllowedMethods })},
+	{"CORS_ALLOWED_HEADERS", setList(func(c *Config) *[]string { return &c.CORS.AllowedHeaders })},
+	{"CORS_EXPOSED_HEADERS", setList(func(c *Config) *[]string { return &c.CORS.ExposedHeaders })},
+	{"CORS_ALLOW_CREDENTIALS", setBool(func(c *Config) *bool { return &c.CORS.AllowCredentials })},
+	{"CORS_MAX_AGE", setDuration(func(c *Config) *time.Duration { return &c.CORS.MaxAge })},
+
+	{"WS_MAX_CONNECTIONS_PER_USER", setInt(func(c *Config) *int { return &c.WebSocket.MaxConnectionsPerUser })},
+	{"WS_PRESENCE_TTL", setDuration(func(c *Config) *time.Duration { return &c.WebSocket.PresenceTTL })},
+	{"WS_REPLAY_BUFFER_SIZE", setInt(func(c *Config) *int { return &c.WebSocket.ReplayBufferSize })},
+	{"WS_REPLAY_TTL", setDuration(func(c *Config) *time.Duration { return &c.WebSocket.ReplayTTL })},
+	{"WS_LAG_THRESHOLD", setInt(func(c *Config) *int { return &c.WebSocket.LagThreshold })},
+	{"WS_MAX_LAG", setDuration(func(c *Config) *time.Duration { return &c.WebSocket.MaxLag })},
+	{"WS_MAX_QUEUE", setInt(func(c *Config) *int { return &c.WebSocket.MaxQueue })},
+	{"WS_MAX_BATCH", setInt(func(c *Config) *int { return &c.WebSocket.MaxBatch })},
+	{"WS_COMPRESSION", setBool(func(c *Config) *bool { return &c.WebSocket.Compression.Enabled })},
+	{"WS_COMPRESSION_LEVEL", setInt(func(c *Config) *int { return &c.WebSocket.Compression.Level })},
+	{"WS_COMPRESSION_MIN_SIZE", setInt(func(c *Config) *int { return &c.WebSocket.Compression.MinSize })},
+
+	{"KAFKA_BROKERS", setList(func(c *Config) *[]string { return &c.Events.Brokers })},
+	{"EVENTS_GROUP_ID", setString(func(c *Config) *string { return &c.Events.GroupID })},
+
+	{"INTERNAL_API_TOKEN", setString(func(c *Config) *string { return &c.Internal.Token })},
+
+	{"LOG_SAMPLE_RATE", setFloat(func(c *Config) *float64 { return &c.Logging.SampleRate })},
+	{"LOG_HEADERS", setBool(func(c *Config) *bool { return &c.Logging.LogHeaders })},
+	{"LOG_REDACT_HEADERS", setList(func(c *Config) *[]string { return &c.Logging.RedactHeaders })},
+	{"LOG_REDACT_QUERY_PARAMS", setList(func(c *Config) *[]string { return &c.Logging.RedactQueryParams })},
+
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", setString(func(c *Config) *string { return &c.Tracing.Endpoint })},
+	{"OTEL_SERVICE_NAME", setString(func(c *Config) *string { return &c.Tracing.ServiceName })},
+	{"TRACING_SAMPLE_RATIO", setFloat(func(c *Config) *float64 { return &c.Tracing.SampleRatio })},
+
+	{"METRICS_ENABLED", setBool(func(c *Config) *bool { return &c.Metrics.Enabled })},
+	{"METRICS_ADDRESS", setString(func(c *Config) *string { return &c.Metrics.Address })},
+
+	{"HEALTH_TIMEOUT", setDuration(func(c *Config) *time.Duration { return &c.Health.Timeout })},
+	{"HEALTH_OPTIONAL", setList(func(c *Config) *[]string { return &c.Health.Optional })},
+}
+
+func applyEnv(cfg *Config) error {
+	for _, v := range envVars {
+		value, ok := os.LookupEnv(v.name)
+		if !ok || value == "" {
+			continue
+		}
+		if err := v.apply(cfg, value); err != nil {
+			return fmt.Errorf("invalid %s: %w", v.name, err)
+		}
+	}
+	return nil
+}
+
+func setString(field func(*Config) *string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		*field(cfg) = value
+		return nil
+	}
+}
+
+func setInt(field func(*Config) *int) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+func setFloat(field func(*Config) *float64) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = f
+		return nil
+	}
+}
+
+func setBool(field func(*Config) *bool) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = b
+		return nil
+	}
+}
+
+func setDuration(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = d
+		return nil
+	}
+}
+
+// setList parses a comma-separated list, ignoring blank entries.
+func setList(field func(*Config) *[]string) func(*Config, string) error {
+	return func(cfg *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(cfg) = items
+		return nil
+	}
+}
+
+func setRedisHost(cfg *Config, value string) error {
+	port := "6379"
+	if _, p, err := net.SplitHostPort(cfg.Redis.Address); err == nil {
+		port = p
+	}
+	if p := os.Getenv("REDIS_PORT"); p != "" {
+		port = p
+	}
+	cfg.Redis.Address = net.JoinHostPort(value, port)
+	return nil
+}
+
+func setRedisURL(cfg *Config, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	cfg.Redis.Address = host
+
+	if password, ok := u.User.Password(); ok {
+		cfg.Redis.Password = password
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		n, err := strconv.Atoi(db)
+		if err != nil {
+			return fmt.Errorf("invalid database %q", db)
+		}
+		cfg.Redis.DB = n
+	}
+	return nil
+}
@@ -0,0 +1,233 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Validate reports every invalid setting at once so a bad deployment fails
+// with a single, complete error.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Address != "", "server.address is required")
+	check(c.Server.ReadTimeout > 0, "server.read_timeout must be positive")
+	check(c.Server.WriteTimeout > 0, "server.write_timeout must be positive")
+	for _, proxy := range c.Server.TrustedProxies {
+		check(validIPOrCIDR(proxy), "server.trusted_proxies: %q is not an IP address or CIDR range", proxy)
+	}
+
+	check(validHostPort(c.Redis.Address), "redis.address %q is not host:port", c.Redis.Address)
+	check(c.Redis.DB >= 0, "redis.db must not be negative")
+
+	check(validURL(c.Auth.ServiceURL), "auth.service_url %q is not an http(s) URL", c.Auth.ServiceURL)
+	switch c.Auth.Verifier {
+	case "local":
+		check(c.Auth.JWTSecret != "", "auth.jwt_secret (JWT_SECRET) is required with the local verifier")
+	case "grpc":
+		check(validHostPort(c.Auth.GRPCAddress), "auth.grpc_address %q is not host:port", c.Auth.GRPCAddress)
+		check(c.Auth.RequestTimeout > 0, "auth.request_timeout must be positive")
+		check(c.Auth.PoolSize > 0, "auth.pool_size must be positive")
+		check(c.Auth.CacheTTL >= 0, "auth.cache_ttl must not be negative")
+		check(c.Auth.CacheSize >= 0, "auth.cache_size must not be negative")
+	default:
+		check(false, "auth.verifier must be \"local\" or \"grpc\", got %q", c.Auth.Verifier)
+	}
+	check(c.Auth.ClockSkew >= 0, "auth.clock_skew must not be negative")
+
+	check(validURL(c.Services.DashboardURL), "services.dashboard_url %q is not an http(s) URL", c.Services.DashboardURL)
+
+	check(c.RateLimit.Requests > 0, "rate_limit.requests must be positive")
+	check(c.RateLimit.UserRequests >= 0, "rate_limit.user_requests must not be negative")
+	check(c.RateLimit.Window > 0, "rate_limit.window must be positive")
+	for name, plan := range c.RateLimit.Plans {
+		check(plan.Requests > 0, "rate_limit.plans.%s.requests must be positive", name)
+		check(plan.UserRequests >= 0, "rate_limit.plans.%s.user_requests must not be negative", name)
+		check(plan.Window >= 0, "rate_limit.plans.%s.window must not be negative", name)
+	}
+	for i, route := range c.RateLimit.Routes {
+		check(strings.HasPrefix(route.Path, "/"), "rate_limit.routes[%d]: path %q must start with /", i, route.Path)
+		check(route.Method == strings.ToUpper(route.Method), "rate_limit.routes[%d]: method %q must be upper case", i, route.Method)
+		check(route.Cost > 0, "rate_limit.routes[%d]: cost must be positive", i)
+		check(route.Cost <= c.RateLimit.smallestQuota(), "rate_limit.routes[%d]: cost %d exceeds a plan's quota, so the route could never be called", i, route.Cost)
+	}
+	switch c.RateLimit.FailureMode {
+	case "open", "closed", "local":
+	default:
+		check(false, "rate_limit.failure_mode must be \"open\", \"closed\" or \"local\", got %q", c.RateLimit.FailureMode)
+	}
+	check(c.RateLimit.LocalReplicas > 0, "rate_limit.local_replicas must be positive")
+	check(c.RateLimit.Timeout > 0, "rate_limit.timeout must be positive")
+	check(c.RateLimit.BreakerFailures > 0, "rate_limit.breaker_failures must be positive")
+	check(c.RateLimit.BreakerCooldown > 0, "rate_limit.breaker_cooldown must be positive")
+	check(c.RateLimit.Auth.IPRequests > 0, "rate_limit.auth.ip_requests must be positive")
+	check(c.RateLimit.Auth.EmailRequests > 0, "rate_limit.auth.email_requests must be positive")
+	check(c.RateLimit.Auth.Window > 0, "rate_limit.auth.window must be positive")
+	check(c.RateLimit.Auth.FreeFailures >= 0, "rate_limit.auth.free_failures must not be negative")
+	check(c.RateLimit.Auth.FailureWindow > 0, "rate_limit.auth.failure_window must be positive")
+	check(c.RateLimit.Auth.Backoff > 0, "rate_limit.auth.backoff must be positive")
+	check(c.RateLimit.Auth.MaxBackoff >= c.RateLimit.Auth.Backoff, "rate_limit.auth.max_backoff must be at least rate_limit.auth.backoff")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		check(validOrigin(origin), "cors.allowed_origins: %q is not a valid origin", origin)
+		check(origin != "*" || !c.CORS.AllowCredentials,
+			"cors.allowed_origins: \"*\" cannot be combined with cors.allow_credentials")
+	}
+	check(len(c.CORS.AllowedMethods) > 0, "cors.allowed_methods must not be empty")
+	check(c.CORS.MaxAge >= 0, "cors.max_age must not be negative")
+
+	check(c.WebSocket.MaxConnectionsPerUser >= 0, "websocket.max_connections_per_user must not be negative")
+	check(c.WebSocket.PresenceTTL >= 3*time.Second, "websocket.presence_ttl must be at least 3s")
+	check(c.WebSocket.ReplayBufferSize > 0, "websocket.replay_buffer_size must be positive")
+	check(c.WebSocket.ReplayTTL >= time.Second, "websocket.replay_ttl must be at least 1s")
+	check(c.WebSocket.LagThreshold > 0, "websocket.lag_threshold must be positive")
+	check(c.WebSocket.MaxLag > 0, "websocket.max_lag must be positive")
+	check(c.WebSocket.MaxQueue >= c.WebSocket.LagThreshold, "websocket.max_queue must be at least websocket.lag_threshold")
+	check(c.WebSocket.MaxBatch > 0, "websocket.max_batch must be positive")
+	check(c.WebSocket.Compression.Level >= -2 && c.WebSocket.Compression.Level <= 9,
+		"websocket.compression.level must be between -2 and 9")
+	check(c.WebSocket.Compression.MinSize >= 0, "websocket.compression.min_size must not be negative")
+
+	for _, broker := range c.Events.Brokers {
+		check(validHostPort(broker), "events.brokers: %q is not host:port", broker)
+	}
+	if len(c.Events.Brokers) > 0 {
+		check(c.Events.GroupID != "", "events.group_id is required")
+	}
+	for i, route := range c.Events.Routes {
+		check(route.Topic != "" && route.Event != "", "events.routes[%d]: topic and event are required", i)
+		check(len(route.Recipients) > 0, "events.routes[%d]: recipients must not be empty", i)
+	}
+
+	check(c.Logging.SampleRate >= 0 && c.Logging.SampleRate <= 1, "logging.sample_rate must be between 0 and 1")
+
+	if c.Tracing.Endpoint != "" {
+		check(validURL(c.Tracing.Endpoint), "tracing.endpoint %q is not an http(s) URL", c.Tracing.Endpoint)
+	}
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1")
+
+	if c.Metrics.Enabled && c.Metrics.Address != "" {
+		check(validHostPort(c.Metrics.Address), "metrics.address %q is not host:port", c.Metrics.Address)
+		check(c.Metrics.Address != c.Server.Address, "metrics.address must differ from server.address")
+	}
+
+	check(c.Health.Timeout > 0, "health.timeout must be positive")
+	for _, name := range c.Health.Optional {
+		switch name {
+		case "redis", "auth-service", "dashboard-service", "kafka":
+		default:
+			check(false, "health.optional: unknown check %q", name)
+		}
+	}
+
+	d := c.Upstreams.Default
+	check(d.ConnectTimeout > 0, "upstreams.default.connect_timeout must be positive")
+	check(d.ResponseTimeout > 0, "upstreams.default.response_timeout must be positive")
+	check(d.FailureThreshold > 0, "upstreams.default.failure_threshold must be positive")
+	check(d.OpenTimeout > 0, "upstreams.default.open_timeout must be positive")
+	check(d.HalfOpenRequests > 0, "upstreams.default.half_open_requests must be positive")
+	check(d.MaxAttempts > 0, "upstreams.default.max_attempts must be positive")
+	check(d.RetryBackoff > 0, "upstreams.default.retry_backoff must be positive")
+	check(d.RetryBudget > 0, "upstreams.default.retry_budget must be positive")
+	check(d.ResolveInterval > 0, "upstreams.default.resolve_interval must be positive")
+	check(strings.HasPrefix(d.HealthPath, "/"), "upstreams.default.health_path %q must start with /", d.HealthPath)
+	check(d.HealthInterval > 0, "upstreams.default.health_interval must be positive")
+	check(d.HealthTimeout > 0, "upstreams.default.health_timeout must be positive")
+	check(d.HealthFailures > 0, "upstreams.default.health_failures must be positive")
+	check(len(d.Endpoints) == 0 && d.SRVName == "", "upstreams.default cannot set endpoints or srv_name")
+	for name, p := range c.Upstreams.Services {
+		switch name {
+		case "auth", "dashboard":
+		default:
+			check(false, "upstreams.services: unknown upstream %q", name)
+		}
+		check(p.ConnectTimeout >= 0 && p.ResponseTimeout >= 0 && p.OpenTimeout >= 0 && p.RetryBackoff >= 0 &&
+			p.ResolveInterval >= 0 && p.HealthInterval >= 0 && p.HealthTimeout >= 0,
+			"upstreams.services.%s: timeouts must not be negative", name)
+		check(p.FailureThreshold >= 0 && p.HalfOpenRequests >= 0 && p.MaxAttempts >= 0 && p.RetryBudget >= 0 && p.HealthFailures >= 0,
+			"upstreams.services.%s: limits must not be negative", name)
+		check(p.HealthPath == "" || strings.HasPrefix(p.HealthPath, "/"), "upstreams.services.%s.health_path %q must start with /", name, p.HealthPath)
+		for _, endpoint := range p.Endpoints {
+			check(validURL(endpoint), "upstreams.services.%s.endpoints: %q is not an http(s) URL", name, endpoint)
+		}
+	}
+	for _, name := range []string{"auth", "dashboard"} {
+		p := c.Upstreams.Policy(name)
+		switch p.Discovery {
+		case "static", "dns":
+		case "srv":
+			check(p.SRVName != "", "upstreams.services.%s.srv_name is required with srv discovery", name)
+		default:
+			check(false, "upstreams.services.%s.discovery must be \"static\", \"dns\" or \"srv\", got %q", name, p.Discovery)
+		}
+		switch p.Balancer {
+		case "least-requests", "round-robin":
+		default:
+			check(false, "upstreams.services.%s.balancer must be \"least-requests\" or \"round-robin\", got %q", name, p.Balancer)
+		}
+	}
+
+	check(c.WatchInterval > 0, "watch_interval must be positive")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// smallestQuota is the lowest number of requests any user may make per
+// window, to a route or overall.
+func (c RateLimitConfig) smallestQuota() int {
+	quota := c.Requests
+	if c.UserRequests > 0 {
+		quota = min(quota, c.UserRequests)
+	}
+	for _, plan := range c.Plans {
+		quota = min(quota, plan.Requests)
+		if plan.UserRequests > 0 {
+			quota = min(quota, plan.UserRequests)
+		}
+	}
+	return quota
+}
+
+func validIPOrCIDR(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+func validHostPort(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	return err == nil && port != ""
+}
+
+func validURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" {
+		return false
+	}
+	// A wildcard may only replace the leftmost label of a domain.
+	host := strings.TrimPrefix(u.Hostname(), "*.")
+	return host != "" && !strings.Contains(host, "*")
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Watcher reloads the configuration on SIGHUP or when the YAML file changes.
+// Only settings that are safe to change on a running gateway (rate limits and
+// CORS) are applied; anything else is logged and waits for a restart.
+type Watcher struct {
+	args    []string
+	logger  *zap.Logger
+	current atomic.Pointer[Config]
+	modTime time.Time
+
+	mu          sync.Mutex
+	subscribers []func(*Config)
+}
+
+func NewWatcher(cfg *Config, args []string, logger *zap.Logger) *Watcher {
+	w := &Watcher{
+		args:    args,
+		logger:  logger,
+		modTime: fileModTime(cfg.File),
+	}
+	w.current.Store(cfg)
+	return w
+}
+
+// Current returns the configuration with the latest reloadable settings.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// OnReload registers fn to be called with the new configuration after every
+// successful reload.
+func (w *Watcher) OnReload(fn func(*Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.subscribers = append(w.subscribers, fn)
+}
+
+// Run watches for reload triggers until ctx is cancelled.
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(w.Current().WatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			w.logger.Info("Received SIGHUP, reloading configuration")
+			w.reload()
+		case <-ticker.C:
+			file := w.Current().File
+			if file == "" {
+				continue
+			}
+			if mt := fileModTime(file); !mt.Equal(w.modTime) {
+				w.modTime = mt
+				w.logger.Info("Configuration file changed, reloading", zap.String("file", file))
+				w.reload()
+			}
+		}
+	}
+}
+
+func (w *Watcher) reload() {
+	next, err := Load(w.args)
+	if err != nil {
+		w.logger.Error("Configuration reload failed, keeping previous settings", zap.Error(err))
+		return
+	}
+
+	prev := w.Current()
+	applied := *prev
+	applied.RateLimit = next.RateLimit
+	applied.CORS = next.CORS
+
+	if !reflect.DeepEqual(withoutReloadable(prev), withoutReloadable(next)) {
+		w.logger.Warn("Configuration changes outside rate_limit and cors require a restart")
+	}
+
+	w.current.Store(&applied)
+
+	w.mu.Lock()
+	subscribers := append([]func(*Config){}, w.subscribers...)
+	w.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(&applied)
+	}
+
+	w.logger.Info("Configuration reloaded",
+		zap.Int("rate_limit_requests", applied.RateLimit.Requests),
+		zap.Duration("rate_limit_window", applied.RateLimit.Window),
+		zap.Int("rate_limit_plans", len(applied.RateLimit.Plans)),
+		zap.Strings("cors_allowed_origins", applied.CORS.AllowedOrigins),
+	)
+}
+
+func withoutReloadable(cfg *Config) Config {
+	c := *cfg
+	c.RateLimit = RateLimitConfig{}
+	c.CORS = CORSConfig{}
+	return c
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
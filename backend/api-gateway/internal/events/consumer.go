@@ -0,0 +1,151 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/financial-analytics/api-gateway/internal/tracing"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// Sender delivers a message to every connection a user has open.
+type Sender interface {
+	SendToUser(ctx context.Context, userID string, data []byte) error
+}
+
+// Consumer reads domain events from Kafka and pushes the routed ones to the
+// affected users' WebSocket connections. Offsets are committed only after
+// an event has been handed to the sender, so a restart resumes where the
+// consumer group left off.
+type Consumer struct {
+	reader  *kafka.Reader
+	groupID string
+	routes  routingTable
+	sender  Sender
+	tracer  trace.Tracer
+	logger  *zap.Logger
+}
+
+func NewConsumer(cfg config.EventsConfig, sender Sender, logger *zap.Logger) *Consumer {
+	routes := newRoutingTable(cfg.Routes)
+	return &Consumer{
+		reader: kafka.NewReader(kafka.ReaderConfig{
+			Brokers:     cfg.Brokers,
+			GroupID:     cfg.GroupID,
+			GroupTopics: routes.topics(),
+			StartOffset: kafka.LastOffset,
+		}),
+		groupID: cfg.GroupID,
+		routes:  routes,
+		sender:  sender,
+		tracer:  otel.Tracer(tracing.InstrumentationName),
+		logger:  logger,
+	}
+}
+
+// Run consumes events until ctx is cancelled, then leaves the consumer group.
+func (c *Consumer) Run(ctx context.Context) {
+	defer func() {
+		if err := c.reader.Close(); err != nil {
+			c.logger.Warn("Failed to close event consumer", zap.Error(err))
+		}
+	}()
+
+	c.logger.Info("Event consumer started", zap.Strings("topics", c.routes.topics()))
+
+	for {
+		msg, err := c.reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			metrics.KafkaErrors.WithLabelValues("fetch").Inc()
+			c.logger.Error("Failed to fetch event", zap.Error(err))
+			if !sleep(ctx, time.Second) {
+				return
+			}
+			continue
+		}
+
+		if !c.handle(ctx, msg) {
+			// ctx was cancelled mid-delivery; the uncommitted event is
+			// redelivered to whichever replica picks up the partition.
+			return
+		}
+
+		if err := c.reader.CommitMessages(ctx, msg); err != nil && !errors.Is(err, context.Canceled) {
+			metrics.KafkaErrors.WithLabelValues("commit").Inc()
+			c.logger.Error("Failed to commit event offset", zap.Error(err))
+		}
+	}
+}
+
+// handle pushes one event, retrying delivery until it succeeds or ctx is
+// cancelled. Malformed and unrouted events are skipped. The push continues
+// the trace of the request that published the event.
+func (c *Consumer) handle(ctx context.Context, msg kafka.Message) bool {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, tracing.MessageCarrier{Message: &msg})
+	ctx, span := c.tracer.Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingKafkaConsumerGroup(c.groupID),
+			semconv.MessagingKafkaDestinationPartition(msg.Partition),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		),
+	)
+	defer span.End()
+
+	push, userIDs, ok, err := c.routes.resolve(msg.Topic, msg.Value)
+	if err != nil {
+		metrics.KafkaErrors.WithLabelValues("decode").Inc()
+		c.logger.Warn("Skipping malformed event",
+			zap.String("topic", msg.Topic),
+			zap.Int64("offset", msg.Offset),
+			zap.Error(err),
+		)
+		return true
+	}
+	if !ok {
+		return true
+	}
+
+	for _, userID := range userIDs {
+		for {
+			err := c.sender.SendToUser(ctx, userID, push)
+			if err == nil {
+				break
+			}
+			metrics.KafkaErrors.WithLabelValues("deliver").Inc()
+			c.logger.Warn("Failed to push event, retrying",
+				zap.String("topic", msg.Topic),
+				zap.String("user_id", userID),
+				zap.Error(err),
+			)
+			if !sleep(ctx, time.Second) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
@@ -0,0 +1,106 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+)
+
+// event is the envelope the services publish, e.g.
+// {"type":"dashboard.updated","timestamp":1700000000,"data":{...}}.
+type event struct {
+	Type      string                     `json:"type"`
+	Timestamp int64                      `json:"timestamp"`
+	Data      map[string]json.RawMessage `json:"data"`
+}
+
+// pushMessage is what WebSocket clients receive for a routed event.
+type pushMessage struct {
+	Type      string                     `json:"type"`
+	Timestamp int64                      `json:"timestamp,omitempty"`
+	Data      map[string]json.RawMessage `json:"data"`
+}
+
+type route struct {
+	message    string
+	recipients []string
+}
+
+// routingTable looks up routes by topic and event type.
+type routingTable map[string]map[string]route
+
+func newRoutingTable(routes []config.EventRoute) routingTable {
+	table := make(routingTable)
+	for _, r := range routes {
+		if table[r.Topic] == nil {
+			table[r.Topic] = make(map[string]route)
+		}
+		message := r.Message
+		if message == "" {
+			message = r.Event
+		}
+		table[r.Topic][r.Event] = route{message: message, recipients: r.Recipients}
+	}
+	return table
+}
+
+func (t routingTable) topics() []string {
+	topics := make([]string, 0, len(t))
+	for topic := range t {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// resolve maps a Kafka message to the WebSocket message and the users it
+// goes to. ok is false for events with no route.
+func (t routingTable) resolve(topic string, value []byte) (msg []byte, userIDs []string, ok bool, err error) {
+	var ev event
+	if err := json.Unmarshal(value, &ev); err != nil {
+		return nil, nil, false, fmt.Errorf("decode event: %w", err)
+	}
+
+	r, ok := t[topic][ev.Type]
+	if !ok {
+		return nil, nil, false, nil
+	}
+
+	seen := make(map[string]bool)
+	data := make(map[string]json.RawMessage, len(ev.Data))
+	for k, v := range ev.Data {
+		data[k] = v
+	}
+	for _, field := range r.recipients {
+		for _, id := range userIDsIn(ev.Data[field]) {
+			if id != "" && !seen[id] {
+				seen[id] = true
+				userIDs = append(userIDs, id)
+			}
+		}
+		// Clients don't need to see who else was notified.
+		delete(data, field)
+	}
+
+	msg, err = json.Marshal(pushMessage{Type: r.message, Timestamp: ev.Timestamp, Data: data})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return msg, userIDs, true, nil
+}
+
+// userIDsIn accepts a single ID or a list of IDs.
+func userIDsIn(raw json.RawMessage) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		return many
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+)
+
+var testRoutes = []config.EventRoute{
+	{Topic: "dashboard-events", Event: "dashboard.shared", Message: "dashboard_shared", Recipients: []string{"shared_with", "owner_id"}},
+	{Topic: "dashboard-events", Event: "dashboard.updated", Recipients: []string{"owner_id"}},
+	{Topic: "alert-events", Event: "alert.triggered", Recipients: []string{"user_id"}},
+}
+
+func TestRoutingTableResolve(t *testing.T) {
+	table := newRoutingTable(testRoutes)
+
+	tests := []struct {
+		name      string
+		topic     string
+		value     string
+		wantMsg   string
+		wantUsers []string
+		wantOK    bool
+		wantErr   bool
+	}{
+		{
+			name:      "message type renamed and recipients removed",
+			topic:     "dashboard-events",
+			value:     `{"type":"dashboard.shared","timestamp":1700000000,"data":{"dashboard_id":"d1","owner_id":"u1","shared_with":["u2","u1",""]}}`,
+			wantMsg:   `{"type":"dashboard_shared","timestamp":1700000000,"data":{"dashboard_id":"d1"}}`,
+			wantUsers: []string{"u2", "u1"},
+			wantOK:    true,
+		},
+		{
+			name:      "message type defaults to the event",
+			topic:     "dashboard-events",
+			value:     `{"type":"dashboard.updated","data":{"dashboard_id":"d1","owner_id":"u1"}}`,
+			wantMsg:   `{"type":"dashboard.updated","data":{"dashboard_id":"d1"}}`,
+			wantUsers: []string{"u1"},
+			wantOK:    true,
+		},
+		{
+			name:    "routed event with no recipients",
+			topic:   "alert-events",
+			value:   `{"type":"alert.triggered","data":{"alert_id":7}}`,
+			wantMsg: `{"type":"alert.triggered","data":{"alert_id":7}}`,
+			wantOK:  true,
+		},
+		{
+			name:  "event routed on another topic",
+			topic: "alert-events",
+			value: `{"type":"dashboard.updated","data":{"owner_id":"u1"}}`,
+		},
+		{
+			name:  "unknown topic",
+			topic: "user-events",
+			value: `{"type":"dashboard.updated","data":{"owner_id":"u1"}}`,
+		},
+		{
+			name:    "malformed event",
+			topic:   "dashboard-events",
+			value:   `{"type":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, userIDs, ok, err := table.resolve(tt.topic, []byte(tt.value))
+			if (err != nil) != tt.wantErr || ok != tt.wantOK {
+				t.Fatalf("ok %v, err %v, want ok %v, error %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+			if string(msg) != tt.wantMsg {
+				t.Errorf("message %s, want %s", msg, tt.wantMsg)
+			}
+			if !slices.Equal(userIDs, tt.wantUsers) {
+				t.Errorf("users %q, want %q", userIDs, tt.wantUsers)
+			}
+		})
+	}
+}
+
+func TestRoutingTableTopics(t *testing.T) {
+	topics := newRoutingTable(testRoutes).topics()
+	slices.Sort(topics)
+	if want := []string{"alert-events", "dashboard-events"}; !slices.Equal(topics, want) {
+		t.Errorf("topics %q, want %q", topics, want)
+	}
+}
+
+// recordingSender records pushes, failing them while err is set.
+type recordingSender struct {
+	err    error
+	pushes []string
+}
+
+func (s *recordingSender) SendToUser(_ context.Context, userID string, data []byte) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.pushes = append(s.pushes, userID+" "+string(data))
+	return nil
+}
+
+func newTestConsumer(sender Sender) *Consumer {
+	return &Consumer{
+		routes: newRoutingTable(testRoutes),
+		sender: sender,
+		tracer: otel.Tracer("test"),
+		logger: zap.NewNop(),
+	}
+}
+
+func TestConsumerHandle(t *testing.T) {
+	sender := &recordingSender{}
+	c := newTestConsumer(sender)
+	ctx := context.Background()
+
+	for _, value := range []string{
+		`{"type":"dashboard.updated","data":{"dashboard_id":"d1","owner_id":"u1"}}`,
+		`not json`,
+		`{"type":"dashboard.deleted","data":{"owner_id":"u1"}}`,
+	} {
+		if !c.handle(ctx, kafka.Message{Topic: "dashboard-events", Value: []byte(value)}) {
+			t.Fatalf("handle(%s) stopped the consumer", value)
+		}
+	}
+	want := []string{`u1 {"type":"dashboard.updated","data":{"dashboard_id":"d1"}}`}
+	if !slices.Equal(sender.pushes, want) {
+		t.Errorf("pushed %q, want %q", sender.pushes, want)
+	}
+}
+
+// An event whose delivery fails is retried until the consumer stops, and
+// handle then reports it undelivered so its offset isn't committed.
+func TestConsumerHandleStopsRetryingWhenCancelled(t *testing.T) {
+	c := newTestConsumer(&recordingSender{err: errors.New("redis unavailable")})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	msg := kafka.Message{Topic: "dashboard-events", Value: []byte(`{"type":"dashboard.updated","data":{"owner_id":"u1"}}`)}
+	if c.handle(ctx, msg) {
+		t.Error("undelivered event reported handled")
+	}
+}
@@ -1,25 +1,50 @@
 package gateway
 
 import (
+	"context"
 	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/events"
 	"github.com/financial-analytics/api-gateway/internal/handlers"
+	"github.com/financial-analytics/api-gateway/internal/health"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
 	"github.com/financial-analytics/api-gateway/internal/middleware"
 	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/financial-analytics/api-gateway/internal/upstream"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
 type Gateway struct {
-	config      *config.Config
-	logger      *zap.Logger
-	authService services.AuthService
-	rateLimiter middleware.RateLimiter
-	wsHub       *handlers.WebSocketHub
-	upgrader    websocket.Upgrader
+	config          *config.Config
+	logger          *zap.Logger
+	authService     services.AuthService
+	rateLimiter     *middleware.FailoverRateLimiter
+	rateLimitPolicy *middleware.RateLimitPolicy
+	corsPolicy      *middleware.CORSPolicy
+	redis           *redis.Client
+	wsHub           *handlers.WebSocketHub
+	wsCluster       *handlers.ClusterHub
+
+	// stop ends the background workers started by New; workers tracks
+	// them so Close can wait.
+	stop           context.CancelFunc
+	workers        sync.WaitGroup
+	upgrader       websocket.Upgrader
+	dashboardProxy *httputil.ReverseProxy
+	authProxy      *httputil.ReverseProxy
+	upstreams      []*upstream.Transport
+	health         *health.Checker
+	// policyTable lists every route's authorization rules, for review.
+	policyTable []middleware.Policy
 }
 
 type Option func(*Gateway)
@@ -27,10 +52,10 @@ type Option func(*Gateway)
 func New(opts ...Option) *Gateway {
 	g := &Gateway{
 		upgrader: websocket.Upgrader{
-			CheckOrigin: func(r *http.Request) bool {
-				// Configure origin checking for production
-				return true
-			},
+			// Prefer an encoding subprotocol; otherwise echo the token
+			// protocol back when the client authenticated with
+			// Sec-WebSocket-Protocol, as browsers require.
+			Subprotocols: append(append([]string{}, handlers.Subprotocols...), middleware.WebSocketTokenProtocol),
 		},
 	}
 
@@ -38,57 +63,162 @@ func New(opts ...Option) *Gateway {
 		opt(g)
 	}
 
+	g.upgrader.EnableCompression = g.config.WebSocket.Compression.Enabled
+	// Without a CORS policy the upgrader only accepts same-origin browsers.
+	if g.corsPolicy != nil {
+		g.upgrader.CheckOrigin = g.corsPolicy.CheckOrigin
+	}
+
+	// Initialize upstream proxies
+	dashboardProxy, err := g.newServiceProxy("dashboard", g.config.Services.DashboardURL, apiPrefix)
+	if err != nil {
+		g.logger.Fatal("Invalid dashboard service URL", zap.Error(err))
+	}
+	g.dashboardProxy = dashboardProxy
+
+	authProxy, err := g.newServiceProxy("auth", g.config.Auth.ServiceURL, authPrefix)
+	if err != nil {
+		g.logger.Fatal("Invalid auth service URL", zap.Error(err))
+	}
+	g.authProxy = authProxy
+
+	g.health = g.newHealthChecker()
+
 	// Initialize WebSocket hub
-	g.wsHub = handlers.NewWebSocketHub(g.logger)
+	g.wsHub = handlers.NewWebSocketHub(g.config.WebSocket, g.logger)
 	go g.wsHub.Run()
+	if err := prometheus.Register(handlers.NewHubCollector(g.wsHub)); err != nil {
+		g.logger.Warn("Failed to register WebSocket hub metrics", zap.Error(err))
+	}
+
+	ctx, stop := context.WithCancel(context.Background())
+	g.stop = stop
+
+	// Discover and probe upstream instances
+	for _, t := range g.upstreams {
+		t := t
+		g.runWorker(func() { t.Run(ctx) })
+	}
+
+	// Share messages and presence with the other gateway replicas
+	g.wsCluster = handlers.NewClusterHub(g.wsHub, g.redis, g.config.WebSocket, g.logger)
+	g.runWorker(func() { g.wsCluster.Run(ctx) })
+
+	// Push domain events from Kafka to the affected users
+	if len(g.config.Events.Brokers) > 0 {
+		consumer := events.NewConsumer(g.config.Events, g.wsCluster, g.logger)
+		g.runWorker(func() { consumer.Run(ctx) })
+	}
 
 	return g
 }
 
+func (g *Gateway) runWorker(fn func()) {
+	g.workers.Add(1)
+	go func() {
+		defer g.workers.Done()
+		fn()
+	}()
+}
+
+// Close stops the event consumer and cluster fan-out, and withdraws this
+// replica's WebSocket clients from presence.
+func (g *Gateway) Close() {
+	g.stop()
+	g.workers.Wait()
+}
+
 func (g *Gateway) SetupRoutes(router *gin.Engine) {
+	policies := middleware.NewPolicies()
+
 	// Health check
 	router.GET("/health", g.handleHealthCheck)
+	router.GET("/livez", g.handleLive)
+	router.GET("/readyz", g.handleReady)
+
+	// Metrics, unless they are served on their own port
+	if g.config.Metrics.Enabled && g.config.Metrics.Address == "" {
+		router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	}
+
+	// WebSocket endpoint, at the path the frontend connects to
+	router.GET("/ws/ws",
+		middleware.WebSocketAuth(g.authService),
+		middleware.RateLimit(g.rateLimiter, g.rateLimitPolicy),
+		g.handleWebSocket,
+	)
 
 	// API v1 routes
 	v1 := router.Group("/api/v1")
 	{
-		// Public routes
+		// Public routes, throttled by client address and email
 		auth := v1.Group("/auth")
+		auth.Use(middleware.AuthThrottle(g.rateLimiter, g.rateLimitPolicy))
 		{
-			auth.POST("/login", g.handleLogin)
-			auth.POST("/register", g.handleRegister)
-			auth.POST("/refresh", g.handleRefreshToken)
+			auth.POST("/login", g.proxyAuth)
+			auth.POST("/register", g.proxyAuth)
+			auth.POST("/refresh", g.proxyAuth)
+		}
+
+		// Public dashboards, rate limited by client address
+		public := v1.Group("/public")
+		public.Use(middleware.RateLimit(g.rateLimiter, g.rateLimitPolicy))
+		{
+			public.GET("/dashboards", g.proxyDashboards)
 		}
 
 		// Protected routes
 		protected := v1.Group("/")
 		protected.Use(middleware.Auth(g.authService))
-		protected.Use(middleware.RateLimit(g.rateLimiter))
+		protected.Use(middleware.RateLimit(g.rateLimiter, g.rateLimitPolicy))
 		{
+			// Logout revokes the session of the caller's access token
+			protected.POST("/auth/logout", g.proxyAuth)
+
+			// API key management; keys cannot be granted this scope
+			apiKeys := protected.Group("/auth/api-keys")
+			policies.Apply(apiKeys, middleware.RequireScope(services.ScopeAPIKeys))
+			{
+				apiKeys.GET("", g.proxyAuth)
+				apiKeys.POST("", g.proxyAuth)
+				apiKeys.DELETE("/:id", g.proxyAuth)
+			}
+
 			// Dashboard routes
 			dashboards := protected.Group("/dashboards")
+			policies.Apply(dashboards,
+				middleware.RequireScope(services.ScopeReadOnly, services.ScopeDashboardsWrite).On(readMethods...),
+				middleware.RequireScope(services.ScopeDashboardsWrite).On(writeMethods...),
+			)
 			{
-				dashboards.GET("", g.handleGetDashboards)
-				dashboards.POST("", g.handleCreateDashboard)
-				dashboards.GET("/:id", g.handleGetDashboard)
-				dashboards.PUT("/:id", g.handleUpdateDashboard)
-				dashboards.DELETE("/:id", g.handleDeleteDashboard)
-				dashboards.POST("/:id/share", g.handleShareDashboard)
+				dashboards.GET("", g.proxyDashboards)
+				dashboards.POST("", g.proxyDashboards)
+				dashboards.GET("/:id", g.proxyDashboards)
+				dashboards.PUT("/:id", g.proxyDashboards)
+				dashboards.DELETE("/:id", g.proxyDashboards)
+				dashboards.POST("/:id/widgets", g.proxyDashboards)
+				dashboards.PUT("/:id/widgets/:widgetId", g.proxyDashboards)
+				dashboards.DELETE("/:id/widgets/:widgetId", g.proxyDashboards)
+				dashboards.POST("/:id/share", g.proxyDashboards)
+				dashboards.GET("/:id/permissions", g.proxyDashboards)
+				dashboards.PUT("/:id/permissions", g.proxyDashboards)
 			}
 
 			// Analytics routes
 			analytics := protected.Group("/analytics")
+			policies.Apply(analytics, middleware.RequireScope(services.ScopeAnalytics))
 			{
 				analytics.GET("/indicators/:symbol", g.handleGetIndicators)
 				analytics.POST("/calculate", g.handleCalculate)
 				analytics.GET("/historical/:symbol", g.handleGetHistorical)
 			}
 
-			// WebSocket endpoint
-			protected.GET("/ws", g.handleWebSocket)
-
 			// User routes
 			users := protected.Group("/users")
+			policies.Apply(users,
+				middleware.RequireScope(services.ScopeReadOnly).On(readMethods...),
+				middleware.RequireScope(services.ScopeProfileWrite).On(writeMethods...),
+			)
 			{
 				users.GET("/profile", g.handleGetProfile)
 				users.PUT("/profile", g.handleUpdateProfile)
@@ -98,6 +228,10 @@ func (g *Gateway) SetupRoutes(router *gin.Engine) {
 
 			// Watchlist routes
 			watchlists := protected.Group("/watchlists")
+			policies.Apply(watchlists,
+				middleware.RequireScope(services.ScopeReadOnly).On(readMethods...),
+				middleware.RequireScope(services.ScopeWatchlistsWrite).On(writeMethods...),
+			)
 			{
 				watchlists.GET("", g.handleGetWatchlists)
 				watchlists.POST("", g.handleCreateWatchlist)
@@ -107,14 +241,28 @@ func (g *Gateway) SetupRoutes(router *gin.Engine) {
 
 			// Alert routes
 			alerts := protected.Group("/alerts")
+			policies.Apply(alerts,
+				middleware.RequireScope(services.ScopeReadOnly).On(readMethods...),
+				middleware.RequireScope(services.ScopeAlertsWrite).On(writeMethods...),
+			)
 			{
 				alerts.GET("", g.handleGetAlerts)
 				alerts.POST("", g.handleCreateAlert)
 				alerts.PUT("/:id", g.handleUpdateAlert)
 				alerts.DELETE("/:id", g.handleDeleteAlert)
 			}
+
+			// Administration
+			admin := protected.Group("/admin")
+			policies.Apply(admin, middleware.RequireRole(services.RoleAdmin))
+			{
+				admin.GET("/policies", g.handlePolicies)
+			}
 		}
 	}
+
+	g.setupInternalRoutes(router)
+	g.policyTable = policies.Table(router.Routes())
 }
 
 func (g *Gateway) handleHealthCheck(c *gin.Context) {
@@ -125,19 +273,82 @@ func (g *Gateway) handleHealthCheck(c *gin.Context) {
 }
 
 func (g *Gateway) handleWebSocket(c *gin.Context) {
+	userID := c.GetString("user_id")
+
+	// Enforce the connection limit across replicas before upgrading; the
+	// hub enforces it again locally. Presence errors don't block connecting.
+	if limit := g.config.WebSocket.MaxConnectionsPerUser; limit > 0 {
+		clients, err := g.wsCluster.UserClients(c.Request.Context(), userID)
+		if err != nil {
+			g.logger.Warn("Failed to check WebSocket presence", zap.Error(err))
+		} else if len(clients) >= limit {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many WebSocket connections"})
+			return
+		}
+	}
+
 	conn, err := g.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		g.logger.Error("Failed to upgrade WebSocket", zap.Error(err))
 		return
 	}
 
-	userID := c.GetString("user_id")
-	client := handlers.NewClient(conn, userID, g.wsHub)
+	// The socket outlives the handshake, so it is closed when the access
+	// token it was opened with expires.
+	var expiresAt time.Time
+	if claims, ok := c.Get("user_claims"); ok {
+		if exp := claims.(*services.Claims).ExpiresAt; exp != nil {
+			expiresAt = exp.Time
+		}
+	}
+
+	client := handlers.NewClient(conn, userID, expiresAt, g.wsHub)
 
+	// Register before reading the stream so nothing sent in between is
+	// missed; the client skips live copies of replayed messages.
 	g.wsHub.Register <- client
+	go client.ReadPump()
 
+	g.resumeStream(c, client, userID)
 	go client.WritePump()
-	go client.ReadPump()
+}
+
+// resumeStream positions the client in the user's message stream. With
+// resume_from it replays what the client missed, or tells it to refresh if
+// that is no longer possible.
+func (g *Gateway) resumeStream(c *gin.Context, client *handlers.Client, userID string) {
+	ctx := c.Request.Context()
+
+	raw := c.Query("resume_from")
+	if raw == "" {
+		position, err := g.wsCluster.Position(ctx, userID)
+		if err != nil {
+			g.logger.Warn("Failed to read WebSocket stream position", zap.Error(err))
+		}
+		client.Resume(position, nil, false)
+		return
+	}
+
+	resumeFrom, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		client.Resume(0, nil, true)
+		return
+	}
+
+	position, missed, complete, err := g.wsCluster.Replay(ctx, userID, resumeFrom)
+	if err != nil {
+		g.logger.Warn("Failed to replay WebSocket stream", zap.Error(err))
+		client.Resume(position, nil, true)
+		return
+	}
+	if !complete {
+		g.logger.Info("WebSocket resume gap too large, requesting resync",
+			zap.String("user_id", userID),
+			zap.Uint64("resume_from", resumeFrom),
+			zap.Uint64("position", position),
+		)
+	}
+	client.Resume(position, missed, !complete)
 }
 
 func WithConfig(cfg *config.Config) Option {
@@ -158,8 +369,28 @@ func WithAuthService(svc services.AuthService) Option {
 	}
 }
 
-func WithRateLimiter(rl middleware.RateLimiter) Option {
+func WithRateLimiter(rl *middleware.FailoverRateLimiter) Option {
 	return func(g *Gateway) {
 		g.rateLimiter = rl
 	}
 }
+
+// WithRateLimitPolicy sets the quotas the rate limiter enforces.
+func WithRateLimitPolicy(policy *middleware.RateLimitPolicy) Option {
+	return func(g *Gateway) {
+		g.rateLimitPolicy = policy
+	}
+}
+
+func WithRedis(client *redis.Client) Option {
+	return func(g *Gateway) {
+		g.redis = client
+	}
+}
+
+// WithCORSPolicy makes WebSocket upgrades accept the origins the API allows.
+func WithCORSPolicy(policy *middleware.CORSPolicy) Option {
+	return func(g *Gateway) {
+		g.corsPolicy = policy
+	}
+}
@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"context"
+	"net/http"
+	"slices"
+
+	"github.com/financial-analytics/api-gateway/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+// newHealthChecker builds the dependency checks behind /readyz.
+func (g *Gateway) newHealthChecker() *health.Checker {
+	check := func(name string, probe func(ctx context.Context) error) health.Check {
+		return health.Check{
+			Name:     name,
+			Critical: !slices.Contains(g.config.Health.Optional, name),
+			Probe:    probe,
+		}
+	}
+
+	checks := []health.Check{
+		check("redis", func(ctx context.Context) error {
+			return g.redis.Ping(ctx).Err()
+		}),
+	}
+	// Upstream instances are probed in the background; the service is
+	// ready while any of them is up.
+	for _, t := range g.upstreams {
+		checks = append(checks, check(t.Name()+"-service", t.Healthy))
+	}
+	if len(g.config.Events.Brokers) > 0 {
+		checks = append(checks, check("kafka", health.KafkaProbe(g.config.Events.Brokers)))
+	}
+	return health.NewChecker(g.config.Health.Timeout, checks...)
+}
+
+// handleLive reports that the process is serving requests. It checks no
+// dependencies, so an outage elsewhere never gets the gateway restarted.
+func (g *Gateway) handleLive(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// handleReady runs the dependency checks and responds 503 if a critical
+// one fails, taking this replica out of load balancing.
+func (g *Gateway) handleReady(c *gin.Context) {
+	report := g.health.Run(c.Request.Context())
+	status := http.StatusOK
+	if !report.Ready() {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, report)
+}
@@ -0,0 +1,195 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/financial-analytics/api-gateway/internal/middleware"
+	"github.com/financial-analytics/api-gateway/internal/upstream"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// maxPushSize bounds the body of a push request.
+const maxPushSize = 64 << 10
+
+// pushMessage is the body of a push request. Data is passed to the client
+// unchanged, e.g. {"type":"notification","data":{...}}.
+type pushMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// setupInternalRoutes registers the API other services use to push messages
+// to connected users, topic subscribers or everyone, the state of the
+// upstream circuit breakers and the authorization policy table. It is only
+// served when an internal token is set.
+func (g *Gateway) setupInternalRoutes(router *gin.Engine) {
+	if g.config.Internal.Token == "" {
+		return
+	}
+
+	internal := router.Group("/internal")
+	internal.Use(middleware.InternalAuth(g.config.Internal.Token))
+	{
+		ws := internal.Group("/ws")
+		{
+			ws.GET("/stats", g.handleHubStats)
+			ws.GET("/users/:userId", g.handleUserConnections)
+			ws.POST("/users/:userId/messages", g.handlePushToUser)
+			ws.POST("/clients/:clientId/messages", g.handlePushToClient)
+			ws.POST("/topics/:topic/messages", g.handlePublishToTopic)
+			ws.POST("/broadcast", g.handleBroadcast)
+		}
+		internal.GET("/upstreams", g.handleUpstreams)
+		internal.GET("/policies", g.handlePolicies)
+	}
+}
+
+// handleUpstreams reports the state of each upstream's circuit breaker in
+// this replica.
+func (g *Gateway) handleUpstreams(c *gin.Context) {
+	statuses := make([]upstream.Status, len(g.upstreams))
+	for i, t := range g.upstreams {
+		statuses[i] = t.Status()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"instance_id": g.wsCluster.InstanceID(),
+		"upstreams":   statuses,
+	})
+}
+
+// handleHubStats reports this replica's connections; each replica serves
+// its own.
+func (g *Gateway) handleHubStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"instance_id": g.wsCluster.InstanceID(),
+		"hub":         g.wsHub.Stats(),
+	})
+}
+
+// handleUserConnections reports whether a user is online anywhere in the
+// cluster.
+func (g *Gateway) handleUserConnections(c *gin.Context) {
+	userID := c.Param("userId")
+	clients, err := g.wsCluster.UserClients(c.Request.Context(), userID)
+	if err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":    userID,
+		"online":     len(clients) > 0,
+		"client_ids": clients,
+	})
+}
+
+func (g *Gateway) handlePushToUser(c *gin.Context) {
+	data, ok := readPushMessage(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := c.Param("userId")
+	clients, err := g.wsCluster.UserClients(ctx, userID)
+	if err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+	// Messages to offline users still go into their stream, so a client
+	// reconnecting with resume_from receives them.
+	if err := g.wsCluster.SendToUser(ctx, userID, data); err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"online":      len(clients) > 0,
+		"connections": len(clients),
+	})
+}
+
+func (g *Gateway) handlePushToClient(c *gin.Context) {
+	data, ok := readPushMessage(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	clientID := c.Param("clientId")
+	online, err := g.wsCluster.ClientOnline(ctx, clientID)
+	if err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+	if !online {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Client not connected"})
+		return
+	}
+
+	if err := g.wsCluster.SendToClient(ctx, clientID, data); err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"connections": 1})
+}
+
+// handlePublishToTopic sends a message to every client in the cluster
+// subscribed to the topic. Subscriptions are local to each replica, so the
+// number of recipients is not known.
+func (g *Gateway) handlePublishToTopic(c *gin.Context) {
+	data, ok := readPushMessage(c)
+	if !ok {
+		return
+	}
+
+	if err := g.wsCluster.Publish(c.Request.Context(), c.Param("topic"), data); err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{"topic": c.Param("topic")})
+}
+
+// handleBroadcast sends a message to every client in the cluster.
+func (g *Gateway) handleBroadcast(c *gin.Context) {
+	data, ok := readPushMessage(c)
+	if !ok {
+		return
+	}
+
+	if err := g.wsCluster.Broadcast(c.Request.Context(), data); err != nil {
+		g.presenceUnavailable(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, gin.H{})
+}
+
+func (g *Gateway) presenceUnavailable(c *gin.Context, err error) {
+	g.logger.Error("WebSocket cluster unavailable", zap.Error(err))
+	c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WebSocket cluster unavailable"})
+}
+
+// readPushMessage validates the request body and returns it re-encoded so
+// clients only ever receive well-formed messages.
+func readPushMessage(c *gin.Context) ([]byte, bool) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPushSize)
+
+	var msg pushMessage
+	if err := json.NewDecoder(c.Request.Body).Decode(&msg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message body"})
+		return nil, false
+	}
+	if msg.Type == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type is required"})
+		return nil, false
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message body"})
+		return nil, false
+	}
+	return data, true
+}
@@ -0,0 +1,67 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/handlers"
+	"github.com/financial-analytics/api-gateway/internal/middleware"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func TestPushToOfflineUserIsReplayedOnResume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+
+	cfg := &config.Config{}
+	cfg.Internal.Token = "internal-token"
+	cfg.WebSocket = config.WebSocketConfig{PresenceTTL: time.Minute, ReplayBufferSize: 100, ReplayTTL: time.Hour}
+	g := &Gateway{config: cfg, logger: zap.NewNop()}
+	g.wsHub = handlers.NewWebSocketHub(cfg.WebSocket, g.logger)
+	g.wsCluster = handlers.NewClusterHub(g.wsHub, client, cfg.WebSocket, g.logger)
+	router := gin.New()
+	g.setupInternalRoutes(router)
+
+	req := httptest.NewRequest(http.MethodPost, "/internal/ws/users/user-1/messages",
+		strings.NewReader(`{"type":"alert","data":{"id":"a1"}}`))
+	req.Header.Set(middleware.InternalTokenHeader, "internal-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Online      bool `json:"online"`
+		Connections int  `json:"connections"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Online || resp.Connections != 0 {
+		t.Errorf("got online %v with %d connections, want offline", resp.Online, resp.Connections)
+	}
+
+	// The user reconnects with resume_from=0.
+	position, missed, complete, err := g.wsCluster.Replay(context.Background(), "user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position != 1 || !complete || len(missed) != 1 {
+		t.Fatalf("got position %d, complete %v, %d missed, want 1, true, 1", position, complete, len(missed))
+	}
+	if got := string(missed[0].Data); got != `{"type":"alert","data":{"id":"a1"}}` {
+		t.Errorf("replayed %s", got)
+	}
+}
@@ -0,0 +1,19 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// readMethods and writeMethods split a route group's authorization rules
+// between reading and changing its resources.
+var (
+	readMethods  = []string{http.MethodGet, http.MethodHead}
+	writeMethods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+)
+
+// handlePolicies lists the authorization rules of every route, for review.
+func (g *Gateway) handlePolicies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"policies": g.policyTable})
+}
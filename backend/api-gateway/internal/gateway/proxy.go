@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/financial-analytics/api-gateway/internal/middleware"
+	"github.com/financial-analytics/api-gateway/internal/upstream"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+const (
+	// apiPrefix is stripped from proxied paths; upstream services mount their
+	// routes at the root.
+	apiPrefix = "/api/v1"
+
+	// authPrefix is stripped from auth routes; auth-service serves /login
+	// rather than /auth/login.
+	authPrefix = apiPrefix + "/auth"
+
+	// userIDHeader carries the authenticated user to upstream services.
+	userIDHeader = "X-User-ID"
+)
+
+// newServiceProxy builds a reverse proxy that forwards gateway requests to
+// the named upstream service at rawURL, with prefix removed from their
+// paths, and registers the upstream for the admin API.
+func (g *Gateway) newServiceProxy(name, rawURL, prefix string) (*httputil.ReverseProxy, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := upstream.NewTransport(name, rawURL, g.config.Upstreams.Policy(name), g.logger)
+	if err != nil {
+		return nil, err
+	}
+	g.upstreams = append(g.upstreams, transport)
+	logger := g.logger
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.Transport = transport
+
+	director := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		req.URL.Path = strings.TrimPrefix(req.URL.Path, prefix)
+		if req.URL.RawPath != "" {
+			req.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, prefix)
+		}
+		director(req)
+		req.Host = target.Host
+	}
+
+	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		// Every failure gets the same body, so clients can handle them alike.
+		body := upstreamError{Error: "Upstream service unavailable", Upstream: transport.Name()}
+		status := http.StatusBadGateway
+		var open *upstream.CircuitOpenError
+		switch {
+		case errors.As(err, &open):
+			body.Error = "Upstream service temporarily unavailable"
+			body.RetryAfter = max(int(math.Ceil(open.RetryAfter.Seconds())), 1)
+			status = http.StatusServiceUnavailable
+			w.Header().Set("Retry-After", strconv.Itoa(body.RetryAfter))
+		case errors.Is(err, upstream.ErrNoHealthyEndpoints):
+			status = http.StatusServiceUnavailable
+		case upstream.IsTimeout(err):
+			body.Error = "Upstream service timed out"
+			status = http.StatusGatewayTimeout
+		}
+
+		// Fast failures are counted in metrics rather than logged one by one.
+		if open == nil {
+			logger.Error("Upstream request failed",
+				zap.String("request_id", r.Header.Get(middleware.RequestIDHeader)),
+				zap.String("upstream", transport.Name()),
+				zap.String("path", r.URL.Path),
+				zap.Error(err),
+			)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+
+	return proxy, nil
+}
+
+// upstreamError is the body of every response the gateway sends when an
+// upstream call fails.
+type upstreamError struct {
+	Error    string `json:"error"`
+	Upstream string `json:"upstream"`
+	// RetryAfter is set, in seconds, when the upstream's circuit is open.
+	RetryAfter int `json:"retry_after,omitempty"`
+}
+
+// forward proxies the request upstream on behalf of the authenticated user.
+// Any client-supplied X-User-ID is discarded so callers cannot impersonate
+// other users. The request ID is passed on so upstream logs can be joined
+// with the gateway's.
+func (g *Gateway) forward(c *gin.Context, proxy *httputil.ReverseProxy) {
+	c.Request.Header.Del(userIDHeader)
+	if userID := c.GetString("user_id"); userID != "" {
+		c.Request.Header.Set(userIDHeader, userID)
+	}
+	if requestID := c.GetString(middleware.RequestIDKey); requestID != "" {
+		c.Request.Header.Set(middleware.RequestIDHeader, requestID)
+	}
+
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+func (g *Gateway) proxyDashboards(c *gin.Context) {
+	g.forward(c, g.dashboardProxy)
+}
+
+func (g *Gateway) proxyAuth(c *gin.Context) {
+	g.forward(c, g.authProxy)
+}
@@ -0,0 +1,74 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestForwardReplacesClientUserID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var path string
+	var userIDs []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, userIDs = r.URL.Path, r.Header.Values(userIDHeader)
+	}))
+	defer upstream.Close()
+
+	g := &Gateway{config: &config.Config{}, logger: zap.NewNop()}
+	proxy, err := g.newServiceProxy("dashboard", upstream.URL, apiPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.dashboardProxy = proxy
+
+	router := gin.New()
+	router.GET("/api/v1/dashboards", func(c *gin.Context) {
+		c.Set("user_id", "user-1")
+	}, g.proxyDashboards)
+	router.GET("/api/v1/public/dashboards", g.proxyDashboards)
+	// httputil.ReverseProxy needs a real connection to watch for clients
+	// going away.
+	gw := httptest.NewServer(router)
+	defer gw.Close()
+
+	tests := []struct {
+		name    string
+		path    string
+		want    []string
+		wantURL string
+	}{
+		{name: "authenticated", path: "/api/v1/dashboards", want: []string{"user-1"}, wantURL: "/dashboards"},
+		{name: "public", path: "/api/v1/public/dashboards", want: nil, wantURL: "/public/dashboards"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, userIDs = "", nil
+			req, err := http.NewRequest(http.MethodGet, gw.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Add(userIDHeader, "victim")
+			req.Header.Add(userIDHeader, "other-victim")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d, want 200", resp.StatusCode)
+			}
+			if path != tt.wantURL {
+				t.Errorf("upstream path %q, want %q", path, tt.wantURL)
+			}
+			if len(userIDs) != len(tt.want) || (len(tt.want) > 0 && userIDs[0] != tt.want[0]) {
+				t.Errorf("upstream %s %q, want %q", userIDHeader, userIDs, tt.want)
+			}
+		})
+	}
+}
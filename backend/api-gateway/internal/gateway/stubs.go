@@ -6,44 +6,6 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// Auth Handlers
-func (g *Gateway) handleLogin(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleRegister(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleRefreshToken(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-// Dashboard Handlers
-func (g *Gateway) handleGetDashboards(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleCreateDashboard(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleGetDashboard(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleUpdateDashboard(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleDeleteDashboard(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
-func (g *Gateway) handleShareDashboard(c *gin.Context) {
-	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
-}
-
 // Analytics Handlers
 func (g *Gateway) handleGetIndicators(c *gin.Context) {
 	c.JSON(http.StatusNotImplemented, gin.H{"error": "Not implemented"})
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,25 +12,92 @@ const (
 	writeWait      = 10 * time.Second
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
-	maxMessageSize = 512
+	maxMessageSize = 4096
+)
+
+// Application close codes.
+const (
+	// CloseTokenExpired is sent when the access token the connection was
+	// opened with expires. Clients should refresh the token and reconnect.
+	CloseTokenExpired = 4001
+	// CloseTooManyConnections is sent when the user already holds the
+	// maximum number of connections.
+	CloseTooManyConnections = 4002
+	// CloseTooSlow is sent when the client has fallen too far behind the
+	// messages sent to it. Clients should reconnect with resume_from.
+	CloseTooSlow = 4003
 )
 
 type Client struct {
 	hub    *WebSocketHub
 	conn   *websocket.Conn
-	send   chan []byte
+	queue  *sendQueue
+	id     string
 	userID string
+
+	// protocol is the negotiated encoding subprotocol, or "" for one JSON
+	// text frame per message.
+	protocol string
+
+	// expiresAt is when the client's access token expires; zero means never.
+	expiresAt time.Time
+
+	// subscriptions is owned by the hub goroutine.
+	subscriptions map[string]bool
+
+	// The stream fields are set by Resume and then owned by WritePump.
+	// position is the last stream seq sent; replayedUpTo is the last seq
+	// sent by replay, so live copies of replayed messages are skipped.
+	position     uint64
+	replay       []StreamMessage
+	replayedUpTo uint64
+	resync       bool
 }
 
-func NewClient(conn *websocket.Conn, userID string, hub *WebSocketHub) *Client {
+func NewClient(conn *websocket.Conn, userID string, expiresAt time.Time, hub *WebSocketHub) *Client {
+	var protocol string
+	switch p := conn.Subprotocol(); p {
+	case ProtocolNDJSON, ProtocolJSONArray, ProtocolMsgPack:
+		protocol = p
+	}
+
+	if hub.cfg.Compression.Enabled {
+		_ = conn.SetCompressionLevel(hub.cfg.Compression.Level)
+	}
+
 	return &Client{
-		hub:    hub,
-		conn:   conn,
-		send:   make(chan []byte, 256),
-		userID: userID,
+		hub:           hub,
+		conn:          conn,
+		queue:         newSendQueue(hub.cfg.LagThreshold, hub.cfg.MaxQueue, hub.cfg.MaxLag),
+		id:            newClientID(),
+		userID:        userID,
+		protocol:      protocol,
+		expiresAt:     expiresAt,
+		subscriptions: make(map[string]bool),
 	}
 }
 
+// ID identifies the connection for SendToClient.
+func (c *Client) ID() string {
+	return c.id
+}
+
+func newClientID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Resume sets where the client's stream starts: position is the user's
+// current stream seq and replay the messages the client missed since its
+// resume_from. If resync is set the gap could not be filled, and the client
+// is told to do a full refresh instead. It must be called before WritePump.
+func (c *Client) Resume(position uint64, replay []StreamMessage, resync bool) {
+	c.position = position
+	c.replay = replay
+	c.resync = resync
+}
+
 func (c *Client) ReadPump() {
 	defer func() {
 		c.hub.Unregister <- c
@@ -38,7 +107,7 @@ func (c *Client) ReadPump() {
 	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.conn.SetPongHandler(func(string) error { _ = c.conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, _, err := c.conn.ReadMessage()
+		messageType, data, err := c.conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				// log error
@@ -46,44 +115,82 @@ func (c *Client) ReadPump() {
 			}
 			break
 		}
-		// If we receive messages from client, we can process them here.
-		// For now, we just ignore incoming messages as this is likely primarily a push notification system.
+		c.handleMessage(messageType, data)
+	}
+}
+
+// handleMessage processes a request from the client. Replies go through the
+// hub, which owns the send channel.
+func (c *Client) handleMessage(messageType int, data []byte) {
+	var msg clientMessage
+	if err := decodeClientMessage(messageType, data, &msg); err != nil {
+		c.reply(errorMessage(ErrCodeInvalidMessage, "Message must be a JSON or MessagePack object", nil))
+		return
+	}
+
+	switch msg.Type {
+	case MessageTypePing:
+		c.reply(encodeServerMessage(serverMessage{Type: MessageTypePong}))
+	case MessageTypeSubscribe, MessageTypeUnsubscribe:
+		symbols, invalid := normalizeSymbols(msg.Symbols)
+		if len(invalid) > 0 {
+			c.reply(errorMessage(ErrCodeInvalidSymbol, "Invalid symbols", invalid))
+		}
+		if len(symbols) == 0 {
+			if len(invalid) == 0 {
+				c.reply(errorMessage(ErrCodeInvalidMessage, "symbols is required", nil))
+			}
+			return
+		}
+		c.hub.subscriptions <- subscriptionRequest{
+			client:    c,
+			symbols:   symbols,
+			subscribe: msg.Type == MessageTypeSubscribe,
+		}
+	default:
+		c.reply(errorMessage(ErrCodeUnknownType, "Unknown message type: "+msg.Type, nil))
 	}
 }
 
+func (c *Client) reply(data []byte) {
+	c.hub.replies <- clientReply{client: c, data: data}
+}
+
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
 	}()
+
+	var expired <-chan time.Time
+	if !c.expiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(c.expiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	if err := c.writeReplay(); err != nil {
+		return
+	}
+
 	for {
 		select {
-		case message, ok := <-c.send:
+		case <-c.queue.ready:
+			messages, closed, closeMessage := c.queue.drain()
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if !ok {
-				// The hub closed the channel.
-				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := c.conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if closed {
+				// The hub closed the queue.
+				_ = c.conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
-			if _, err := w.Write(message); err != nil {
-				return
-			}
-
-			// Add queued chat messages to the current websocket message.
-			n := len(c.send)
-			for i := 0; i < n; i++ {
-				if _, err := w.Write(<-c.send); err != nil {
-					return
+			batch := make([][]byte, 0, len(messages))
+			for _, message := range messages {
+				if data, ok := c.sequence(message); ok {
+					batch = append(batch, data)
 				}
 			}
-
-			if err := w.Close(); err != nil {
+			if err := c.writeBatch(batch); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -91,6 +198,62 @@ func (c *Client) WritePump() {
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+		case <-expired:
+			msg := websocket.FormatCloseMessage(CloseTokenExpired, "token expired")
+			_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// writeReplay sends the resync signal or the missed messages set by Resume.
+func (c *Client) writeReplay() error {
+	if c.resync {
+		msg := encodeServerMessage(serverMessage{Type: MessageTypeResync})
+		return c.writeBatch([][]byte{stamp(msg, c.position)})
+	}
+
+	batch := make([][]byte, 0, len(c.replay))
+	for _, m := range c.replay {
+		batch = append(batch, stamp(m.Data, m.Seq))
+		c.replayedUpTo = m.Seq
+		if m.Seq > c.position {
+			c.position = m.Seq
+		}
+	}
+	c.replay = nil
+	return c.writeBatch(batch)
+}
+
+// sequence stamps a queued message with its envelope. It reports false for
+// stream messages already sent by replay.
+func (c *Client) sequence(m outbound) ([]byte, bool) {
+	if m.seq == 0 {
+		return stamp(m.data, c.position), true
+	}
+	if m.seq <= c.replayedUpTo {
+		return nil, false
+	}
+	if m.seq > c.position {
+		c.position = m.seq
+	}
+	return stamp(m.data, m.seq), true
+}
+
+// writeBatch writes messages in the negotiated encoding, compressing
+// frames at or above the configured size when permessage-deflate is on.
+func (c *Client) writeBatch(messages [][]byte) error {
+	frames, err := encodeFrames(c.protocol, messages, c.hub.cfg.MaxBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range frames {
+		c.conn.EnableWriteCompression(len(f.data) >= c.hub.cfg.Compression.MinSize)
+		_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := c.conn.WriteMessage(f.messageType, f.data); err != nil {
+			return err
 		}
 	}
+	return nil
 }
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gorilla/websocket"
+)
+
+func TestClientClosedWhenTokenExpires(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	conn := hub.connect(t, "user-1", "expires_in=100ms")
+	if msg := read(t, conn); msg.Type != MessageTypeConnected {
+		t.Fatalf("first message %+v, want connected", msg)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := conn.ReadMessage()
+	if !websocket.IsCloseError(err, CloseTokenExpired) {
+		t.Fatalf("got %v, want close %d", err, CloseTokenExpired)
+	}
+}
+
+func TestClientWithoutExpiryStaysOpen(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	conn, _ := hub.connectClient(t, "user-1")
+
+	time.Sleep(150 * time.Millisecond)
+	expectNothingQueued(t, conn)
+}
@@ -0,0 +1,375 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// fanoutChannel is the Redis pub/sub channel every gateway replica listens
+// on. Each replica delivers what it receives to its own clients.
+const fanoutChannel = "ws:fanout"
+
+const (
+	userPresenceKey   = "ws:presence:user:"
+	clientPresenceKey = "ws:presence:client:"
+
+	// A user's stream is a counter holding the last seq handed out and a
+	// sorted set of recent messages scored by seq, stored as "seq:data".
+	streamSeqKey    = "ws:stream:seq:"
+	streamBufferKey = "ws:stream:buffer:"
+)
+
+// appendStreamScript assigns the next seq in a user's stream, buffers the
+// message, trimming the buffer to its size limit, and publishes it on the
+// channel ARGV[4]. ARGV[5] is the fan-out message as a JSON object, which
+// the seq is added to. Publishing in the script means concurrent senders'
+// messages reach every replica in seq order.
+var appendStreamScript = redis.NewScript(`
+local seq = redis.call('INCR', KEYS[1])
+redis.call('ZADD', KEYS[2], seq, seq .. ':' .. ARGV[1])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, -tonumber(ARGV[2]) - 1)
+redis.call('PEXPIRE', KEYS[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[2], ARGV[3])
+redis.call('PUBLISH', ARGV[4], '{"seq":' .. seq .. ',' .. string.sub(ARGV[5], 2))
+return seq
+`)
+
+// replayStreamScript returns {position, complete, messages}: the user's
+// current seq, whether every message after ARGV[1] is still buffered, and
+// those messages if so.
+var replayStreamScript = redis.NewScript(`
+local pos = tonumber(redis.call('GET', KEYS[1]) or '0')
+local after = tonumber(ARGV[1])
+if after > pos then
+	return {pos, 0, {}}
+end
+local oldest = pos + 1
+local first = redis.call('ZRANGE', KEYS[2], 0, 0, 'WITHSCORES')
+if #first > 0 then
+	oldest = tonumber(first[2])
+end
+if after + 1 < oldest then
+	return {pos, 0, {}}
+end
+return {pos, 1, redis.call('ZRANGEBYSCORE', KEYS[2], '(' .. after, '+inf')}
+`)
+
+// Fan-out message kinds.
+const (
+	fanoutBroadcast = "broadcast"
+	fanoutTopic     = "topic"
+	fanoutUser      = "user"
+	fanoutClient    = "client"
+)
+
+// fanoutMessage is published on fanoutChannel. Target is the topic, user ID
+// or client ID depending on Kind.
+type fanoutMessage struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+	Data   []byte `json:"data"`
+}
+
+// Presence is notified as clients connect to and disconnect from a hub.
+// Implementations are called from the hub goroutine and must not block.
+type Presence interface {
+	Connected(userID, clientID string)
+	Disconnected(userID, clientID string)
+}
+
+type presenceEvent struct {
+	userID    string
+	clientID  string
+	connected bool
+}
+
+// ClusterHub routes messages through Redis so they reach clients on every
+// gateway replica, and tracks which users are connected cluster-wide.
+//
+// Presence is kept per user in a sorted set of client IDs scored by expiry,
+// plus a key per client naming its user. Each replica refreshes its own
+// clients well within the TTL, so entries left by a crashed replica lapse
+// on their own.
+//
+// Messages sent to a user form that user's stream: each gets the next
+// sequence number and is kept in a bounded buffer, so a client that
+// reconnects to any replica can resume where it left off.
+type ClusterHub struct {
+	hub         *WebSocketHub
+	redis       *redis.Client
+	instanceID  string
+	presenceTTL time.Duration
+	replaySize  int
+	replayTTL   time.Duration
+	events      chan presenceEvent
+	logger      *zap.Logger
+}
+
+func NewClusterHub(hub *WebSocketHub, client *redis.Client, cfg config.WebSocketConfig, logger *zap.Logger) *ClusterHub {
+	c := &ClusterHub{
+		hub:         hub,
+		redis:       client,
+		instanceID:  newInstanceID(),
+		presenceTTL: cfg.PresenceTTL,
+		replaySize:  cfg.ReplayBufferSize,
+		replayTTL:   cfg.ReplayTTL,
+		events:      make(chan presenceEvent, 1024),
+		logger:      logger,
+	}
+	hub.presence = c
+	return c
+}
+
+// InstanceID identifies this replica.
+func (c *ClusterHub) InstanceID() string {
+	return c.instanceID
+}
+
+// Broadcast sends data to every client in the cluster.
+func (c *ClusterHub) Broadcast(ctx context.Context, data []byte) error {
+	return c.publish(ctx, fanoutMessage{Kind: fanoutBroadcast, Data: data})
+}
+
+// Publish sends data to every client in the cluster subscribed to topic.
+func (c *ClusterHub) Publish(ctx context.Context, topic string, data []byte) error {
+	return c.publish(ctx, fanoutMessage{Kind: fanoutTopic, Target: topic, Data: data})
+}
+
+// SendToUser appends data to userID's stream and sends it to all of the
+// user's connections in the cluster.
+func (c *ClusterHub) SendToUser(ctx context.Context, userID string, data []byte) error {
+	payload, err := json.Marshal(fanoutMessage{Kind: fanoutUser, Target: userID, Data: data})
+	if err != nil {
+		return err
+	}
+	err = appendStreamScript.Run(ctx, c.redis,
+		[]string{streamSeqKey + userID, streamBufferKey + userID},
+		data, c.replaySize, c.replayTTL.Milliseconds(), fanoutChannel, payload,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("append to stream: %w", err)
+	}
+	return nil
+}
+
+// SendToClient sends data to a single connection, wherever it is.
+func (c *ClusterHub) SendToClient(ctx context.Context, clientID string, data []byte) error {
+	return c.publish(ctx, fanoutMessage{Kind: fanoutClient, Target: clientID, Data: data})
+}
+
+func (c *ClusterHub) publish(ctx context.Context, msg fanoutMessage) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return c.redis.Publish(ctx, fanoutChannel, payload).Err()
+}
+
+// UserClients returns the IDs of userID's live connections across the
+// cluster. A user is online if the result is not empty.
+func (c *ClusterHub) UserClients(ctx context.Context, userID string) ([]string, error) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	ids, err := c.redis.ZRangeByScore(ctx, userPresenceKey+userID, &redis.ZRangeBy{
+		Min: "(" + now,
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// Replay returns userID's current stream position and the buffered
+// messages after seq resumeFrom. complete is false if some of them have
+// already been dropped from the buffer, or resumeFrom is ahead of the
+// stream, in which case the client needs a full refresh.
+func (c *ClusterHub) Replay(ctx context.Context, userID string, resumeFrom uint64) (position uint64, messages []StreamMessage, complete bool, err error) {
+	res, err := replayStreamScript.Run(ctx, c.redis,
+		[]string{streamSeqKey + userID, streamBufferKey + userID},
+		resumeFrom,
+	).Slice()
+	if err != nil {
+		return 0, nil, false, fmt.Errorf("replay stream: %w", err)
+	}
+	if len(res) != 3 {
+		return 0, nil, false, fmt.Errorf("replay stream: unexpected reply %v", res)
+	}
+
+	pos, _ := res[0].(int64)
+	ok, _ := res[1].(int64)
+	entries, _ := res[2].([]interface{})
+	for _, e := range entries {
+		member, _ := e.(string)
+		seqStr, data, found := strings.Cut(member, ":")
+		seq, err := strconv.ParseUint(seqStr, 10, 64)
+		if !found || err != nil {
+			return 0, nil, false, fmt.Errorf("replay stream: malformed entry %q", member)
+		}
+		messages = append(messages, StreamMessage{Seq: seq, Data: []byte(data)})
+	}
+	return uint64(pos), messages, ok == 1, nil
+}
+
+// Position returns userID's current stream seq.
+func (c *ClusterHub) Position(ctx context.Context, userID string) (uint64, error) {
+	pos, err := c.redis.Get(ctx, streamSeqKey+userID).Uint64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return pos, err
+}
+
+// ClientOnline reports whether the connection clientID is live anywhere in
+// the cluster.
+func (c *ClusterHub) ClientOnline(ctx context.Context, clientID string) (bool, error) {
+	n, err := c.redis.Exists(ctx, clientPresenceKey+clientID).Result()
+	return n > 0, err
+}
+
+// Connected implements Presence.
+func (c *ClusterHub) Connected(userID, clientID string) {
+	c.queuePresence(presenceEvent{userID: userID, clientID: clientID, connected: true})
+}
+
+// Disconnected implements Presence.
+func (c *ClusterHub) Disconnected(userID, clientID string) {
+	c.queuePresence(presenceEvent{userID: userID, clientID: clientID})
+}
+
+func (c *ClusterHub) queuePresence(ev presenceEvent) {
+	select {
+	case c.events <- ev:
+	default:
+		// The next heartbeat repairs anything dropped here.
+		c.logger.Warn("Presence update dropped", zap.String("client_id", ev.clientID))
+	}
+}
+
+// Run receives fan-out messages and maintains presence until ctx is
+// cancelled, then withdraws this replica's clients from presence.
+func (c *ClusterHub) Run(ctx context.Context) {
+	sub := c.redis.Subscribe(ctx, fanoutChannel)
+	defer sub.Close()
+	messages := sub.Channel()
+
+	heartbeat := time.NewTicker(c.presenceTTL / 3)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.withdraw()
+			return
+		case msg, ok := <-messages:
+			if !ok {
+				return
+			}
+			c.deliverLocal(msg.Payload)
+		case ev := <-c.events:
+			if err := c.updatePresence(ctx, ev); err != nil {
+				c.logger.Warn("Failed to update presence", zap.Error(err))
+			}
+		case <-heartbeat.C:
+			if err := c.refreshPresence(ctx); err != nil {
+				c.logger.Warn("Failed to refresh presence", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *ClusterHub) deliverLocal(payload string) {
+	var msg fanoutMessage
+	if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+		c.logger.Warn("Invalid fan-out message", zap.Error(err))
+		return
+	}
+
+	switch msg.Kind {
+	case fanoutBroadcast:
+		c.hub.Broadcast <- msg.Data
+	case fanoutTopic:
+		c.hub.Publish <- TopicMessage{Topic: msg.Target, Data: msg.Data}
+	case fanoutUser:
+		c.hub.SendToUser(msg.Target, msg.Seq, msg.Data)
+	case fanoutClient:
+		c.hub.SendToClient(msg.Target, msg.Data)
+	default:
+		c.logger.Warn("Unknown fan-out message kind", zap.String("kind", msg.Kind))
+	}
+}
+
+func (c *ClusterHub) updatePresence(ctx context.Context, ev presenceEvent) error {
+	if !ev.connected {
+		pipe := c.redis.TxPipeline()
+		pipe.ZRem(ctx, userPresenceKey+ev.userID, ev.clientID)
+		pipe.Del(ctx, clientPresenceKey+ev.clientID)
+		_, err := pipe.Exec(ctx)
+		return err
+	}
+	return c.markPresent(ctx, map[string]string{ev.clientID: ev.userID})
+}
+
+// refreshPresence renews the entries for every local client and prunes
+// expired ones.
+func (c *ClusterHub) refreshPresence(ctx context.Context) error {
+	clients := c.hub.localClients()
+	if len(clients) == 0 {
+		return nil
+	}
+	return c.markPresent(ctx, clients)
+}
+
+func (c *ClusterHub) markPresent(ctx context.Context, clients map[string]string) error {
+	now := time.Now()
+	expires := float64(now.Add(c.presenceTTL).Unix())
+	// The per-user set outlives its members slightly so it is never
+	// dropped while one of them is still live.
+	keyTTL := c.presenceTTL + c.presenceTTL/2
+
+	pipe := c.redis.Pipeline()
+	users := make(map[string]bool)
+	for clientID, userID := range clients {
+		key := userPresenceKey + userID
+		pipe.ZAdd(ctx, key, redis.Z{Score: expires, Member: clientID})
+		pipe.Set(ctx, clientPresenceKey+clientID, userID, c.presenceTTL)
+		if !users[userID] {
+			users[userID] = true
+			pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+			pipe.Expire(ctx, key, keyTTL)
+		}
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// withdraw removes this replica's clients from presence on shutdown.
+func (c *ClusterHub) withdraw() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := c.redis.Pipeline()
+	for clientID, userID := range c.hub.localClients() {
+		pipe.ZRem(ctx, userPresenceKey+userID, clientID)
+		pipe.Del(ctx, clientPresenceKey+clientID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		c.logger.Warn("Failed to withdraw presence", zap.Error(err))
+	}
+}
+
+func newInstanceID() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func newTestCluster(t *testing.T) (*ClusterHub, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	cfg := config.WebSocketConfig{PresenceTTL: time.Minute, ReplayBufferSize: 100, ReplayTTL: time.Hour}
+	return NewClusterHub(NewWebSocketHub(cfg, zap.NewNop()), client, cfg, zap.NewNop()), client
+}
+
+func TestClusterSendToOfflineUserIsReplayed(t *testing.T) {
+	cluster, _ := newTestCluster(t)
+	ctx := context.Background()
+
+	if err := cluster.SendToUser(ctx, "user-1", []byte(`{"type":"alert"}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	position, messages, complete, err := cluster.Replay(ctx, "user-1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if position != 1 || !complete || len(messages) != 1 {
+		t.Fatalf("got position %d, complete %v, %d messages, want 1, true, 1", position, complete, len(messages))
+	}
+	if m := messages[0]; m.Seq != 1 || string(m.Data) != `{"type":"alert"}` {
+		t.Errorf("replayed %d %s", m.Seq, m.Data)
+	}
+}
+
+// Each message is published by the script that numbers it, so concurrent
+// senders' messages arrive in seq order.
+func TestClusterSendToUserPublishesInSeqOrder(t *testing.T) {
+	cluster, client := newTestCluster(t)
+	ctx := context.Background()
+	sub := client.Subscribe(ctx, fanoutChannel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+	messages := sub.Channel()
+
+	const senders, each = 4, 25
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				data := []byte(fmt.Sprintf(`{"sender":%d,"n":%d}`, i, j))
+				if err := cluster.SendToUser(ctx, "user-1", data); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for want := uint64(1); want <= senders*each; want++ {
+		select {
+		case msg := <-messages:
+			var fm fanoutMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &fm); err != nil {
+				t.Fatal(err)
+			}
+			if fm.Kind != fanoutUser || fm.Target != "user-1" || fm.Seq != want {
+				t.Fatalf("got %s %s seq %d, want user user-1 seq %d", fm.Kind, fm.Target, fm.Seq, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for seq %d", want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Subprotocols a client may request in Sec-WebSocket-Protocol to choose how
+// messages are framed. Without one, each message is a JSON text frame.
+const (
+	// ProtocolNDJSON sends batches as newline-delimited JSON text frames.
+	ProtocolNDJSON = "ndjson"
+	// ProtocolJSONArray sends batches as JSON arrays in text frames.
+	ProtocolJSONArray = "json-array"
+	// ProtocolMsgPack sends batches as MessagePack arrays in binary frames,
+	// and also accepts MessagePack requests.
+	ProtocolMsgPack = "msgpack"
+)
+
+// Subprotocols lists the encodings the server supports, in order of
+// preference.
+var Subprotocols = []string{ProtocolMsgPack, ProtocolJSONArray, ProtocolNDJSON}
+
+type frame struct {
+	messageType int
+	data        []byte
+}
+
+// encodeFrames turns JSON messages into the frames for protocol, putting up
+// to maxBatch messages in each frame of the batching protocols.
+func encodeFrames(protocol string, messages [][]byte, maxBatch int) ([]frame, error) {
+	var frames []frame
+	for len(messages) > 0 {
+		n := len(messages)
+		if protocol == "" {
+			n = 1
+		} else if n > maxBatch {
+			n = maxBatch
+		}
+		batch := messages[:n]
+		messages = messages[n:]
+
+		switch protocol {
+		case ProtocolNDJSON:
+			frames = append(frames, frame{websocket.TextMessage, bytes.Join(batch, []byte{'\n'})})
+		case ProtocolJSONArray:
+			data := append([]byte{'['}, bytes.Join(batch, []byte{','})...)
+			frames = append(frames, frame{websocket.TextMessage, append(data, ']')})
+		case ProtocolMsgPack:
+			data, err := jsonToMsgPack(batch)
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, frame{websocket.BinaryMessage, data})
+		default:
+			frames = append(frames, frame{websocket.TextMessage, batch[0]})
+		}
+	}
+	return frames, nil
+}
+
+// jsonToMsgPack re-encodes a batch of JSON messages as a MessagePack array.
+func jsonToMsgPack(batch [][]byte) ([]byte, error) {
+	values := make([]interface{}, len(batch))
+	for i, raw := range batch {
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			return nil, fmt.Errorf("decode message: %w", err)
+		}
+		values[i] = msgpackValue(v)
+	}
+	return msgpack.Marshal(values)
+}
+
+// msgpackValue converts json.Number so integers, such as seq, stay integers.
+func msgpackValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = msgpackValue(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = msgpackValue(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// decodeClientMessage reads a request in JSON, or MessagePack when it
+// arrives in a binary frame.
+func decodeClientMessage(messageType int, data []byte, msg *clientMessage) error {
+	if messageType == websocket.BinaryMessage {
+		dec := msgpack.NewDecoder(bytes.NewReader(data))
+		dec.SetCustomStructTag("json")
+		return dec.Decode(msg)
+	}
+	return json.Unmarshal(data, msg)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func TestEncodeFrames(t *testing.T) {
+	messages := [][]byte{[]byte(`{"seq":1}`), []byte(`{"seq":2}`), []byte(`{"seq":3}`)}
+
+	tests := []struct {
+		protocol string
+		want     []string
+	}{
+		{protocol: "", want: []string{`{"seq":1}`, `{"seq":2}`, `{"seq":3}`}},
+		{protocol: ProtocolNDJSON, want: []string{"{\"seq\":1}\n{\"seq\":2}", `{"seq":3}`}},
+		{protocol: ProtocolJSONArray, want: []string{`[{"seq":1},{"seq":2}]`, `[{"seq":3}]`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.protocol, func(t *testing.T) {
+			frames, err := encodeFrames(tt.protocol, messages, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, f := range frames {
+				if f.messageType != websocket.TextMessage {
+					t.Errorf("frame type %d, want text", f.messageType)
+				}
+				got = append(got, string(f.data))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("frames %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeFramesMsgPack(t *testing.T) {
+	messages := [][]byte{
+		[]byte(`{"v":1,"seq":9007199254740993,"type":"price","data":{"symbol":"AAPL","price":189.5,"volume":1200,"tags":["a",null,true]}}`),
+		[]byte(`{"v":1,"seq":2,"type":"notification"}`),
+	}
+	frames, err := encodeFrames(ProtocolMsgPack, messages, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 1 || frames[0].messageType != websocket.BinaryMessage {
+		t.Fatalf("got %d frames, want one binary frame", len(frames))
+	}
+
+	// Integers decode as int64 and floats as float64, so a float that
+	// came out as an integer, or the reverse, would show.
+	dec := msgpack.NewDecoder(bytes.NewReader(frames[0].data))
+	dec.UseLooseInterfaceDecoding(true)
+	var got []map[string]interface{}
+	if err := dec.Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{
+			"v":    int64(1),
+			"seq":  int64(9007199254740993),
+			"type": "price",
+			"data": map[string]interface{}{
+				"symbol": "AAPL",
+				"price":  189.5,
+				"volume": int64(1200),
+				"tags":   []interface{}{"a", nil, true},
+			},
+		},
+		{"v": int64(1), "seq": int64(2), "type": "notification"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %#v, want %#v", got, want)
+	}
+}
+
+func TestEncodeFramesMsgPackRejectsInvalidJSON(t *testing.T) {
+	if _, err := encodeFrames(ProtocolMsgPack, [][]byte{[]byte(`{"seq":`)}, 10); err == nil {
+		t.Error("encoded invalid JSON")
+	}
+}
+
+func TestDecodeClientMessage(t *testing.T) {
+	want := clientMessage{Type: "subscribe", Symbols: []string{"AAPL", "MSFT"}}
+	packed, err := msgpack.Marshal(map[string]interface{}{"type": "subscribe", "symbols": []string{"AAPL", "MSFT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		messageType int
+		data        []byte
+	}{
+		{name: "JSON", messageType: websocket.TextMessage, data: []byte(`{"type":"subscribe","symbols":["AAPL","MSFT"]}`)},
+		{name: "MessagePack", messageType: websocket.BinaryMessage, data: packed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got clientMessage
+			if err := decodeClientMessage(tt.messageType, tt.data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %+v, want %+v", got, want)
+			}
+		})
+	}
+}
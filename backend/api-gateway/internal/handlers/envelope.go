@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+)
+
+// ProtocolVersion is sent as "v" in every message envelope.
+const ProtocolVersion = 1
+
+// outbound is a message queued for a client. seq is its position in the
+// user's stream, or 0 for messages that are not replayable (ticks, replies
+// and broadcasts). topic is set on topic messages, which may be conflated;
+// critical messages are never dropped for a slow client.
+type outbound struct {
+	data     []byte
+	seq      uint64
+	topic    string
+	critical bool
+}
+
+// StreamMessage is a replayable message from a user's stream.
+type StreamMessage struct {
+	Seq  uint64
+	Data []byte
+}
+
+// stamp adds the envelope fields to a JSON object message:
+//
+//	{"type":"notification","data":{...}}
+//
+// becomes
+//
+//	{"v":1,"seq":42,"type":"notification","data":{...}}
+//
+// seq is the last stream position the client has been sent, so the client
+// can pass it back as resume_from when it reconnects. Any "v" or "seq" the
+// message already has is dropped, so the envelope's are the only ones.
+// Messages that are not objects are wrapped as {"v":1,"seq":42,"data":...}.
+func stamp(data []byte, seq uint64) []byte {
+	trimmed := withoutEnvelopeKeys(bytes.TrimSpace(data))
+
+	buf := make([]byte, 0, len(trimmed)+32)
+	buf = append(buf, `{"v":`...)
+	buf = strconv.AppendInt(buf, ProtocolVersion, 10)
+	buf = append(buf, `,"seq":`...)
+	buf = strconv.AppendUint(buf, seq, 10)
+
+	if len(trimmed) >= 2 && trimmed[0] == '{' {
+		body := bytes.TrimSpace(trimmed[1:])
+		if len(body) > 0 && body[0] != '}' {
+			buf = append(buf, ',')
+		}
+		return append(buf, body...)
+	}
+
+	buf = append(buf, `,"data":`...)
+	buf = append(buf, trimmed...)
+	return append(buf, '}')
+}
+
+// withoutEnvelopeKeys removes the top-level "v" and "seq" fields from a
+// JSON object. Most messages have neither, and are returned as they are.
+func withoutEnvelopeKeys(data []byte) []byte {
+	if len(data) == 0 || data[0] != '{' ||
+		!bytes.Contains(data, []byte(`"v"`)) && !bytes.Contains(data, []byte(`"seq"`)) {
+		return data
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(data, &fields) != nil {
+		return data
+	}
+	_, hasV := fields["v"]
+	_, hasSeq := fields["seq"]
+	if !hasV && !hasSeq {
+		return data
+	}
+	delete(fields, "v")
+	delete(fields, "seq")
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return data
+	}
+	return out
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import "testing"
+
+func TestStamp(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{name: "object", data: `{"type":"notification","data":{"id":1}}`, want: `{"v":1,"seq":42,"type":"notification","data":{"id":1}}`},
+		{name: "empty object", data: ` {} `, want: `{"v":1,"seq":42}`},
+		{name: "not an object", data: `[1,2]`, want: `{"v":1,"seq":42,"data":[1,2]}`},
+		{name: "nested seq is kept", data: `{"type":"t","data":{"seq":7}}`, want: `{"v":1,"seq":42,"type":"t","data":{"seq":7}}`},
+		{name: "seq and v are replaced", data: `{"seq":7,"type":"t","v":9}`, want: `{"v":1,"seq":42,"type":"t"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(stamp([]byte(tt.data), 42)); got != tt.want {
+				t.Errorf("stamp(%s) = %s, want %s", tt.data, got, tt.want)
+			}
+		})
+	}
+}
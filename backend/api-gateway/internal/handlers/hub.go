@@ -1,24 +1,119 @@
 package handlers
 
 import (
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
 )
 
+// maxSubscriptionsPerClient caps the symbols one connection can follow.
+const maxSubscriptionsPerClient = 50
+
+// TopicMessage is delivered only to clients subscribed to Topic, usually a
+// ticker symbol.
+type TopicMessage struct {
+	Topic string
+	Data  []byte
+}
+
+type subscriptionRequest struct {
+	client    *Client
+	symbols   []string
+	subscribe bool
+}
+
+type clientReply struct {
+	client *Client
+	data   []byte
+}
+
+// directMessage targets every connection of userID, or the single connection
+// clientID when that is set. seq is the message's position in the user's
+// stream, if it has one. The number of connections the message was queued
+// for is sent on delivered.
+type directMessage struct {
+	userID    string
+	clientID  string
+	seq       uint64
+	data      []byte
+	delivered chan int
+}
+
+type query struct {
+	fn   func()
+	done chan struct{}
+}
+
+// HubStats is a snapshot of the hub's connections.
+type HubStats struct {
+	Connections int `json:"connections"`
+	Users       int `json:"users"`
+	Topics      int `json:"topics"`
+	// ConnectionsPerUser maps a connection count to the number of users
+	// holding that many connections.
+	ConnectionsPerUser map[int]int `json:"connections_per_user"`
+	// MaxUserConnections is the most connections any one user holds.
+	MaxUserConnections int `json:"max_user_connections"`
+	// RejectedConnections counts sockets refused by the per-user limit.
+	RejectedConnections uint64 `json:"rejected_connections"`
+
+	// QueueDepth is the number of messages waiting across all clients and
+	// MaxQueueDepth the longest single client queue.
+	QueueDepth    int `json:"queue_depth"`
+	MaxQueueDepth int `json:"max_queue_depth"`
+	// ConflatedMessages counts topic messages replaced by a newer one
+	// before a slow client received them; DroppedMessages counts
+	// best-effort messages discarded for lagging clients.
+	ConflatedMessages uint64 `json:"conflated_messages"`
+	DroppedMessages   uint64 `json:"dropped_messages"`
+	// SlowDisconnects counts clients disconnected for lagging too long.
+	SlowDisconnects uint64 `json:"slow_disconnects"`
+}
+
 type WebSocketHub struct {
 	clients    map[*Client]bool
+	byID       map[string]*Client
+	users      map[string]map[*Client]bool
+	topics     map[string]map[*Client]bool
 	Broadcast  chan []byte
+	Publish    chan TopicMessage
 	Register   chan *Client
 	Unregister chan *Client
-	logger     *zap.Logger
+
+	subscriptions chan subscriptionRequest
+	replies       chan clientReply
+	direct        chan directMessage
+	queries       chan query
+
+	// presence, if set, is told about every client that joins or leaves.
+	presence Presence
+
+	maxConnectionsPerUser int
+	cfg                   config.WebSocketConfig
+	rejected              uint64
+	conflated             uint64
+	dropped               uint64
+	slowDisconnects       uint64
+	logger                *zap.Logger
 }
 
-func NewWebSocketHub(logger *zap.Logger) *WebSocketHub {
+func NewWebSocketHub(cfg config.WebSocketConfig, logger *zap.Logger) *WebSocketHub {
 	return &WebSocketHub{
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		clients:    make(map[*Client]bool),
-		logger:     logger,
+		Broadcast:             make(chan []byte),
+		Publish:               make(chan TopicMessage),
+		Register:              make(chan *Client),
+		Unregister:            make(chan *Client),
+		clients:               make(map[*Client]bool),
+		byID:                  make(map[string]*Client),
+		users:                 make(map[string]map[*Client]bool),
+		topics:                make(map[string]map[*Client]bool),
+		subscriptions:         make(chan subscriptionRequest),
+		replies:               make(chan clientReply),
+		direct:                make(chan directMessage),
+		queries:               make(chan query),
+		maxConnectionsPerUser: cfg.MaxConnectionsPerUser,
+		cfg:                   cfg,
+		logger:                logger,
 	}
 }
 
@@ -26,23 +121,283 @@ func (h *WebSocketHub) Run() {
 	for {
 		select {
 		case client := <-h.Register:
-			h.clients[client] = true
-			h.logger.Info("Client registered", zap.String("user_id", client.userID))
+			h.register(client)
 		case client := <-h.Unregister:
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.send)
-				h.logger.Info("Client unregistered", zap.String("user_id", client.userID))
+				h.removeClient(client)
+				h.logger.Info("Client unregistered",
+					zap.String("user_id", client.userID),
+					zap.String("client_id", client.id),
+				)
 			}
 		case message := <-h.Broadcast:
 			for client := range h.clients {
-				select {
-				case client.send <- message:
-				default:
-					close(client.send)
-					delete(h.clients, client)
-				}
+				h.enqueue(client, outbound{data: message})
+			}
+		case message := <-h.Publish:
+			for client := range h.topics[message.Topic] {
+				h.enqueue(client, outbound{data: message.Data, topic: message.Topic})
+			}
+		case message := <-h.direct:
+			message.delivered <- h.deliverDirect(message)
+		case req := <-h.subscriptions:
+			if h.clients[req.client] {
+				h.updateSubscriptions(req)
+			}
+		case reply := <-h.replies:
+			h.deliver(reply.client, reply.data)
+		case q := <-h.queries:
+			q.fn()
+			close(q.done)
+		}
+	}
+}
+
+// SendToUser queues data for every connection userID has open and returns
+// how many connections it was queued for. seq is the message's position in
+// the user's stream, or 0 if it is not replayable.
+func (h *WebSocketHub) SendToUser(userID string, seq uint64, data []byte) int {
+	delivered := make(chan int, 1)
+	h.direct <- directMessage{userID: userID, seq: seq, data: data, delivered: delivered}
+	return <-delivered
+}
+
+// SendToClient queues data for the connection with the given ID. It reports
+// false if no such connection is open.
+func (h *WebSocketHub) SendToClient(clientID string, data []byte) bool {
+	delivered := make(chan int, 1)
+	h.direct <- directMessage{clientID: clientID, data: data, delivered: delivered}
+	return <-delivered > 0
+}
+
+// UserClients returns the IDs of userID's open connections.
+func (h *WebSocketHub) UserClients(userID string) []string {
+	ids := []string{}
+	h.query(func() {
+		for client := range h.users[userID] {
+			ids = append(ids, client.id)
+		}
+	})
+	return ids
+}
+
+// localClients maps the IDs of this hub's clients to their users.
+func (h *WebSocketHub) localClients() map[string]string {
+	clients := make(map[string]string)
+	h.query(func() {
+		for id, client := range h.byID {
+			clients[id] = client.userID
+		}
+	})
+	return clients
+}
+
+func (h *WebSocketHub) Stats() HubStats {
+	var stats HubStats
+	h.query(func() {
+		stats = HubStats{
+			Connections:         len(h.clients),
+			Users:               len(h.users),
+			Topics:              len(h.topics),
+			ConnectionsPerUser:  make(map[int]int),
+			RejectedConnections: h.rejected,
+			ConflatedMessages:   h.conflated,
+			DroppedMessages:     h.dropped,
+			SlowDisconnects:     h.slowDisconnects,
+		}
+		for client := range h.clients {
+			depth := client.queue.len()
+			stats.QueueDepth += depth
+			if depth > stats.MaxQueueDepth {
+				stats.MaxQueueDepth = depth
+			}
+		}
+		for _, clients := range h.users {
+			n := len(clients)
+			stats.ConnectionsPerUser[n]++
+			if n > stats.MaxUserConnections {
+				stats.MaxUserConnections = n
 			}
 		}
+	})
+	return stats
+}
+
+// query runs fn on the hub goroutine, which owns all hub state.
+func (h *WebSocketHub) query(fn func()) {
+	done := make(chan struct{})
+	h.queries <- query{fn: fn, done: done}
+	<-done
+}
+
+func (h *WebSocketHub) register(client *Client) {
+	conns := h.users[client.userID]
+	if h.maxConnectionsPerUser > 0 && len(conns) >= h.maxConnectionsPerUser {
+		h.rejected++
+		h.logger.Warn("Client rejected, too many connections",
+			zap.String("user_id", client.userID),
+			zap.Int("connections", len(conns)),
+		)
+		client.queue.close(websocket.FormatCloseMessage(CloseTooManyConnections, "too many connections"))
+		return
+	}
+
+	if conns == nil {
+		conns = make(map[*Client]bool)
+		h.users[client.userID] = conns
+	}
+	conns[client] = true
+	h.clients[client] = true
+	h.byID[client.id] = client
+	if h.presence != nil {
+		h.presence.Connected(client.userID, client.id)
+	}
+
+	h.logger.Info("Client registered",
+		zap.String("user_id", client.userID),
+		zap.String("client_id", client.id),
+		zap.Int("user_connections", len(conns)),
+	)
+
+	// Tell the client its ID so other services can target this connection.
+	h.deliver(client, encodeServerMessage(serverMessage{
+		Type:     MessageTypeConnected,
+		ClientID: client.id,
+	}))
+}
+
+func (h *WebSocketHub) deliverDirect(message directMessage) int {
+	if message.clientID != "" {
+		client, ok := h.byID[message.clientID]
+		if !ok || (message.userID != "" && client.userID != message.userID) {
+			return 0
+		}
+		if h.deliver(client, message.data) {
+			return 1
+		}
+		return 0
+	}
+
+	n := 0
+	for client := range h.users[message.userID] {
+		if h.enqueue(client, outbound{data: message.data, seq: message.seq, critical: true}) {
+			n++
+		}
+	}
+	return n
+}
+
+// deliver queues a reply or control message for one client. These are not
+// part of the user's stream but are never dropped.
+func (h *WebSocketHub) deliver(client *Client, message []byte) bool {
+	return h.enqueue(client, outbound{data: message, critical: true})
+}
+
+// enqueue offers a message to a client's send queue, disconnecting the
+// client if it has lagged for too long. It reports whether the message will
+// be delivered.
+func (h *WebSocketHub) enqueue(client *Client, message outbound) bool {
+	if !h.clients[client] {
+		return false
+	}
+
+	switch client.queue.push(message) {
+	case pushQueued:
+		return true
+	case pushConflated:
+		h.conflated++
+		return true
+	case pushDropped:
+		h.dropped++
+		return false
+	default:
+		h.slowDisconnects++
+		h.logger.Warn("Disconnecting slow client",
+			zap.String("user_id", client.userID),
+			zap.String("client_id", client.id),
+			zap.Int("queue_depth", client.queue.len()),
+		)
+		h.closeClient(client, websocket.FormatCloseMessage(CloseTooSlow, "client too slow"))
+		return false
+	}
+}
+
+func (h *WebSocketHub) removeClient(client *Client) {
+	h.closeClient(client, nil)
+}
+
+// closeClient removes a client from the hub and has its WritePump send
+// closeMessage.
+func (h *WebSocketHub) closeClient(client *Client, closeMessage []byte) {
+	for symbol := range client.subscriptions {
+		h.unsubscribe(client, symbol)
+	}
+	if conns, ok := h.users[client.userID]; ok {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.users, client.userID)
+		}
+	}
+	delete(h.byID, client.id)
+	delete(h.clients, client)
+	client.queue.close(closeMessage)
+
+	if h.presence != nil {
+		h.presence.Disconnected(client.userID, client.id)
+	}
+}
+
+func (h *WebSocketHub) updateSubscriptions(req subscriptionRequest) {
+	client := req.client
+
+	if !req.subscribe {
+		for _, symbol := range req.symbols {
+			h.unsubscribe(client, symbol)
+		}
+		h.deliver(client, encodeServerMessage(serverMessage{
+			Type:    MessageTypeUnsubscribed,
+			Symbols: req.symbols,
+		}))
+		return
+	}
+
+	var added, rejected []string
+	for _, symbol := range req.symbols {
+		if client.subscriptions[symbol] {
+			added = append(added, symbol)
+			continue
+		}
+		if len(client.subscriptions) >= maxSubscriptionsPerClient {
+			rejected = append(rejected, symbol)
+			continue
+		}
+
+		client.subscriptions[symbol] = true
+		if h.topics[symbol] == nil {
+			h.topics[symbol] = make(map[*Client]bool)
+		}
+		h.topics[symbol][client] = true
+		added = append(added, symbol)
+	}
+
+	if len(added) > 0 {
+		h.deliver(client, encodeServerMessage(serverMessage{
+			Type:    MessageTypeSubscribed,
+			Symbols: added,
+		}))
+	}
+	if len(rejected) > 0 {
+		h.deliver(client, errorMessage(ErrCodeSubscriptionLimit,
+			"Subscription limit reached", rejected))
+	}
+}
+
+func (h *WebSocketHub) unsubscribe(client *Client, symbol string) {
+	delete(client.subscriptions, symbol)
+	if subscribers, ok := h.topics[symbol]; ok {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(h.topics, symbol)
+		}
 	}
 }
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gorilla/websocket"
+	"go.uber.org/zap"
+)
+
+// received is a message a test client read, with its envelope.
+type received struct {
+	Seq      uint64   `json:"seq"`
+	Type     string   `json:"type"`
+	Symbols  []string `json:"symbols"`
+	ClientID string   `json:"client_id"`
+	Code     string   `json:"code"`
+	Price    float64  `json:"price"`
+}
+
+// testHub runs a hub behind a WebSocket server that connects clients the
+// way the gateway does.
+type testHub struct {
+	*WebSocketHub
+	server *httptest.Server
+}
+
+func newTestHub(t *testing.T, cfg config.WebSocketConfig) *testHub {
+	t.Helper()
+	cfg.LagThreshold = max(cfg.LagThreshold, 100)
+	cfg.MaxQueue = max(cfg.MaxQueue, 1000)
+	cfg.MaxLag = max(cfg.MaxLag, time.Minute)
+	cfg.MaxBatch = max(cfg.MaxBatch, 10)
+	hub := NewWebSocketHub(cfg, zap.NewNop())
+	go hub.Run()
+
+	upgrader := websocket.Upgrader{Subprotocols: Subprotocols}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		var expiresAt time.Time
+		if exp := r.URL.Query().Get("expires_in"); exp != "" {
+			d, _ := time.ParseDuration(exp)
+			expiresAt = time.Now().Add(d)
+		}
+		client := NewClient(conn, r.URL.Query().Get("user"), expiresAt, hub)
+		hub.Register <- client
+		go client.ReadPump()
+		go client.WritePump()
+	}))
+	t.Cleanup(server.Close)
+	return &testHub{WebSocketHub: hub, server: server}
+}
+
+// connect opens a connection as userID with the given query, e.g.
+// "expires_in=1s".
+func (h *testHub) connect(t *testing.T, userID, query string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(h.server.URL, "http") + "/?user=" + userID
+	if query != "" {
+		url += "&" + query
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// connectClient opens a connection and reads its "connected" message.
+func (h *testHub) connectClient(t *testing.T, userID string) (*websocket.Conn, string) {
+	t.Helper()
+	conn := h.connect(t, userID, "")
+	msg := read(t, conn)
+	if msg.Type != MessageTypeConnected || msg.ClientID == "" {
+		t.Fatalf("first message %+v, want connected", msg)
+	}
+	return conn, msg.ClientID
+}
+
+func read(t *testing.T, conn *websocket.Conn) received {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg received
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("%s: %v", data, err)
+	}
+	return msg
+}
+
+func send(t *testing.T, conn *websocket.Conn, msg clientMessage) {
+	t.Helper()
+	if err := conn.WriteJSON(msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectNothingQueued checks that conn has nothing waiting before the
+// reply to a ping, which the hub queues after anything sent earlier.
+func expectNothingQueued(t *testing.T, conn *websocket.Conn) {
+	t.Helper()
+	send(t, conn, clientMessage{Type: MessageTypePing})
+	if msg := read(t, conn); msg.Type != MessageTypePong {
+		t.Fatalf("got %+v, want nothing before the pong", msg)
+	}
+}
+
+func TestNormalizeSymbols(t *testing.T) {
+	valid, invalid := normalizeSymbols([]string{" aapl", "AAPL", "brk.b", "^gspc", "eurusd=x", "", "../etc", "A B", strings.Repeat("X", 21)})
+	if want := []string{"AAPL", "BRK.B", "EURUSD=X"}; !slices.Equal(valid, want) {
+		t.Errorf("valid %q, want %q", valid, want)
+	}
+	if want := []string{"^GSPC", "", "../ETC", "A B", strings.Repeat("X", 21)}; !slices.Equal(invalid, want) {
+		t.Errorf("invalid %q, want %q", invalid, want)
+	}
+}
+
+func TestHubTopicRouting(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	apple, _ := hub.connectClient(t, "user-1")
+	microsoft, _ := hub.connectClient(t, "user-2")
+
+	send(t, apple, clientMessage{Type: MessageTypeSubscribe, Symbols: []string{"aapl", "AAPL "}})
+	if msg := read(t, apple); msg.Type != MessageTypeSubscribed || !slices.Equal(msg.Symbols, []string{"AAPL"}) {
+		t.Fatalf("got %+v, want subscribed to AAPL", msg)
+	}
+	send(t, microsoft, clientMessage{Type: MessageTypeSubscribe, Symbols: []string{"MSFT"}})
+	if msg := read(t, microsoft); msg.Type != MessageTypeSubscribed {
+		t.Fatalf("got %+v, want subscribed", msg)
+	}
+
+	hub.Publish <- TopicMessage{Topic: "AAPL", Data: []byte(`{"type":"price","price":189.5}`)}
+	if msg := read(t, apple); msg.Type != "price" || msg.Price != 189.5 {
+		t.Fatalf("subscriber got %+v, want the AAPL price", msg)
+	}
+	expectNothingQueued(t, microsoft)
+
+	send(t, apple, clientMessage{Type: MessageTypeUnsubscribe, Symbols: []string{"AAPL"}})
+	if msg := read(t, apple); msg.Type != MessageTypeUnsubscribed {
+		t.Fatalf("got %+v, want unsubscribed", msg)
+	}
+	hub.Publish <- TopicMessage{Topic: "AAPL", Data: []byte(`{"type":"price","price":190}`)}
+	expectNothingQueued(t, apple)
+
+	if stats := hub.Stats(); stats.Topics != 1 {
+		t.Errorf("%d topics, want only MSFT left", stats.Topics)
+	}
+}
+
+func TestHubRejectsBadSubscriptions(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	conn, _ := hub.connectClient(t, "user-1")
+
+	tests := []struct {
+		name     string
+		msg      string
+		wantType string
+		wantCode string
+	}{
+		{name: "not JSON", msg: `subscribe AAPL`, wantType: MessageTypeError, wantCode: ErrCodeInvalidMessage},
+		{name: "no symbols", msg: `{"type":"subscribe"}`, wantType: MessageTypeError, wantCode: ErrCodeInvalidMessage},
+		{name: "invalid symbol", msg: `{"type":"subscribe","symbols":["$$$"]}`, wantType: MessageTypeError, wantCode: ErrCodeInvalidSymbol},
+		{name: "unknown type", msg: `{"type":"trade"}`, wantType: MessageTypeError, wantCode: ErrCodeUnknownType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(tt.msg)); err != nil {
+				t.Fatal(err)
+			}
+			if msg := read(t, conn); msg.Type != tt.wantType || msg.Code != tt.wantCode {
+				t.Errorf("got %+v, want %s %s", msg, tt.wantType, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestHubSubscriptionLimit(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	conn, _ := hub.connectClient(t, "user-1")
+
+	symbols := make([]string, maxSubscriptionsPerClient+2)
+	for i := range symbols {
+		symbols[i] = "S" + strings.Repeat("X", i%10) + string(rune('A'+i/10))
+	}
+	send(t, conn, clientMessage{Type: MessageTypeSubscribe, Symbols: symbols})
+
+	if msg := read(t, conn); msg.Type != MessageTypeSubscribed || len(msg.Symbols) != maxSubscriptionsPerClient {
+		t.Fatalf("got %s with %d symbols, want %d subscribed", msg.Type, len(msg.Symbols), maxSubscriptionsPerClient)
+	}
+	msg := read(t, conn)
+	if msg.Code != ErrCodeSubscriptionLimit || !slices.Equal(msg.Symbols, symbols[maxSubscriptionsPerClient:]) {
+		t.Errorf("got %+v, want the last 2 symbols rejected", msg)
+	}
+}
+
+func TestHubSendToUser(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	phone, _ := hub.connectClient(t, "user-1")
+	laptop, _ := hub.connectClient(t, "user-1")
+	other, _ := hub.connectClient(t, "user-2")
+
+	if n := hub.SendToUser("user-1", 7, []byte(`{"type":"alert"}`)); n != 2 {
+		t.Fatalf("queued for %d connections, want 2", n)
+	}
+	for _, conn := range []*websocket.Conn{phone, laptop} {
+		if msg := read(t, conn); msg.Type != "alert" || msg.Seq != 7 {
+			t.Errorf("got %+v, want the alert as seq 7", msg)
+		}
+	}
+	expectNothingQueued(t, other)
+
+	if n := hub.SendToUser("user-3", 8, []byte(`{"type":"alert"}`)); n != 0 {
+		t.Errorf("queued for %d connections of an offline user", n)
+	}
+}
+
+func TestHubSendToClient(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{})
+	phone, phoneID := hub.connectClient(t, "user-1")
+	laptop, _ := hub.connectClient(t, "user-1")
+
+	if !hub.SendToClient(phoneID, []byte(`{"type":"alert"}`)) {
+		t.Fatal("not delivered to an open connection")
+	}
+	if msg := read(t, phone); msg.Type != "alert" {
+		t.Errorf("got %+v, want the alert", msg)
+	}
+	expectNothingQueued(t, laptop)
+
+	if hub.SendToClient("no-such-client", []byte(`{"type":"alert"}`)) {
+		t.Error("delivered to an unknown connection")
+	}
+	if ids := hub.UserClients("user-1"); len(ids) != 2 || !slices.Contains(ids, phoneID) {
+		t.Errorf("user-1 clients %q", ids)
+	}
+}
+
+func TestHubConnectionLimit(t *testing.T) {
+	hub := newTestHub(t, config.WebSocketConfig{MaxConnectionsPerUser: 2})
+	first, firstID := hub.connectClient(t, "user-1")
+	hub.connectClient(t, "user-1")
+	hub.connectClient(t, "user-2")
+
+	third := hub.connect(t, "user-1", "")
+	_ = third.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := third.ReadMessage(); !websocket.IsCloseError(err, CloseTooManyConnections) {
+		t.Fatalf("got %v, want close %d", err, CloseTooManyConnections)
+	}
+
+	stats := hub.Stats()
+	if stats.Connections != 3 || stats.Users != 2 || stats.RejectedConnections != 1 || stats.MaxUserConnections != 2 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.ConnectionsPerUser[2] != 1 || stats.ConnectionsPerUser[1] != 1 {
+		t.Errorf("connections per user %v, want one user with 2 and one with 1", stats.ConnectionsPerUser)
+	}
+
+	// A closed connection frees its slot.
+	first.Close()
+	for slices.Contains(hub.UserClients("user-1"), firstID) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	hub.connectClient(t, "user-1")
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	wsConnectionsDesc = prometheus.NewDesc("websocket_connections",
+		"Open WebSocket connections on this replica.", nil, nil)
+	wsUsersDesc = prometheus.NewDesc("websocket_users",
+		"Users with at least one WebSocket connection on this replica.", nil, nil)
+	wsTopicsDesc = prometheus.NewDesc("websocket_topics",
+		"Topics with at least one subscriber on this replica.", nil, nil)
+	wsQueueDepthDesc = prometheus.NewDesc("websocket_queue_depth",
+		"Messages waiting in all client send queues.", nil, nil)
+	wsMaxQueueDepthDesc = prometheus.NewDesc("websocket_max_queue_depth",
+		"Messages waiting in the longest client send queue.", nil, nil)
+	wsRejectedDesc = prometheus.NewDesc("websocket_rejected_connections_total",
+		"Connections refused by the per-user limit.", nil, nil)
+	wsConflatedDesc = prometheus.NewDesc("websocket_conflated_messages_total",
+		"Topic messages replaced by a newer one before a slow client received them.", nil, nil)
+	wsDroppedDesc = prometheus.NewDesc("websocket_dropped_messages_total",
+		"Best-effort messages discarded for lagging clients.", nil, nil)
+	wsSlowDisconnectsDesc = prometheus.NewDesc("websocket_slow_disconnects_total",
+		"Clients disconnected for lagging too long.", nil, nil)
+)
+
+// hubCollector exports HubStats, read from the hub on each scrape.
+type hubCollector struct {
+	hub *WebSocketHub
+}
+
+// NewHubCollector returns a Prometheus collector for the hub's connection
+// and queue statistics.
+func NewHubCollector(hub *WebSocketHub) prometheus.Collector {
+	return hubCollector{hub: hub}
+}
+
+func (c hubCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- wsConnectionsDesc
+	ch <- wsUsersDesc
+	ch <- wsTopicsDesc
+	ch <- wsQueueDepthDesc
+	ch <- wsMaxQueueDepthDesc
+	ch <- wsRejectedDesc
+	ch <- wsConflatedDesc
+	ch <- wsDroppedDesc
+	ch <- wsSlowDisconnectsDesc
+}
+
+func (c hubCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.hub.Stats()
+	gauge := func(desc *prometheus.Desc, v int) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v))
+	}
+	counter := func(desc *prometheus.Desc, v uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v))
+	}
+	gauge(wsConnectionsDesc, stats.Connections)
+	gauge(wsUsersDesc, stats.Users)
+	gauge(wsTopicsDesc, stats.Topics)
+	gauge(wsQueueDepthDesc, stats.QueueDepth)
+	gauge(wsMaxQueueDepthDesc, stats.MaxQueueDepth)
+	counter(wsRejectedDesc, stats.RejectedConnections)
+	counter(wsConflatedDesc, stats.ConflatedMessages)
+	counter(wsDroppedDesc, stats.DroppedMessages)
+	counter(wsSlowDisconnectsDesc, stats.SlowDisconnects)
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// Message types exchanged with WebSocket clients.
+const (
+	MessageTypeSubscribe    = "subscribe"
+	MessageTypeUnsubscribe  = "unsubscribe"
+	MessageTypePing         = "ping"
+	MessageTypeConnected    = "connected"
+	MessageTypeResync       = "resync"
+	MessageTypePong         = "pong"
+	MessageTypeSubscribed   = "subscribed"
+	MessageTypeUnsubscribed = "unsubscribed"
+	MessageTypeError        = "error"
+)
+
+// Error codes sent in "error" messages.
+const (
+	ErrCodeInvalidMessage    = "invalid_message"
+	ErrCodeUnknownType       = "unknown_type"
+	ErrCodeInvalidSymbol     = "invalid_symbol"
+	ErrCodeSubscriptionLimit = "subscription_limit"
+)
+
+var symbolPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9.\-:^=]{0,19}$`)
+
+// clientMessage is a request sent by a client, e.g.
+// {"type":"subscribe","symbols":["AAPL","GOOGL"]}.
+type clientMessage struct {
+	Type    string   `json:"type"`
+	Symbols []string `json:"symbols,omitempty"`
+}
+
+// serverMessage is a reply to a clientMessage.
+type serverMessage struct {
+	Type     string   `json:"type"`
+	Symbols  []string `json:"symbols,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Code     string   `json:"code,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+func encodeServerMessage(msg serverMessage) []byte {
+	data, _ := json.Marshal(msg)
+	return data
+}
+
+func errorMessage(code, message string, symbols []string) []byte {
+	return encodeServerMessage(serverMessage{
+		Type:    MessageTypeError,
+		Code:    code,
+		Message: message,
+		Symbols: symbols,
+	})
+}
+
+// normalizeSymbols upper-cases and de-duplicates symbols, returning the
+// invalid ones separately.
+func normalizeSymbols(symbols []string) (valid, invalid []string) {
+	seen := make(map[string]bool, len(symbols))
+	for _, s := range symbols {
+		s = strings.ToUpper(strings.TrimSpace(s))
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+
+		if symbolPattern.MatchString(s) {
+			valid = append(valid, s)
+		} else {
+			invalid = append(invalid, s)
+		}
+	}
+	return valid, invalid
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// pushResult says what became of a message offered to a sendQueue.
+type pushResult int
+
+const (
+	pushQueued pushResult = iota
+	// pushConflated means the message replaced a queued one for the same
+	// topic.
+	pushConflated
+	// pushDropped means a best-effort message was discarded because the
+	// client is lagging.
+	pushDropped
+	// pushTooSlow means the client has lagged for too long, or its queue is
+	// full, and should be disconnected.
+	pushTooSlow
+)
+
+// sendQueue buffers a client's outbound messages between the hub and
+// WritePump. It never blocks the hub. Messages are delivered in order,
+// except that a topic message (price tick) replaces any older one for the
+// same topic still waiting, so a slow client only ever has the latest value
+// per symbol queued. Once more than lagThreshold messages are waiting the
+// client is lagging: best-effort messages (broadcasts) are dropped, while
+// critical ones (the user's stream and replies) are always kept.
+//
+// A client lagging for longer than maxLag, or whose queue reaches maxSize,
+// is disconnected. Messages from its stream can be replayed on resume.
+type sendQueue struct {
+	lagThreshold int
+	maxLag       time.Duration
+	maxSize      int
+	// now is the clock lag is measured with.
+	now func() time.Time
+
+	mu           sync.Mutex
+	items        []*outbound
+	byTopic      map[string]*outbound
+	laggingSince time.Time
+	closed       bool
+	closeMessage []byte
+
+	// ready is signalled when items are added or the queue is closed.
+	ready chan struct{}
+}
+
+func newSendQueue(lagThreshold, maxSize int, maxLag time.Duration) *sendQueue {
+	return &sendQueue{
+		lagThreshold: lagThreshold,
+		maxLag:       maxLag,
+		maxSize:      maxSize,
+		now:          time.Now,
+		byTopic:      make(map[string]*outbound),
+		ready:        make(chan struct{}, 1),
+	}
+}
+
+func (q *sendQueue) push(m outbound) pushResult {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return pushDropped
+	}
+
+	if m.topic != "" {
+		if queued, ok := q.byTopic[m.topic]; ok {
+			*queued = m
+			return pushConflated
+		}
+	}
+
+	lagging := len(q.items) >= q.lagThreshold
+	if lagging {
+		if q.laggingSince.IsZero() {
+			q.laggingSince = q.now()
+		} else if q.now().Sub(q.laggingSince) > q.maxLag {
+			return pushTooSlow
+		}
+	}
+	if len(q.items) >= q.maxSize {
+		return pushTooSlow
+	}
+	if lagging && !m.critical && m.topic == "" {
+		return pushDropped
+	}
+
+	item := &m
+	q.items = append(q.items, item)
+	if m.topic != "" {
+		q.byTopic[m.topic] = item
+	}
+	q.signal()
+	return pushQueued
+}
+
+// drain removes and returns every queued message. closed reports that the
+// queue was closed and nothing more will arrive; closeMessage is then the
+// close frame to send.
+func (q *sendQueue) drain() (messages []outbound, closed bool, closeMessage []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	messages = make([]outbound, len(q.items))
+	for i, item := range q.items {
+		messages[i] = *item
+	}
+	q.items = q.items[:0]
+	for topic := range q.byTopic {
+		delete(q.byTopic, topic)
+	}
+	q.laggingSince = time.Time{}
+
+	return messages, q.closed, q.closeMessage
+}
+
+// close discards anything still queued and tells WritePump to send
+// closeMessage as the close frame; nil sends an empty one.
+func (q *sendQueue) close(closeMessage []byte) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.closeMessage = closeMessage
+	q.items = nil
+	q.byTopic = nil
+	q.signal()
+}
+
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+func (q *sendQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
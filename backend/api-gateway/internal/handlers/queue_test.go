@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestQueue(lagThreshold, maxSize int, maxLag time.Duration) (*sendQueue, *fakeClock) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	q := newSendQueue(lagThreshold, maxSize, maxLag)
+	q.now = clock.now
+	return q, clock
+}
+
+func (r pushResult) String() string {
+	return [...]string{"queued", "conflated", "dropped", "too slow"}[r]
+}
+
+func tick(topic, data string) outbound { return outbound{topic: topic, data: []byte(data)} }
+func broadcast(data string) outbound   { return outbound{data: []byte(data)} }
+func critical(data string) outbound    { return outbound{data: []byte(data), critical: true} }
+
+// after returns a step hook that moves the clock on by d.
+func after(d time.Duration) func(*fakeClock) {
+	return func(c *fakeClock) { c.advance(d) }
+}
+
+func TestSendQueuePush(t *testing.T) {
+	type step struct {
+		// before runs ahead of the push, e.g. to move the clock.
+		before func(*fakeClock)
+		msg    outbound
+		want   pushResult
+	}
+	tests := []struct {
+		name         string
+		lagThreshold int
+		maxSize      int
+		maxLag       time.Duration
+		steps        []step
+		// queued is the data left in the queue, in order.
+		queued []string
+	}{
+		{
+			name:         "below the lag threshold everything is queued",
+			lagThreshold: 3, maxSize: 10, maxLag: time.Second,
+			steps: []step{
+				{msg: broadcast("a"), want: pushQueued},
+				{msg: critical("b"), want: pushQueued},
+				{msg: tick("AAPL", "c"), want: pushQueued},
+			},
+			queued: []string{"a", "b", "c"},
+		},
+		{
+			name:         "a tick replaces the queued one for its topic in place",
+			lagThreshold: 10, maxSize: 10, maxLag: time.Second,
+			steps: []step{
+				{msg: tick("AAPL", "aapl-1"), want: pushQueued},
+				{msg: tick("MSFT", "msft-1"), want: pushQueued},
+				{msg: critical("reply"), want: pushQueued},
+				{msg: tick("AAPL", "aapl-2"), want: pushConflated},
+				{msg: tick("AAPL", "aapl-3"), want: pushConflated},
+			},
+			queued: []string{"aapl-3", "msft-1", "reply"},
+		},
+		{
+			name:         "a lagging client loses broadcasts but keeps critical messages and ticks",
+			lagThreshold: 1, maxSize: 10, maxLag: time.Minute,
+			steps: []step{
+				{msg: critical("a"), want: pushQueued},
+				{msg: broadcast("b"), want: pushDropped},
+				{msg: critical("c"), want: pushQueued},
+				{msg: tick("AAPL", "d"), want: pushQueued},
+			},
+			queued: []string{"a", "c", "d"},
+		},
+		{
+			name:         "lag shorter than maxLag is tolerated",
+			lagThreshold: 1, maxSize: 10, maxLag: time.Second,
+			steps: []step{
+				{msg: critical("a"), want: pushQueued},
+				{msg: critical("b"), want: pushQueued},
+				{before: after(time.Second), msg: critical("c"), want: pushQueued},
+			},
+			queued: []string{"a", "b", "c"},
+		},
+		{
+			name:         "lag longer than maxLag makes the client too slow",
+			lagThreshold: 1, maxSize: 10, maxLag: time.Second,
+			steps: []step{
+				{msg: critical("a"), want: pushQueued},
+				{msg: critical("b"), want: pushQueued},
+				{before: after(time.Second + time.Millisecond), msg: critical("c"), want: pushTooSlow},
+			},
+			queued: []string{"a", "b"},
+		},
+		{
+			name:         "a full queue makes the client too slow at once",
+			lagThreshold: 10, maxSize: 2, maxLag: time.Minute,
+			steps: []step{
+				{msg: critical("a"), want: pushQueued},
+				{msg: critical("b"), want: pushQueued},
+				{msg: critical("c"), want: pushTooSlow},
+			},
+			queued: []string{"a", "b"},
+		},
+		{
+			name:         "conflation still works on a full queue",
+			lagThreshold: 10, maxSize: 2, maxLag: time.Minute,
+			steps: []step{
+				{msg: tick("AAPL", "a"), want: pushQueued},
+				{msg: critical("b"), want: pushQueued},
+				{msg: tick("AAPL", "c"), want: pushConflated},
+			},
+			queued: []string{"c", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, clock := newTestQueue(tt.lagThreshold, tt.maxSize, tt.maxLag)
+			for i, s := range tt.steps {
+				if s.before != nil {
+					s.before(clock)
+				}
+				if got := q.push(s.msg); got != s.want {
+					t.Errorf("push %d (%s): got %v, want %v", i, s.msg.data, got, s.want)
+				}
+			}
+
+			messages, closed, _ := q.drain()
+			if closed {
+				t.Fatal("queue closed unexpectedly")
+			}
+			var got []string
+			for _, m := range messages {
+				got = append(got, string(m.data))
+			}
+			if !slices.Equal(got, tt.queued) {
+				t.Errorf("queued %q, want %q", got, tt.queued)
+			}
+		})
+	}
+}
+
+func TestSendQueueDrainEndsLag(t *testing.T) {
+	q, clock := newTestQueue(1, 10, time.Second)
+	q.push(critical("a"))
+	q.push(critical("b")) // starts lagging
+	q.drain()
+
+	clock.advance(time.Minute)
+	q.push(critical("c"))
+	if got := q.push(critical("d")); got != pushQueued {
+		t.Fatalf("push after drain: got %v, want queued", got)
+	}
+}
+
+func TestSendQueueClosed(t *testing.T) {
+	q, _ := newTestQueue(10, 10, time.Second)
+	q.push(critical("a"))
+	q.close([]byte("bye"))
+
+	if got := q.push(critical("b")); got != pushDropped {
+		t.Errorf("push after close: got %v, want dropped", got)
+	}
+	messages, closed, closeMessage := q.drain()
+	if len(messages) != 0 || !closed || string(closeMessage) != "bye" {
+		t.Errorf("drain after close: got %d messages, closed %v, close message %q", len(messages), closed, closeMessage)
+	}
+}
+
+func TestHubDisconnectsLaggingClient(t *testing.T) {
+	hub := NewWebSocketHub(config.WebSocketConfig{}, zap.NewNop())
+	queue, clock := newTestQueue(2, 100, time.Second)
+	client := &Client{hub: hub, queue: queue, id: "client-1", userID: "user-1", subscriptions: map[string]bool{}}
+	hub.clients[client] = true
+	hub.byID[client.id] = client
+	hub.users[client.userID] = map[*Client]bool{client: true}
+
+	for i := 0; i < 3; i++ {
+		if !hub.enqueue(client, critical("msg")) {
+			t.Fatalf("message %d not delivered", i)
+		}
+	}
+	if hub.slowDisconnects != 0 {
+		t.Fatal("client disconnected before maxLag")
+	}
+
+	clock.advance(2 * time.Second)
+	if hub.enqueue(client, critical("late")) {
+		t.Fatal("message to a client lagging past maxLag was delivered")
+	}
+	if hub.clients[client] || hub.users[client.userID] != nil {
+		t.Error("slow client still registered")
+	}
+	if hub.slowDisconnects != 1 {
+		t.Errorf("slow disconnects: got %d, want 1", hub.slowDisconnects)
+	}
+
+	_, closed, closeMessage := queue.drain()
+	if !closed {
+		t.Fatal("queue not closed")
+	}
+	if len(closeMessage) < 2 {
+		t.Fatalf("close message too short: %q", closeMessage)
+	}
+	if code := binary.BigEndian.Uint16(closeMessage); code != CloseTooSlow {
+		t.Errorf("close code: got %d, want %d", code, CloseTooSlow)
+	}
+}
@@ -0,0 +1,103 @@
+// Package health runs the dependency checks behind the gateway's readiness
+// endpoint.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check probes one dependency. A failing critical check makes the gateway
+// unready; a failing optional one only degrades it.
+type Check struct {
+	Name     string
+	Critical bool
+	Probe    func(ctx context.Context) error
+}
+
+// Status values of a Report and of each Result.
+const (
+	StatusOK          = "ok"
+	StatusDegraded    = "degraded"
+	StatusUnavailable = "unavailable"
+	StatusFailed      = "failed"
+)
+
+// Report is the outcome of running every check.
+type Report struct {
+	// Status is "ok", "degraded" if only optional checks failed, or
+	// "unavailable" if a critical check failed.
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Ready reports whether every critical check passed.
+func (r Report) Ready() bool {
+	return r.Status != StatusUnavailable
+}
+
+// Checker runs a fixed set of checks concurrently, each within its own
+// timeout.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+func (c *Checker) Run(ctx context.Context) Report {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, r := range results {
+		if r.Status == StatusOK {
+			continue
+		}
+		if r.Critical {
+			report.Status = StatusUnavailable
+		} else if report.Status == StatusOK {
+			report.Status = StatusDegraded
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Probe(ctx)
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOK,
+		Critical:  check.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFailed
+		result.Error = err.Error()
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(err error) func(context.Context) error {
+	return func(context.Context) error { return err }
+}
+
+func TestCheckerRun(t *testing.T) {
+	down := errors.New("connection refused")
+
+	tests := []struct {
+		name      string
+		checks    []Check
+		want      string
+		wantReady bool
+	}{
+		{
+			name:      "all pass",
+			checks:    []Check{{Name: "redis", Critical: true, Probe: probe(nil)}, {Name: "kafka", Probe: probe(nil)}},
+			want:      StatusOK,
+			wantReady: true,
+		},
+		{
+			name:      "optional check fails",
+			checks:    []Check{{Name: "redis", Critical: true, Probe: probe(nil)}, {Name: "kafka", Probe: probe(down)}},
+			want:      StatusDegraded,
+			wantReady: true,
+		},
+		{
+			name:      "critical check fails",
+			checks:    []Check{{Name: "redis", Critical: true, Probe: probe(down)}, {Name: "kafka", Probe: probe(nil)}},
+			want:      StatusUnavailable,
+			wantReady: false,
+		},
+		{
+			name:      "critical and optional checks fail",
+			checks:    []Check{{Name: "kafka", Probe: probe(down)}, {Name: "redis", Critical: true, Probe: probe(down)}},
+			want:      StatusUnavailable,
+			wantReady: false,
+		},
+		{
+			name:      "no checks",
+			want:      StatusOK,
+			wantReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := NewChecker(time.Second, tt.checks...).Run(context.Background())
+			if report.Status != tt.want || report.Ready() != tt.wantReady {
+				t.Fatalf("status %s, ready %v, want %s, %v", report.Status, report.Ready(), tt.want, tt.wantReady)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("%d results for %d checks", len(report.Checks), len(tt.checks))
+			}
+			for i, r := range report.Checks {
+				check := tt.checks[i]
+				if r.Name != check.Name || r.Critical != check.Critical {
+					t.Errorf("result %d is %+v, want check %s", i, r, check.Name)
+				}
+				if failed := check.Probe(context.Background()) != nil; failed != (r.Status == StatusFailed) || failed != (r.Error != "") {
+					t.Errorf("result %+v does not match its probe", r)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckerTimesOutProbes(t *testing.T) {
+	checker := NewChecker(10*time.Millisecond, Check{
+		Name:     "postgres",
+		Critical: true,
+		Probe: func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	report := checker.Run(context.Background())
+	if report.Ready() || report.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("got %+v, want the hung check to time out", report)
+	}
+}
+
+func TestHTTPProbe(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	check := HTTPProbe(server.Client(), server.URL+"/livez")
+
+	if err := check(context.Background()); err != nil {
+		t.Errorf("200: %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := check(context.Background()); err == nil {
+		t.Error("503: no error")
+	}
+}
@@ -0,0 +1,49 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// HTTPProbe checks that a GET of url succeeds with a 2xx status. Upstream
+// services are probed at their liveness endpoint, so one service's
+// dependencies don't cascade into the gateway's readiness.
+func HTTPProbe(client *http.Client, url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		// Drain the body so the connection can be reused.
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
+
+// KafkaProbe checks that at least one of brokers accepts a connection.
+func KafkaProbe(brokers []string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var errs []error
+		for _, broker := range brokers {
+			conn, err := kafka.DialContext(ctx, "tcp", broker)
+			if err == nil {
+				return conn.Close()
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+}
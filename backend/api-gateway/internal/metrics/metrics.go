@@ -0,0 +1,96 @@
+// Package metrics defines the gateway's Prometheus metrics. They are
+// registered with the default registry, which also carries the Go runtime
+// and process collectors, and served at /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// HTTPRequests and HTTPRequestDuration are labelled by route template,
+	// not the raw path, so IDs in URLs don't multiply the series.
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	// RedisErrors counts failed Redis commands; a missing key is not an
+	// error.
+	RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_errors_total",
+		Help: "Failed Redis commands, by command.",
+	}, []string{"command"})
+
+	// KafkaErrors counts failures of the event consumer, by operation:
+	// "fetch", "decode", "deliver" or "commit".
+	KafkaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_errors_total",
+		Help: "Kafka consumer errors, by operation.",
+	}, []string{"operation"})
+
+	RateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_rejections_total",
+		Help: "Requests rejected by the rate limiter, by route template.",
+	}, []string{"route"})
+
+	AuthorizationDenials = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "authorization_denials_total",
+		Help: "Requests denied by authorization rules, by route template and reason.",
+	}, []string{"route", "code"})
+
+	// RateLimitModeSwitches counts the rate limiter switching between Redis
+	// ("redis") and its failure mode ("open", "closed" or "local"), and
+	// RateLimitDegraded the requests decided without Redis.
+	RateLimitModeSwitches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_mode_switches_total",
+		Help: "Rate limiter switches between Redis and its failure mode, by mode switched to.",
+	}, []string{"mode"})
+
+	RateLimitDegraded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rate_limit_degraded_decisions_total",
+		Help: "Rate limit decisions made without Redis, by failure mode.",
+	}, []string{"mode"})
+
+	RateLimitCircuitOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rate_limit_redis_circuit_open",
+		Help: "1 while the rate limiter is bypassing Redis, otherwise 0.",
+	})
+
+	// UpstreamCircuitState is 0 while an upstream's circuit breaker is
+	// closed, 1 while it is open and 2 while it is half-open.
+	UpstreamCircuitState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_circuit_state",
+		Help: "Circuit breaker state by upstream: 0 closed, 1 open, 2 half-open.",
+	}, []string{"upstream"})
+
+	UpstreamRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_circuit_rejections_total",
+		Help: "Requests failed fast by an open circuit breaker, by upstream.",
+	}, []string{"upstream"})
+
+	UpstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "upstream_retries_total",
+		Help: "Retried upstream calls, by upstream.",
+	}, []string{"upstream"})
+
+	UpstreamEndpoints = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "upstream_endpoints",
+		Help: "Known instances of each upstream, by health: healthy or unhealthy.",
+	}, []string{"upstream", "state"})
+)
+
+// Handler serves the default registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
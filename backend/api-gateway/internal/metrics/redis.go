@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisHook counts failed commands in RedisErrors. Add it to a client with
+// AddHook.
+type RedisHook struct{}
+
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			RedisErrors.WithLabelValues("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		// The client sets cmd's error only after the hooks return.
+		err := next(ctx, cmd)
+		countRedisError(cmd.Name(), err)
+		return err
+	}
+}
+
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			countRedisError(cmd.Name(), cmd.Err())
+		}
+		return err
+	}
+}
+
+func countRedisError(command string, err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		RedisErrors.WithLabelValues(command).Inc()
+	}
+}
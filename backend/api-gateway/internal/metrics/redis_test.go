@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer client.Close()
+	client.AddHook(RedisHook{})
+	ctx := context.Background()
+
+	failures := func(command string) float64 {
+		return testutil.ToFloat64(RedisErrors.WithLabelValues(command))
+	}
+	get, incr := failures("get"), failures("incr")
+
+	// A missing key is not an error.
+	if err := client.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatal(err)
+	}
+	client.Set(ctx, "name", "not a number", 0)
+	if err := client.Incr(ctx, "name").Err(); err == nil {
+		t.Fatal("INCR of a string succeeded")
+	}
+	pipe := client.Pipeline()
+	pipe.Incr(ctx, "name")
+	pipe.Get(ctx, "name")
+	_, _ = pipe.Exec(ctx)
+
+	if got := failures("get") - get; got != 0 {
+		t.Errorf("get errors %v, want 0", got)
+	}
+	if got := failures("incr") - incr; got != 2 {
+		t.Errorf("incr errors %v, want 2", got)
+	}
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// maxAuthBody bounds how much of a request body is read to find its email.
+const maxAuthBody = 64 << 10
+
+// authFailureScript counts a failed attempt against each identity and,
+// once an identity has had more than the free failures within the failure
+// window, blocks it for a backoff that doubles with every further failure.
+// KEYS are pairs of a failure counter and a block key. ARGV is the number
+// of free failures, the backoff, the maximum backoff and the failure
+// window, durations in milliseconds.
+var authFailureScript = redis.NewScript(`
+local free = tonumber(ARGV[1])
+local backoff = tonumber(ARGV[2])
+local max_backoff = tonumber(ARGV[3])
+
+for i = 1, #KEYS, 2 do
+	local failures = redis.call('INCR', KEYS[i])
+	if failures == 1 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[4])
+	end
+	if failures > free then
+		local block = math.min(backoff * 2 ^ (failures - free - 1), max_backoff)
+		redis.call('SET', KEYS[i + 1], 1, 'PX', math.floor(block))
+	end
+end
+return 0
+`)
+
+// AuthThrottle protects the public authentication routes from credential
+// stuffing. Attempts are limited per client address and, when the body
+// names one, per email address; an address or email whose attempts keep
+// failing with 401 is blocked for progressively longer. A successful
+// attempt clears the email's failures, but not the address's. Blocks are
+// kept in Redis behind limiter's circuit, so Redis failures here count
+// toward opening it; while it is open, blocks are neither enforced nor
+// recorded and the limits follow limiter's failure mode.
+func AuthThrottle(limiter *FailoverRateLimiter, policy *RateLimitPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		email := requestEmail(c)
+		identities := []string{"ip:" + ip}
+		if email != "" {
+			identities = append(identities, "email:"+email)
+		}
+
+		var blocked time.Duration
+		err := limiter.Do(c.Request.Context(), func(ctx context.Context, client *redis.Client) error {
+			var err error
+			blocked, err = blockedFor(ctx, client, identities)
+			return err
+		})
+		if err != nil {
+			throttleError(c, err)
+		} else if blocked > 0 {
+			rejectRateLimited(c, blocked, "Too many failed attempts")
+			return
+		}
+
+		if !enforce(c, limiter, 1, policy.AuthBuckets(ip, email)) {
+			return
+		}
+
+		c.Next()
+
+		// Record the outcome even if the client has gone away.
+		ctx := context.WithoutCancel(c.Request.Context())
+		status := c.Writer.Status()
+		switch {
+		case status == http.StatusUnauthorized:
+			throttleError(c, limiter.Do(ctx, func(ctx context.Context, client *redis.Client) error {
+				return recordAuthFailure(ctx, client, identities, policy)
+			}))
+		case status < http.StatusMultipleChoices && email != "":
+			key := "email:" + email
+			throttleError(c, limiter.Do(ctx, func(ctx context.Context, client *redis.Client) error {
+				if err := client.Del(ctx, authFailuresKey(key), authBlockKey(key)).Err(); err != nil {
+					return fmt.Errorf("auth throttle: %w", err)
+				}
+				return nil
+			}))
+		}
+	}
+}
+
+// throttleError records a Redis failure on the request. An open circuit
+// is not one: the rate limiter has already reported the outage.
+func throttleError(c *gin.Context, err error) {
+	if err != nil && !errors.Is(err, ErrRateLimitUnavailable) {
+		_ = c.Error(err)
+	}
+}
+
+// requestEmail returns the normalized email address a JSON request body
+// names, if any, leaving the body intact for the handler.
+func requestEmail(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxAuthBody))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// blockedFor returns how long the longest block on any of identities has
+// left to run.
+func blockedFor(ctx context.Context, client *redis.Client, identities []string) (time.Duration, error) {
+	cmds := make([]*redis.DurationCmd, len(identities))
+	_, err := client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, id := range identities {
+			cmds[i] = pipe.PTTL(ctx, authBlockKey(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("auth throttle: %w", err)
+	}
+
+	var blocked time.Duration
+	for _, cmd := range cmds {
+		blocked = max(blocked, cmd.Val())
+	}
+	return blocked, nil
+}
+
+func recordAuthFailure(ctx context.Context, client *redis.Client, identities []string, policy *RateLimitPolicy) error {
+	cfg := policy.authBackoff()
+	keys := make([]string, 0, 2*len(identities))
+	for _, id := range identities {
+		keys = append(keys, authFailuresKey(id), authBlockKey(id))
+	}
+	err := authFailureScript.Run(ctx, client, keys,
+		cfg.FreeFailures,
+		cfg.Backoff.Milliseconds(),
+		cfg.MaxBackoff.Milliseconds(),
+		cfg.FailureWindow.Milliseconds(),
+	).Err()
+	if err != nil {
+		return fmt.Errorf("auth throttle: %w", err)
+	}
+	return nil
+}
+
+func authFailuresKey(identity string) string {
+	return "auth_failures:" + identity
+}
+
+func authBlockKey(identity string) string {
+	return "auth_block:" + identity
+}
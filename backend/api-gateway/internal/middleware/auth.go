@@ -1,44 +1,154 @@
 package middleware
 
 import (
-    "net/http"
-    "strings"
-    
-    "github.com/financial-analytics/api-gateway/internal/services"
-    "github.com/gin-gonic/gin"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
 )
 
+// WebSocketTokenProtocol is the Sec-WebSocket-Protocol entry that precedes
+// an access token, e.g. "Sec-WebSocket-Protocol: bearer, <token>". Browsers
+// cannot set an Authorization header on a WebSocket handshake.
+const WebSocketTokenProtocol = "bearer"
+
+// APIKeyHeader carries an API key, as an alternative to
+// "Authorization: ApiKey <key>".
+const APIKeyHeader = "X-API-Key"
+
+// Auth authenticates the caller by a bearer access token or by an API key.
+// Either way the caller's user ID, email and claims are stored in the
+// request context.
 func Auth(authService services.AuthService) gin.HandlerFunc {
-    return func(c *gin.Context) {
-        authHeader := c.GetHeader("Authorization")
-        if authHeader == "" {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-            c.Abort()
-            return
-        }
-        
-        tokenParts := strings.Split(authHeader, " ")
-        if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
-            c.Abort()
-            return
-        }
-        
-        token := tokenParts[1]
-        
-        // Validate token
-        claims, err := authService.ValidateToken(token)
-        if err != nil {
-            c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-            c.Abort()
-            return
-        }
-        
-        // Set user context
-        c.Set("user_id", claims.UserID)
-        c.Set("user_email", claims.Email)
-        c.Set("user_claims", claims)
-        
-        c.Next()
-    }
-}
\ No newline at end of file
+	return func(c *gin.Context) {
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" {
+			authenticateAPIKey(c, authService, apiKey)
+			return
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		if authHeader == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
+			c.Abort()
+			return
+		}
+
+		if token, ok := bearerToken(authHeader); ok {
+			authenticate(c, authService, token)
+			return
+		}
+		if apiKey, ok := credentials(authHeader, "ApiKey"); ok {
+			authenticateAPIKey(c, authService, apiKey)
+			return
+		}
+
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+		c.Abort()
+	}
+}
+
+// WebSocketAuth authenticates a WebSocket upgrade. Besides the Authorization
+// header it accepts the token from the "token" query parameter or the
+// Sec-WebSocket-Protocol header; those are only honoured on upgrade requests
+// so tokens don't leak into URLs for ordinary API calls.
+func WebSocketAuth(authService services.AuthService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var token string
+		if authHeader := c.GetHeader("Authorization"); authHeader != "" {
+			var ok bool
+			if token, ok = bearerToken(authHeader); !ok {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization header format"})
+				c.Abort()
+				return
+			}
+		} else if websocket.IsWebSocketUpgrade(c.Request) {
+			token = c.Query("token")
+			if token == "" {
+				token = protocolToken(websocket.Subprotocols(c.Request))
+			}
+		}
+
+		if token == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Access token required"})
+			c.Abort()
+			return
+		}
+
+		authenticate(c, authService, token)
+	}
+}
+
+func bearerToken(header string) (string, bool) {
+	return credentials(header, "Bearer")
+}
+
+// credentials returns the credentials of an Authorization header that uses
+// scheme.
+func credentials(header, scheme string) (string, bool) {
+	tokenParts := strings.Split(header, " ")
+	if len(tokenParts) != 2 || tokenParts[0] != scheme {
+		return "", false
+	}
+	return tokenParts[1], true
+}
+
+func protocolToken(protocols []string) string {
+	for i, p := range protocols {
+		if p == WebSocketTokenProtocol && i+1 < len(protocols) {
+			return protocols[i+1]
+		}
+	}
+	return ""
+}
+
+// authenticate validates the token and stores the caller's identity in the
+// request context.
+func authenticate(c *gin.Context, authService services.AuthService, token string) {
+	claims, err := authService.ValidateToken(c.Request.Context(), token)
+	if errors.Is(err, services.ErrRefreshTokenUsage) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh tokens cannot be used for API access"})
+		c.Abort()
+		return
+	}
+	if errors.Is(err, services.ErrAuthUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication service unavailable"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+		c.Abort()
+		return
+	}
+
+	setIdentity(c, claims)
+}
+
+// authenticateAPIKey validates the API key and stores its owner's identity
+// in the request context.
+func authenticateAPIKey(c *gin.Context, authService services.AuthService, apiKey string) {
+	claims, err := authService.ValidateAPIKey(c.Request.Context(), apiKey)
+	if errors.Is(err, services.ErrAuthUnavailable) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Authentication service unavailable"})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+		c.Abort()
+		return
+	}
+
+	setIdentity(c, claims)
+}
+
+func setIdentity(c *gin.Context, claims *services.Claims) {
+	c.Set("user_id", claims.UserID)
+	c.Set("user_email", claims.Email)
+	c.Set("user_claims", claims)
+
+	c.Next()
+}
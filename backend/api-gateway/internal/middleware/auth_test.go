@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+func TestWebSocketAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	auth := fakeAuth{tokens: map[string]*services.Claims{
+		"good": {UserID: "u1", Role: services.RoleUser, Scopes: services.SessionScopes},
+	}}
+	router := gin.New()
+	router.GET("/ws/ws", WebSocketAuth(auth), func(c *gin.Context) {
+		c.String(http.StatusOK, c.GetString("user_id"))
+	})
+
+	tests := []struct {
+		name          string
+		query         string
+		authorization string
+		protocol      string
+		upgrade       bool
+		want          int
+	}{
+		{name: "Authorization header", authorization: "Bearer good", upgrade: true, want: http.StatusOK},
+		{name: "malformed Authorization header", authorization: "Token good", upgrade: true, want: http.StatusUnauthorized},
+		{name: "header wins over the query", authorization: "Bearer bad", query: "?token=good", upgrade: true, want: http.StatusUnauthorized},
+		{name: "query token", query: "?token=good", upgrade: true, want: http.StatusOK},
+		{name: "subprotocol token", protocol: "msgpack, bearer, good", upgrade: true, want: http.StatusOK},
+		{name: "subprotocol without a token", protocol: "bearer", upgrade: true, want: http.StatusUnauthorized},
+		{name: "invalid query token", query: "?token=bad", upgrade: true, want: http.StatusUnauthorized},
+		{name: "query token without an upgrade", query: "?token=good", want: http.StatusUnauthorized},
+		{name: "subprotocol token without an upgrade", protocol: "bearer, good", want: http.StatusUnauthorized},
+		{name: "no token", upgrade: true, want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ws/ws"+tt.query, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.protocol != "" {
+				req.Header.Set("Sec-WebSocket-Protocol", tt.protocol)
+			}
+			if tt.upgrade {
+				req.Header.Set("Connection", "Upgrade")
+				req.Header.Set("Upgrade", "websocket")
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.want == http.StatusOK && w.Body.String() != "u1" {
+				t.Errorf("user %q, want u1", w.Body)
+			}
+		})
+	}
+}
+
+func TestCredentials(t *testing.T) {
+	tests := []struct {
+		header string
+		scheme string
+		want   string
+		ok     bool
+	}{
+		{header: "Bearer abc", scheme: "Bearer", want: "abc", ok: true},
+		{header: "ApiKey fa_x_y", scheme: "ApiKey", want: "fa_x_y", ok: true},
+		{header: "ApiKey fa_x_y", scheme: "Bearer"},
+		{header: "Bearer", scheme: "Bearer"},
+		{header: "Bearer a b", scheme: "Bearer"},
+		{header: "bearer abc", scheme: "Bearer"},
+	}
+	for _, tt := range tests {
+		got, ok := credentials(tt.header, tt.scheme)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("credentials(%q, %q) = %q, %v, want %q, %v", tt.header, tt.scheme, got, ok, tt.want, tt.ok)
+		}
+	}
+}
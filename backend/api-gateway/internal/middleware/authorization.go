@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// Rule is what a caller needs to reach a route: one of Scopes, if any are
+// set, and one of Roles, if any are set.
+type Rule struct {
+	Scopes []string `json:"scopes,omitempty"`
+	Roles  []string `json:"roles,omitempty"`
+	// Methods limits the rule to requests with these methods; empty means
+	// every method.
+	Methods []string `json:"methods,omitempty"`
+}
+
+// RequireScope is a rule admitting callers with any of scopes.
+func RequireScope(scopes ...string) Rule {
+	return Rule{Scopes: scopes}
+}
+
+// RequireRole is a rule admitting callers with any of roles. API keys carry
+// no role, so they never satisfy it.
+func RequireRole(roles ...string) Rule {
+	return Rule{Roles: roles}
+}
+
+// On limits the rule to requests with the given methods.
+func (r Rule) On(methods ...string) Rule {
+	r.Methods = methods
+	return r
+}
+
+func (r Rule) applies(method string) bool {
+	return len(r.Methods) == 0 || slices.Contains(r.Methods, method)
+}
+
+// forbidden is the body of a 403 from an authorization rule.
+type forbidden struct {
+	Error string `json:"error"`
+	// Code is "insufficient_scope" or "insufficient_role".
+	Code           string   `json:"code"`
+	Method         string   `json:"method"`
+	Route          string   `json:"route"`
+	RequiredScopes []string `json:"required_scopes,omitempty"`
+	RequiredRoles  []string `json:"required_roles,omitempty"`
+}
+
+// handler enforces the rule. It must run after Auth.
+func (r Rule) handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !r.applies(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		var claims services.Claims
+		if value, ok := c.Get("user_claims"); ok {
+			claims = *value.(*services.Claims)
+		}
+		body := forbidden{Error: "Forbidden", Method: c.Request.Method, Route: c.FullPath()}
+		switch {
+		case len(r.Roles) > 0 && !slices.Contains(r.Roles, claims.Role):
+			body.Code = "insufficient_role"
+			body.RequiredRoles = r.Roles
+		case len(r.Scopes) > 0 && !slices.ContainsFunc(claims.Scopes, func(s string) bool {
+			return slices.Contains(r.Scopes, s)
+		}):
+			body.Code = "insufficient_scope"
+			body.RequiredScopes = r.Scopes
+		default:
+			c.Next()
+			return
+		}
+
+		metrics.AuthorizationDenials.WithLabelValues(body.Route, body.Code).Inc()
+		c.JSON(http.StatusForbidden, body)
+		c.Abort()
+	}
+}
+
+// Policies attaches rules to route groups and keeps them as a table for
+// review.
+type Policies struct {
+	mu     sync.Mutex
+	groups []groupRule
+}
+
+type groupRule struct {
+	path string
+	rule Rule
+}
+
+func NewPolicies() *Policies {
+	return &Policies{}
+}
+
+// Apply makes the routes of group require each rule. gin only applies
+// middleware to routes registered after it, so call Apply before adding
+// the group's routes.
+func (p *Policies) Apply(group *gin.RouterGroup, rules ...Rule) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, rule := range rules {
+		p.groups = append(p.groups, groupRule{path: group.BasePath(), rule: rule})
+		group.Use(rule.handler())
+	}
+}
+
+// Policy is one route's entry in the policy table.
+type Policy struct {
+	Method string `json:"method"`
+	Route  string `json:"route"`
+	// Rules are every rule the route requires; none means any caller the
+	// route's authentication admits.
+	Rules []Rule `json:"rules"`
+}
+
+// Table lists the rules that apply to each of routes, sorted by route and
+// method.
+func (p *Policies) Table(routes gin.RoutesInfo) []Policy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	table := make([]Policy, 0, len(routes))
+	for _, route := range routes {
+		policy := Policy{Method: route.Method, Route: route.Path, Rules: []Rule{}}
+		for _, g := range p.groups {
+			if inGroup(route.Path, g.path) && g.rule.applies(route.Method) {
+				rule := g.rule
+				rule.Methods = nil
+				policy.Rules = append(policy.Rules, rule)
+			}
+		}
+		table = append(table, policy)
+	}
+	slices.SortFunc(table, func(a, b Policy) int {
+		if c := strings.Compare(a.Route, b.Route); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Method, b.Method)
+	})
+	return table
+}
+
+// inGroup reports whether path is registered under the group at base.
+func inGroup(path, base string) bool {
+	base = strings.TrimSuffix(base, "/")
+	return path == base || strings.HasPrefix(path, base+"/")
+}
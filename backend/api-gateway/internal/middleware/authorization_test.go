@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// fakeAuth admits the bearer tokens and API keys it has claims for.
+type fakeAuth struct {
+	tokens  map[string]*services.Claims
+	apiKeys map[string]*services.Claims
+}
+
+func (a fakeAuth) ValidateToken(_ context.Context, token string) (*services.Claims, error) {
+	if claims, ok := a.tokens[token]; ok {
+		return claims, nil
+	}
+	return nil, services.ErrInvalidToken
+}
+
+func (a fakeAuth) ValidateAPIKey(_ context.Context, key string) (*services.Claims, error) {
+	if claims, ok := a.apiKeys[key]; ok {
+		return claims, nil
+	}
+	return nil, services.ErrInvalidToken
+}
+
+// newAuthorizedRouter returns a router with an admin group and a
+// dashboards group guarded like the gateway's, and their policies.
+func newAuthorizedRouter() (*gin.Engine, *Policies) {
+	gin.SetMode(gin.TestMode)
+	auth := fakeAuth{
+		tokens: map[string]*services.Claims{
+			"user":  {UserID: "u1", Role: services.RoleUser, Scopes: services.SessionScopes},
+			"admin": {UserID: "a1", Role: services.RoleAdmin, Scopes: services.SessionScopes},
+		},
+		apiKeys: map[string]*services.Claims{
+			"read-key":  {UserID: "u1", Scopes: []string{services.ScopeReadOnly}, APIKeyID: "k1"},
+			"write-key": {UserID: "u1", Scopes: []string{services.ScopeDashboardsWrite}, APIKeyID: "k2"},
+		},
+	}
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+
+	router := gin.New()
+	policies := NewPolicies()
+	protected := router.Group("/api")
+	protected.Use(Auth(auth))
+	{
+		dashboards := protected.Group("/dashboards")
+		policies.Apply(dashboards,
+			RequireScope(services.ScopeReadOnly, services.ScopeDashboardsWrite).On(http.MethodGet),
+			RequireScope(services.ScopeDashboardsWrite).On(http.MethodPost, http.MethodDelete),
+		)
+		{
+			dashboards.GET("", ok)
+			dashboards.POST("", ok)
+			dashboards.DELETE("/:id", ok)
+		}
+
+		admin := protected.Group("/admin")
+		policies.Apply(admin, RequireRole(services.RoleAdmin))
+		{
+			admin.GET("/policies", ok)
+		}
+	}
+	router.GET("/health", ok)
+	return router, policies
+}
+
+func TestAuthorizationRules(t *testing.T) {
+	router, _ := newAuthorizedRouter()
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		apiKey        string
+		want          int
+		wantCode      string
+	}{
+		{name: "user reads dashboards", method: http.MethodGet, path: "/api/dashboards", authorization: "Bearer user", want: http.StatusOK},
+		{name: "read-only key reads dashboards", method: http.MethodGet, path: "/api/dashboards", apiKey: "read-key", want: http.StatusOK},
+		{name: "write key reads dashboards", method: http.MethodGet, path: "/api/dashboards", apiKey: "write-key", want: http.StatusOK},
+		{name: "read-only key cannot create dashboards", method: http.MethodPost, path: "/api/dashboards", apiKey: "read-key", want: http.StatusForbidden, wantCode: "insufficient_scope"},
+		{name: "read-only key cannot delete dashboards", method: http.MethodDelete, path: "/api/dashboards/1", authorization: "ApiKey read-key", want: http.StatusForbidden, wantCode: "insufficient_scope"},
+		{name: "write key creates dashboards", method: http.MethodPost, path: "/api/dashboards", apiKey: "write-key", want: http.StatusOK},
+		{name: "admin reads policies", method: http.MethodGet, path: "/api/admin/policies", authorization: "Bearer admin", want: http.StatusOK},
+		{name: "user cannot read policies", method: http.MethodGet, path: "/api/admin/policies", authorization: "Bearer user", want: http.StatusForbidden, wantCode: "insufficient_role"},
+		{name: "API key without a role cannot read policies", method: http.MethodGet, path: "/api/admin/policies", apiKey: "write-key", want: http.StatusForbidden, wantCode: "insufficient_role"},
+		{name: "unknown API key", method: http.MethodGet, path: "/api/admin/policies", apiKey: "stolen", want: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.apiKey != "" {
+				req.Header.Set(APIKeyHeader, tt.apiKey)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var body forbidden
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Code != tt.wantCode || body.Method != tt.method {
+				t.Errorf("got %+v, want code %s for %s", body, tt.wantCode, tt.method)
+			}
+		})
+	}
+}
+
+func TestPoliciesTable(t *testing.T) {
+	router, policies := newAuthorizedRouter()
+	table := policies.Table(router.Routes())
+
+	var routes []string
+	rules := map[string][]Rule{}
+	for _, p := range table {
+		key := p.Method + " " + p.Route
+		routes = append(routes, key)
+		rules[key] = p.Rules
+	}
+	want := []string{
+		"GET /api/admin/policies",
+		"GET /api/dashboards",
+		"POST /api/dashboards",
+		"DELETE /api/dashboards/:id",
+		"GET /health",
+	}
+	if !slices.Equal(routes, want) {
+		t.Fatalf("routes %q, want %q", routes, want)
+	}
+
+	if got := rules["GET /health"]; got == nil || len(got) != 0 {
+		t.Errorf("health rules %+v, want none", got)
+	}
+	if got := rules["GET /api/admin/policies"]; len(got) != 1 || !slices.Equal(got[0].Roles, []string{services.RoleAdmin}) {
+		t.Errorf("admin rules %+v, want the admin role", got)
+	}
+	got := rules["DELETE /api/dashboards/:id"]
+	if len(got) != 1 || !slices.Equal(got[0].Scopes, []string{services.ScopeDashboardsWrite}) || got[0].Methods != nil {
+		t.Errorf("dashboard delete rules %+v, want the write scope alone", got)
+	}
+}
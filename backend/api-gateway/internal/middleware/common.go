@@ -1,18 +1,153 @@
 package middleware
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	mathrand "math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
-func Logger(logger *zap.Logger) gin.HandlerFunc {
+const (
+	// RequestIDHeader carries the request ID from clients, back to them,
+	// and on to upstream services.
+	RequestIDHeader = "X-Request-ID"
+	// RequestIDKey is the gin context key holding the request ID.
+	RequestIDKey = "request_id"
+
+	maxRequestIDLength = 128
+	redacted           = "[REDACTED]"
+)
+
+// RequestID assigns every request an ID, keeping the caller's X-Request-ID
+// when it is well formed, and echoes it in the response.
+func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
 		c.Next()
 	}
 }
 
-func CORS() gin.HandlerFunc {
+// validRequestID accepts short printable IDs, so callers cannot inject
+// arbitrary data into logs or upstream headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Logger writes one access log line per request. Failed requests are always
+// logged; successful ones are sampled at cfg.SampleRate.
+func Logger(logger *zap.Logger, cfg config.LoggingConfig) gin.HandlerFunc {
+	redactHeaders := make(map[string]bool, len(cfg.RedactHeaders))
+	for _, h := range cfg.RedactHeaders {
+		redactHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+	redactParams := make(map[string]bool, len(cfg.RedactQueryParams))
+	for _, p := range cfg.RedactQueryParams {
+		redactParams[p] = true
+	}
+
 	return func(c *gin.Context) {
+		start := time.Now()
 		c.Next()
+
+		status := c.Writer.Status()
+		failed := status >= http.StatusBadRequest || len(c.Errors) > 0
+		if !failed && !sampled(cfg.SampleRate) {
+			return
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		fields := []zap.Field{
+			zap.String("request_id", c.GetString(RequestIDKey)),
+			zap.String("method", c.Request.Method),
+			zap.String("route", route),
+			zap.String("path", redactQuery(c.Request.URL, redactParams)),
+			zap.Int("status", status),
+			zap.Duration("latency", time.Since(start)),
+			zap.Int("bytes", max(c.Writer.Size(), 0)),
+			zap.String("user_id", c.GetString("user_id")),
+			zap.String("client_ip", c.ClientIP()),
+			zap.String("user_agent", c.Request.UserAgent()),
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			fields = append(fields, zap.String("trace_id", span.TraceID().String()))
+		}
+		if cfg.LogHeaders {
+			fields = append(fields, zap.Any("headers", redactHeaderValues(c.Request.Header, redactHeaders)))
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.String("errors", c.Errors.String()))
+		}
+
+		switch {
+		case status >= http.StatusInternalServerError:
+			logger.Error("Request", fields...)
+		case failed:
+			logger.Warn("Request", fields...)
+		default:
+			logger.Info("Request", fields...)
+		}
+	}
+}
+
+func sampled(rate float64) bool {
+	return rate >= 1 || (rate > 0 && mathrand.Float64() < rate)
+}
+
+// redactQuery returns the request path and query with sensitive parameters,
+// such as WebSocket access tokens, masked.
+func redactQuery(u *url.URL, params map[string]bool) string {
+	if u.RawQuery == "" {
+		return u.Path
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && params[unescaped] {
+			pairs[i] = name + "=" + redacted
+		}
+	}
+	return u.Path + "?" + strings.Join(pairs, "&")
+}
+
+func redactHeaderValues(header http.Header, redact map[string]bool) map[string]string {
+	values := make(map[string]string, len(header))
+	for name, v := range header {
+		if redact[name] {
+			values[name] = redacted
+		} else {
+			values[name] = strings.Join(v, ", ")
+		}
 	}
+	return values
 }
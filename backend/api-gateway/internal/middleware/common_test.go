@@ -0,0 +1,114 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestLoggerRedacts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	router.Use(RequestID(), Logger(zap.New(core), config.LoggingConfig{
+		SampleRate:        1,
+		LogHeaders:        true,
+		RedactHeaders:     []string{"authorization", "X-API-Key"},
+		RedactQueryParams: []string{"token", "access_token"},
+	}))
+	router.GET("/ws/ws", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	req := httptest.NewRequest(http.MethodGet, "/ws/ws?symbol=AAPL&token=secret1&access%5Ftoken=secret2&token", nil)
+	req.Header.Set("Authorization", "Bearer secret3")
+	req.Header.Set("X-API-Key", "secret4")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set(RequestIDHeader, "caller-id")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if logs.Len() != 1 {
+		t.Fatalf("%d log lines, want 1", logs.Len())
+	}
+	fields := logs.All()[0].ContextMap()
+	if want := "/ws/ws?symbol=AAPL&token=[REDACTED]&access%5Ftoken=[REDACTED]&token=[REDACTED]"; fields["path"] != want {
+		t.Errorf("path %q, want %q", fields["path"], want)
+	}
+	headers, _ := fields["headers"].(map[string]string)
+	if headers["Authorization"] != redacted || headers["X-Api-Key"] != redacted {
+		t.Errorf("credentials logged: %v", headers)
+	}
+	if headers["Accept"] != "application/json" {
+		t.Errorf("Accept %q, want it logged", headers["Accept"])
+	}
+	if fields["request_id"] != "caller-id" || fields["route"] != "/ws/ws" {
+		t.Errorf("request_id %v, route %v", fields["request_id"], fields["route"])
+	}
+	for _, secret := range []string{"secret1", "secret2", "secret3", "secret4"} {
+		for name, v := range fields {
+			if s, ok := v.(string); ok && strings.Contains(s, secret) {
+				t.Errorf("%s logged %s", name, secret)
+			}
+		}
+	}
+}
+
+func TestLoggerSampling(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	core, logs := observer.New(zap.InfoLevel)
+	router := gin.New()
+	router.Use(Logger(zap.New(core), config.LoggingConfig{SampleRate: 0}))
+	router.GET("/ok", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	var got []string
+	for _, entry := range logs.All() {
+		got = append(got, entry.Level.String()+" "+entry.ContextMap()["route"].(string))
+	}
+	if want := "error /fail,warn unmatched"; strings.Join(got, ",") != want {
+		t.Errorf("logged %q, want %q", got, want)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, c.GetString(RequestIDKey)) })
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{name: "kept", header: "abc-123", keep: true},
+		{name: "missing", header: ""},
+		{name: "with spaces", header: "abc 123"},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(RequestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			id := w.Header().Get(RequestIDHeader)
+			if id != w.Body.String() {
+				t.Fatalf("response header %q, context %q", id, w.Body)
+			}
+			if (id == tt.header) != tt.keep || !validRequestID(id) {
+				t.Errorf("request ID %q for header %q", id, tt.header)
+			}
+		})
+	}
+}
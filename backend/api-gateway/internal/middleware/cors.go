@@ -0,0 +1,234 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// CORSPolicy decides which browser origins may call the API and open
+// WebSockets. It is shared by the CORS middleware and the WebSocket
+// upgrader so both enforce the same allowlist.
+type CORSPolicy struct {
+	logger *zap.Logger
+	rules  atomic.Pointer[corsRules]
+}
+
+// corsRules is a parsed CORSConfig. It is never modified once stored, so a
+// request keeps a consistent view across a reload.
+type corsRules struct {
+	anyOrigin     bool
+	origins       map[string]bool
+	wildcards     []wildcardOrigin
+	methods       map[string]bool
+	allowMethods  string
+	headers       map[string]bool
+	allowHeaders  string
+	exposeHeaders string
+	credentials   bool
+	maxAge        string
+}
+
+// wildcardOrigin matches any subdomain of suffix, e.g. "https://*.example.com"
+// matches "https://app.example.com" but not "https://example.com".
+type wildcardOrigin struct {
+	scheme string
+	suffix string
+	port   string
+}
+
+func NewCORSPolicy(cfg config.CORSConfig, logger *zap.Logger) *CORSPolicy {
+	p := &CORSPolicy{logger: logger}
+	p.SetConfig(cfg)
+	return p
+}
+
+// SetConfig replaces the policy. It is called when the configuration is
+// reloaded.
+func (p *CORSPolicy) SetConfig(cfg config.CORSConfig) {
+	origins := make(map[string]bool)
+	var wildcards []wildcardOrigin
+	anyOrigin := false
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(strings.ToLower(origin))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(u.Hostname(), "*.") {
+			wildcards = append(wildcards, wildcardOrigin{
+				scheme: u.Scheme,
+				suffix: strings.TrimPrefix(u.Hostname(), "*"),
+				port:   u.Port(),
+			})
+			continue
+		}
+		origins[u.Scheme+"://"+u.Host] = true
+	}
+
+	methods := make(map[string]bool, len(cfg.AllowedMethods))
+	for _, m := range cfg.AllowedMethods {
+		methods[strings.ToUpper(m)] = true
+	}
+	headers := make(map[string]bool, len(cfg.AllowedHeaders))
+	for _, h := range cfg.AllowedHeaders {
+		headers[http.CanonicalHeaderKey(h)] = true
+	}
+
+	rules := &corsRules{
+		anyOrigin:     anyOrigin,
+		origins:       origins,
+		wildcards:     wildcards,
+		methods:       methods,
+		allowMethods:  strings.ToUpper(strings.Join(cfg.AllowedMethods, ", ")),
+		headers:       headers,
+		allowHeaders:  strings.Join(cfg.AllowedHeaders, ", "),
+		exposeHeaders: strings.Join(cfg.ExposedHeaders, ", "),
+		credentials:   cfg.AllowCredentials,
+	}
+	if cfg.MaxAge > 0 {
+		rules.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	p.rules.Store(rules)
+}
+
+// AllowOrigin reports whether origin is on the allowlist.
+func (p *CORSPolicy) AllowOrigin(origin string) bool {
+	return p.rules.Load().allowOrigin(origin)
+}
+
+func (r *corsRules) allowOrigin(origin string) bool {
+	if r.anyOrigin {
+		return true
+	}
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if r.origins[u.Scheme+"://"+u.Host] {
+		return true
+	}
+	for _, w := range r.wildcards {
+		if u.Scheme == w.scheme && u.Port() == w.port && strings.HasSuffix(u.Hostname(), w.suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin is a websocket.Upgrader CheckOrigin function. Requests without
+// an Origin header come from non-browser clients and same-origin requests
+// are always allowed; anything else must be on the allowlist.
+func (p *CORSPolicy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	if p.AllowOrigin(origin) {
+		return true
+	}
+	p.logger.Warn("WebSocket origin rejected",
+		zap.String("origin", origin),
+		zap.String("path", r.URL.Path),
+	)
+	return false
+}
+
+// CORS applies the policy to API requests. Preflight requests are answered
+// here; a disallowed origin gets no CORS headers, so the browser blocks the
+// response.
+func CORS(policy *CORSPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions &&
+			c.GetHeader("Access-Control-Request-Method") != ""
+
+		rules := policy.rules.Load()
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !rules.allowOrigin(origin) {
+			policy.logger.Warn("CORS origin rejected",
+				zap.String("origin", origin),
+				zap.String("method", c.Request.Method),
+				zap.String("path", c.Request.URL.Path),
+			)
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if preflight && (!rules.methods[strings.ToUpper(c.GetHeader("Access-Control-Request-Method"))] ||
+			!rules.allowsHeaders(c.GetHeader("Access-Control-Request-Headers"))) {
+			policy.logger.Warn("CORS preflight rejected",
+				zap.String("origin", origin),
+				zap.String("request_method", c.GetHeader("Access-Control-Request-Method")),
+				zap.String("request_headers", c.GetHeader("Access-Control-Request-Headers")),
+				zap.String("path", c.Request.URL.Path),
+			)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		if rules.anyOrigin && !rules.credentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if rules.credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if rules.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", rules.exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		h.Set("Access-Control-Allow-Methods", rules.allowMethods)
+		if rules.allowHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", rules.allowHeaders)
+		}
+		if rules.maxAge != "" {
+			h.Set("Access-Control-Max-Age", rules.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
+
+// allowsHeaders reports whether every header in a comma-separated
+// Access-Control-Request-Headers value is allowed.
+func (r *corsRules) allowsHeaders(requested string) bool {
+	for _, h := range strings.Split(requested, ",") {
+		if h = strings.TrimSpace(h); h != "" && !r.headers[http.CanonicalHeaderKey(h)] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func testCORSConfig() config.CORSConfig {
+	return config.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.staging.example.com"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Authorization", "Content-Type"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	}
+}
+
+func TestCORSPolicyAllowOrigin(t *testing.T) {
+	policy := NewCORSPolicy(testCORSConfig(), zap.NewNop())
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://evil.example.com", false},
+		{"https://app.example.com.evil.com", false},
+		{"https://pr-1.staging.example.com", true},
+		{"https://staging.example.com", false},
+		{"https://evilstaging.example.com", false},
+		{"null", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := policy.AllowOrigin(tt.origin); got != tt.want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+
+	policy.SetConfig(config.CORSConfig{AllowedOrigins: []string{"*"}})
+	if !policy.AllowOrigin("https://evil.example.com") {
+		t.Error("\"*\" did not allow every origin")
+	}
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(CORS(NewCORSPolicy(testCORSConfig(), zap.NewNop())))
+	router.GET("/quotes", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.OPTIONS("/quotes", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name          string
+		method        string
+		origin        string
+		requestMethod string
+		headers       string
+		want          int
+		wantOrigin    string
+	}{
+		{name: "allowed request", method: http.MethodGet, origin: "https://app.example.com", want: http.StatusOK, wantOrigin: "https://app.example.com"},
+		{name: "disallowed request gets no CORS headers", method: http.MethodGet, origin: "https://evil.example.com", want: http.StatusOK},
+		{name: "request without an origin", method: http.MethodGet, want: http.StatusOK},
+		{name: "allowed preflight", method: http.MethodOptions, origin: "https://app.example.com", requestMethod: "POST", headers: "content-type, authorization", want: http.StatusNoContent, wantOrigin: "https://app.example.com"},
+		{name: "preflight from a disallowed origin", method: http.MethodOptions, origin: "https://evil.example.com", requestMethod: "GET", want: http.StatusForbidden},
+		{name: "preflight for a disallowed method", method: http.MethodOptions, origin: "https://app.example.com", requestMethod: "DELETE", want: http.StatusForbidden},
+		{name: "preflight for a disallowed header", method: http.MethodOptions, origin: "https://app.example.com", requestMethod: "GET", headers: "X-Admin", want: http.StatusForbidden},
+		{name: "OPTIONS without a request method is not a preflight", method: http.MethodOptions, origin: "https://app.example.com", want: http.StatusOK, wantOrigin: "https://app.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/quotes", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if tt.requestMethod != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+			}
+			if tt.headers != "" {
+				req.Header.Set("Access-Control-Request-Headers", tt.headers)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("got %d, want %d", w.Code, tt.want)
+			}
+			h := w.Header()
+			if got := h.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Fatalf("Access-Control-Allow-Origin %q, want %q", got, tt.wantOrigin)
+			}
+			if tt.wantOrigin == "" {
+				return
+			}
+			if got := h.Get("Access-Control-Allow-Credentials"); got != "true" {
+				t.Errorf("Access-Control-Allow-Credentials %q, want true", got)
+			}
+			if w.Code == http.StatusNoContent {
+				if got := h.Get("Access-Control-Allow-Methods"); got != "GET, POST" {
+					t.Errorf("Access-Control-Allow-Methods %q", got)
+				}
+				if got := h.Get("Access-Control-Max-Age"); got != "600" {
+					t.Errorf("Access-Control-Max-Age %q, want 600", got)
+				}
+			} else if got := h.Get("Access-Control-Expose-Headers"); got != "X-Request-ID" {
+				t.Errorf("Access-Control-Expose-Headers %q", got)
+			}
+		})
+	}
+}
+
+func TestCORSPolicyCheckOrigin(t *testing.T) {
+	policy := NewCORSPolicy(testCORSConfig(), zap.NewNop())
+
+	tests := []struct {
+		name   string
+		origin string
+		want   bool
+	}{
+		{name: "no origin", want: true},
+		{name: "same origin", origin: "https://gateway.example.com", want: true},
+		{name: "allowed origin", origin: "https://app.example.com", want: true},
+		{name: "allowed by wildcard", origin: "https://pr-1.staging.example.com", want: true},
+		{name: "disallowed origin", origin: "https://evil.example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "https://gateway.example.com/ws/ws", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			if got := policy.CheckOrigin(req); got != tt.want {
+				t.Errorf("CheckOrigin = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
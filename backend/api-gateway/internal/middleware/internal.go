@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// InternalTokenHeader carries the shared secret on calls to the internal API.
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalAuth admits requests from other services that present token in
+// the X-Internal-Token header.
+func InternalAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		got := c.GetHeader(InternalTokenHeader)
+		if got == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal token"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics counts requests and records their latency by route template.
+// Requests that match no route share the "unmatched" label, so scanners
+// probing random paths can't create unbounded series.
+func Metrics() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		labels := []string{c.Request.Method, route, strconv.Itoa(c.Writer.Status())}
+		metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+		metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Metrics())
+	router.GET("/metrics-test/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	requests := func(method, route, status string) float64 {
+		return testutil.ToFloat64(metrics.HTTPRequests.WithLabelValues(method, route, status))
+	}
+	route := requests("GET", "/metrics-test/:id", "204")
+	unmatched := requests("GET", "unmatched", "404")
+
+	for _, path := range []string{"/metrics-test/1", "/metrics-test/2", "/no-such-route/1", "/no-such-route/2"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	if got := requests("GET", "/metrics-test/:id", "204") - route; got != 2 {
+		t.Errorf("route requests counted %v, want 2 under the template", got)
+	}
+	if got := requests("GET", "unmatched", "404") - unmatched; got != 2 {
+		t.Errorf("unmatched requests counted %v, want 2", got)
+	}
+	if n := testutil.CollectAndCount(metrics.HTTPRequestDuration, "http_request_duration_seconds"); n == 0 {
+		t.Error("no latency recorded")
+	}
+}
@@ -0,0 +1,274 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// ErrRateLimitUnavailable is returned in the "closed" failure mode while
+// Redis is unreachable.
+var ErrRateLimitUnavailable = errors.New("rate limit: redis unavailable")
+
+// FailoverRateLimiter checks quotas in Redis and, when Redis fails, decides
+// by the configured failure mode instead. A circuit breaker stops it from
+// calling Redis during an outage, so requests aren't each delayed by a
+// Redis timeout; once the cooldown has passed a single request probes
+// Redis, and the circuit closes again if it succeeds. Other Redis calls
+// that should share the circuit go through Do.
+type FailoverRateLimiter struct {
+	redis    *RedisRateLimiter
+	local    *LocalRateLimiter
+	logger   *zap.Logger
+	settings atomic.Pointer[failoverSettings]
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	retryAt  time.Time
+}
+
+type failoverSettings struct {
+	mode            string
+	replicas        int
+	timeout         time.Duration
+	breakerFailures int
+	breakerCooldown time.Duration
+}
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	// circuitProbing is an open circuit with one request trying Redis.
+	circuitProbing
+)
+
+func NewFailoverRateLimiter(redis *RedisRateLimiter, cfg config.RateLimitConfig, logger *zap.Logger) *FailoverRateLimiter {
+	f := &FailoverRateLimiter{
+		redis:  redis,
+		local:  NewLocalRateLimiter(),
+		logger: logger,
+	}
+	f.SetConfig(cfg)
+	return f
+}
+
+// SetConfig replaces the failure mode and circuit settings. It is called
+// when the configuration is reloaded.
+func (f *FailoverRateLimiter) SetConfig(cfg config.RateLimitConfig) {
+	f.settings.Store(&failoverSettings{
+		mode:            cfg.FailureMode,
+		replicas:        cfg.LocalReplicas,
+		timeout:         cfg.Timeout,
+		breakerFailures: cfg.BreakerFailures,
+		breakerCooldown: cfg.BreakerCooldown,
+	})
+}
+
+func (f *FailoverRateLimiter) Allow(ctx context.Context, cost int, buckets ...Bucket) (Decision, error) {
+	var decision Decision
+	err := f.Do(ctx, func(ctx context.Context, _ *redis.Client) error {
+		var err error
+		decision, err = f.redis.Allow(ctx, cost, buckets...)
+		return err
+	})
+	switch {
+	case err == nil:
+		return decision, nil
+	case ctx.Err() != nil:
+		return Decision{}, err
+	default:
+		return f.fallback(ctx, f.settings.Load(), cost, buckets)
+	}
+}
+
+// Do runs fn against Redis through the circuit. fn's context is bounded by
+// the configured timeout, and its errors count toward opening the circuit.
+// While the circuit is open fn is not called and Do returns
+// ErrRateLimitUnavailable.
+func (f *FailoverRateLimiter) Do(ctx context.Context, fn func(context.Context, *redis.Client) error) error {
+	s := f.settings.Load()
+	if !f.tryRedis() {
+		return ErrRateLimitUnavailable
+	}
+
+	redisCtx, cancel := context.WithTimeout(ctx, s.timeout)
+	err := fn(redisCtx, f.redis.client)
+	cancel()
+	switch {
+	case err == nil:
+		f.succeeded()
+	case ctx.Err() != nil:
+		// The client went away, which says nothing about Redis.
+		f.abandoned()
+	default:
+		f.failed(s, err)
+	}
+	return err
+}
+
+// tryRedis reports whether a request should be checked in Redis.
+func (f *FailoverRateLimiter) tryRedis() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch f.state {
+	case circuitClosed:
+		return true
+	case circuitOpen:
+		if time.Now().Before(f.retryAt) {
+			return false
+		}
+		f.state = circuitProbing
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *FailoverRateLimiter) succeeded() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = 0
+	if f.state == circuitClosed {
+		return
+	}
+	f.state = circuitClosed
+	f.logger.Info("Redis is reachable again, rate limiting with Redis")
+	metrics.RateLimitModeSwitches.WithLabelValues("redis").Inc()
+	metrics.RateLimitCircuitOpen.Set(0)
+}
+
+func (f *FailoverRateLimiter) failed(s *failoverSettings, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures++
+	switch f.state {
+	case circuitClosed:
+		if f.failures < s.breakerFailures {
+			return
+		}
+		f.logger.Warn("Redis is unreachable, rate limiting in failure mode",
+			zap.String("mode", s.mode),
+			zap.Int("failures", f.failures),
+			zap.Duration("retry_after", s.breakerCooldown),
+			zap.Error(err),
+		)
+		metrics.RateLimitModeSwitches.WithLabelValues(s.mode).Inc()
+		metrics.RateLimitCircuitOpen.Set(1)
+	case circuitProbing:
+		f.logger.Debug("Redis probe failed", zap.Error(err))
+	default:
+		return
+	}
+	f.state = circuitOpen
+	f.retryAt = time.Now().Add(s.breakerCooldown)
+}
+
+// abandoned lets another request probe Redis if this one was the probe.
+func (f *FailoverRateLimiter) abandoned() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.state == circuitProbing {
+		f.state = circuitOpen
+	}
+}
+
+func (f *FailoverRateLimiter) fallback(ctx context.Context, s *failoverSettings, cost int, buckets []Bucket) (Decision, error) {
+	metrics.RateLimitDegraded.WithLabelValues(s.mode).Inc()
+	switch s.mode {
+	case "open":
+		return Decision{Allowed: true}, nil
+	case "closed":
+		return Decision{}, ErrRateLimitUnavailable
+	}
+
+	// Each replica sees only its share of the traffic, so it enforces its
+	// share of the quota; a request must still fit in a bucket.
+	local := make([]Bucket, len(buckets))
+	for i, b := range buckets {
+		b.Limit = max(b.Limit/s.replicas, cost)
+		local[i] = b
+	}
+	return f.local.Allow(ctx, cost, local...)
+}
+
+// LocalRateLimiter keeps buckets in process memory with the same algorithm
+// as RedisRateLimiter, so each replica limits only the requests it serves.
+type LocalRateLimiter struct {
+	mu        sync.Mutex
+	tats      map[string]time.Time
+	nextSweep time.Time
+}
+
+// localSweepInterval is how often buckets that have refilled are dropped.
+const localSweepInterval = time.Minute
+
+func NewLocalRateLimiter() *LocalRateLimiter {
+	return &LocalRateLimiter{tats: make(map[string]time.Time)}
+}
+
+func (l *LocalRateLimiter) Allow(_ context.Context, cost int, buckets ...Bucket) (Decision, error) {
+	if len(buckets) == 0 {
+		return Decision{Allowed: true}, nil
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+
+	decision := Decision{Allowed: true}
+	tats := make([]time.Time, len(buckets))
+	for i, b := range buckets {
+		emission := b.Window / time.Duration(b.Limit)
+		tat := l.tats[b.Key]
+		if tat.Before(now) {
+			tat = now
+		}
+		tats[i] = tat
+		if wait := tat.Add(emission*time.Duration(cost) - b.Window).Sub(now); wait > 0 {
+			decision.Allowed = false
+			decision.RetryAfter = max(decision.RetryAfter, wait)
+		}
+	}
+
+	for i, b := range buckets {
+		emission := b.Window / time.Duration(b.Limit)
+		tat := tats[i]
+		if decision.Allowed {
+			tat = tat.Add(emission * time.Duration(cost))
+			l.tats[b.Key] = tat
+		}
+		left := int((b.Window - tat.Sub(now)) / emission)
+		if i == 0 || left < decision.Remaining {
+			decision.Limit = b.Limit
+			decision.Remaining = left
+			decision.ResetAfter = tat.Sub(now)
+		}
+	}
+	decision.Remaining = max(decision.Remaining, 0)
+	return decision, nil
+}
+
+// sweep drops the buckets whose quota is full again, which are the same as
+// absent ones.
+func (l *LocalRateLimiter) sweep(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	for key, tat := range l.tats {
+		if tat.Before(now) {
+			delete(l.tats, key)
+		}
+	}
+	l.nextSweep = now.Add(localSweepInterval)
+}
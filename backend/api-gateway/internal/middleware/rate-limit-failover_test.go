@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+func failoverConfig(mode string) config.RateLimitConfig {
+	return config.RateLimitConfig{
+		FailureMode:     mode,
+		LocalReplicas:   2,
+		Timeout:         time.Second,
+		BreakerFailures: 2,
+		BreakerCooldown: 20 * time.Millisecond,
+	}
+}
+
+func TestFailoverRateLimiterModes(t *testing.T) {
+	bucket := Bucket{Key: "rate_limit:test", Limit: 4, Window: time.Minute}
+
+	tests := []struct {
+		mode    string
+		allowed int
+		wantErr error
+	}{
+		{mode: "open", allowed: 10},
+		// Half of the quota for each of 2 replicas.
+		{mode: "local", allowed: 2},
+		{mode: "closed", allowed: 0, wantErr: ErrRateLimitUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			mr, client := newTestRedis(t)
+			mr.SetError("LOADING Redis is loading the dataset in memory")
+			limiter := NewFailoverRateLimiter(NewRateLimiter(client), failoverConfig(tt.mode), zap.NewNop())
+
+			allowed := 0
+			for i := 0; i < 10; i++ {
+				d, err := limiter.Allow(context.Background(), 1, bucket)
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("request %d: error %v, want %v", i, err, tt.wantErr)
+				}
+				if d.Allowed {
+					allowed++
+				}
+			}
+			if allowed != tt.allowed {
+				t.Errorf("%d requests allowed, want %d", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestFailoverRateLimiterCircuit(t *testing.T) {
+	mr, client := newTestRedis(t)
+	limiter := NewFailoverRateLimiter(NewRateLimiter(client), failoverConfig("open"), zap.NewNop())
+	bucket := Bucket{Key: "rate_limit:test", Limit: 100, Window: time.Minute}
+	ctx := context.Background()
+
+	mr.SetError("LOADING Redis is loading the dataset in memory")
+	for i := 0; i < 2; i++ {
+		_, _ = limiter.Allow(ctx, 1, bucket)
+	}
+	// The circuit is open: Redis is left alone until the cooldown passes.
+	calls := mr.CommandCount()
+	for i := 0; i < 5; i++ {
+		if d, _ := limiter.Allow(ctx, 1, bucket); !d.Allowed || d.Limit != 0 {
+			t.Fatalf("open circuit: got %+v, want allowed without a quota", d)
+		}
+	}
+	if mr.CommandCount() != calls {
+		t.Fatalf("%d Redis calls with the circuit open", mr.CommandCount()-calls)
+	}
+
+	// A failed probe keeps it open.
+	time.Sleep(30 * time.Millisecond)
+	_, _ = limiter.Allow(ctx, 1, bucket)
+	calls = mr.CommandCount()
+	_, _ = limiter.Allow(ctx, 1, bucket)
+	if mr.CommandCount() != calls {
+		t.Fatal("circuit closed by a failed probe")
+	}
+
+	// A successful one closes it.
+	mr.SetError("")
+	time.Sleep(30 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		d, err := limiter.Allow(ctx, 1, bucket)
+		if err != nil || d.Limit != 100 {
+			t.Fatalf("after Redis recovered: got %+v, %v, want a Redis decision", d, err)
+		}
+	}
+}
+
+func TestFailoverRateLimiterDo(t *testing.T) {
+	mr, client := newTestRedis(t)
+	limiter := NewFailoverRateLimiter(NewRateLimiter(client), failoverConfig("open"), zap.NewNop())
+	ctx := context.Background()
+
+	if err := limiter.Do(ctx, func(ctx context.Context, c *redis.Client) error {
+		return c.Set(ctx, "k", "v", 0).Err()
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := mr.Get("k"); got != "v" {
+		t.Fatalf("k = %q, want v", got)
+	}
+
+	// Do's failures open the circuit Allow shares.
+	failing := func(context.Context, *redis.Client) error { return errors.New("i/o timeout") }
+	_ = limiter.Do(ctx, failing)
+	_ = limiter.Do(ctx, failing)
+	called := false
+	err := limiter.Do(ctx, func(context.Context, *redis.Client) error {
+		called = true
+		return nil
+	})
+	if called || !errors.Is(err, ErrRateLimitUnavailable) {
+		t.Errorf("open circuit: called %v, error %v", called, err)
+	}
+}
+
+// A request whose client went away mid-call says nothing about Redis.
+func TestFailoverRateLimiterIgnoresCancelledRequests(t *testing.T) {
+	_, client := newTestRedis(t)
+	limiter := NewFailoverRateLimiter(NewRateLimiter(client), failoverConfig("closed"), zap.NewNop())
+
+	for i := 0; i < 5; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		_ = limiter.Do(ctx, func(ctx context.Context, _ *redis.Client) error {
+			cancel()
+			return ctx.Err()
+		})
+	}
+	if d, err := limiter.Allow(context.Background(), 1, Bucket{Key: "k", Limit: 1, Window: time.Minute}); err != nil || !d.Allowed {
+		t.Errorf("got %+v, %v, want the circuit still closed", d, err)
+	}
+}
+
+func TestLocalRateLimiter(t *testing.T) {
+	limiter := NewLocalRateLimiter()
+	ctx := context.Background()
+	route := Bucket{Key: "route", Limit: 2, Window: time.Minute}
+	user := Bucket{Key: "user", Limit: 10, Window: time.Minute}
+
+	for i, remaining := range []int{1, 0} {
+		d, _ := limiter.Allow(ctx, 1, route, user)
+		if !d.Allowed || d.Remaining != remaining || d.Limit != 2 {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i, d, remaining)
+		}
+	}
+	d, _ := limiter.Allow(ctx, 1, route, user)
+	if d.Allowed || d.RetryAfter <= 29*time.Second || d.RetryAfter > 30*time.Second {
+		t.Fatalf("over quota: got %+v, want denied for about 30s", d)
+	}
+
+	// The denied request was charged to neither bucket.
+	if d, _ := limiter.Allow(ctx, 1, user); d.Remaining != 7 {
+		t.Errorf("user bucket: got %+v, want 7 remaining", d)
+	}
+}
@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+)
+
+// RateLimitPolicy decides which quotas a request counts against: the
+// user's quota for the route and their ceiling across all routes, both set
+// by their plan, at a cost set per route.
+type RateLimitPolicy struct {
+	rules atomic.Pointer[rateLimitRules]
+}
+
+// rateLimitRules is a parsed RateLimitConfig. It is never modified once
+// stored.
+type rateLimitRules struct {
+	defaultPlan planLimits
+	plans       map[string]planLimits
+	// costs is keyed by method and route template, with an empty method
+	// for costs that apply to any method.
+	costs  map[routeKey]int
+	exempt map[string]bool
+	auth   config.AuthRateLimitConfig
+}
+
+type planLimits struct {
+	requests     int
+	userRequests int
+	window       time.Duration
+}
+
+type routeKey struct {
+	method string
+	path   string
+}
+
+func NewRateLimitPolicy(cfg config.RateLimitConfig) *RateLimitPolicy {
+	p := &RateLimitPolicy{}
+	p.SetConfig(cfg)
+	return p
+}
+
+// SetConfig replaces the policy. It is called when the configuration is
+// reloaded.
+func (p *RateLimitPolicy) SetConfig(cfg config.RateLimitConfig) {
+	rules := &rateLimitRules{
+		defaultPlan: planLimits{
+			requests:     cfg.Requests,
+			userRequests: cfg.UserRequests,
+			window:       cfg.Window,
+		},
+		plans:  make(map[string]planLimits, len(cfg.Plans)),
+		costs:  make(map[routeKey]int, len(cfg.Routes)),
+		exempt: make(map[string]bool, len(cfg.ExemptUsers)),
+		auth:   cfg.Auth,
+	}
+	for name, plan := range cfg.Plans {
+		limits := planLimits{
+			requests:     plan.Requests,
+			userRequests: plan.UserRequests,
+			window:       plan.Window,
+		}
+		if limits.window == 0 {
+			limits.window = cfg.Window
+		}
+		rules.plans[name] = limits
+	}
+	for _, route := range cfg.Routes {
+		rules.costs[routeKey{method: route.Method, path: route.Path}] = route.Cost
+	}
+	for _, id := range cfg.ExemptUsers {
+		rules.exempt[id] = true
+	}
+	p.rules.Store(rules)
+}
+
+// Buckets returns the cost of a request to route and the buckets it is
+// charged to. It returns no buckets for exempt users.
+func (p *RateLimitPolicy) Buckets(userID, plan, method, route string) (int, []Bucket) {
+	rules := p.rules.Load()
+	if rules.exempt[userID] {
+		return 0, nil
+	}
+
+	limits, ok := rules.plans[plan]
+	if !ok {
+		limits = rules.defaultPlan
+	}
+
+	cost, ok := rules.costs[routeKey{method: method, path: route}]
+	if !ok {
+		if cost, ok = rules.costs[routeKey{path: route}]; !ok {
+			cost = 1
+		}
+	}
+
+	if route == "" {
+		route = "unmatched"
+	}
+	buckets := []Bucket{{
+		Key:    "rate_limit:route:" + userID + ":" + method + " " + route,
+		Limit:  limits.requests,
+		Window: limits.window,
+	}}
+	if limits.userRequests > 0 {
+		buckets = append(buckets, Bucket{
+			Key:    "rate_limit:user:" + userID,
+			Limit:  limits.userRequests,
+			Window: limits.window,
+		})
+	}
+	return cost, buckets
+}
+
+// AuthBuckets returns the buckets an attempt to authenticate from ip as
+// email is charged to. email is empty when the request names none, as for
+// a token refresh.
+func (p *RateLimitPolicy) AuthBuckets(ip, email string) []Bucket {
+	auth := p.rules.Load().auth
+	buckets := []Bucket{{
+		Key:    "rate_limit:auth:ip:" + ip,
+		Limit:  auth.IPRequests,
+		Window: auth.Window,
+	}}
+	if email != "" {
+		buckets = append(buckets, Bucket{
+			Key:    "rate_limit:auth:email:" + email,
+			Limit:  auth.EmailRequests,
+			Window: auth.Window,
+		})
+	}
+	return buckets
+}
+
+// authBackoff returns the settings for blocking repeated failures.
+func (p *RateLimitPolicy) authBackoff() config.AuthRateLimitConfig {
+	return p.rules.Load().auth
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+)
+
+func TestRateLimitPolicyBuckets(t *testing.T) {
+	policy := NewRateLimitPolicy(config.RateLimitConfig{
+		Requests:     100,
+		UserRequests: 300,
+		Window:       time.Minute,
+		Plans: map[string]config.RateLimitPlan{
+			"pro":      {Requests: 1000, UserRequests: 3000},
+			"internal": {Requests: 50, Window: time.Second},
+		},
+		Routes: []config.RouteCost{
+			{Path: "/api/v1/analytics/calculate", Cost: 10},
+			{Method: "GET", Path: "/api/v1/analytics/historical/:symbol", Cost: 5},
+			{Path: "/api/v1/analytics/historical/:symbol", Cost: 2},
+		},
+		ExemptUsers: []string{"svc-reports"},
+	})
+
+	tests := []struct {
+		name        string
+		userID      string
+		plan        string
+		method      string
+		route       string
+		wantCost    int
+		wantBuckets []Bucket
+	}{
+		{
+			name:   "default plan",
+			userID: "u1", method: "GET", route: "/api/v1/dashboards",
+			wantCost: 1,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u1:GET /api/v1/dashboards", Limit: 100, Window: time.Minute},
+				{Key: "rate_limit:user:u1", Limit: 300, Window: time.Minute},
+			},
+		},
+		{
+			name:   "unknown plan gets the default",
+			userID: "u1", plan: "enterprise", method: "GET", route: "/api/v1/dashboards",
+			wantCost: 1,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u1:GET /api/v1/dashboards", Limit: 100, Window: time.Minute},
+				{Key: "rate_limit:user:u1", Limit: 300, Window: time.Minute},
+			},
+		},
+		{
+			name:   "plan limits and route cost",
+			userID: "u2", plan: "pro", method: "POST", route: "/api/v1/analytics/calculate",
+			wantCost: 10,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u2:POST /api/v1/analytics/calculate", Limit: 1000, Window: time.Minute},
+				{Key: "rate_limit:user:u2", Limit: 3000, Window: time.Minute},
+			},
+		},
+		{
+			name:   "method cost wins over the route's",
+			userID: "u2", plan: "pro", method: "GET", route: "/api/v1/analytics/historical/:symbol",
+			wantCost: 5,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u2:GET /api/v1/analytics/historical/:symbol", Limit: 1000, Window: time.Minute},
+				{Key: "rate_limit:user:u2", Limit: 3000, Window: time.Minute},
+			},
+		},
+		{
+			name:   "route cost for other methods",
+			userID: "u2", plan: "pro", method: "HEAD", route: "/api/v1/analytics/historical/:symbol",
+			wantCost: 2,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u2:HEAD /api/v1/analytics/historical/:symbol", Limit: 1000, Window: time.Minute},
+				{Key: "rate_limit:user:u2", Limit: 3000, Window: time.Minute},
+			},
+		},
+		{
+			name:   "plan with its own window and no ceiling",
+			userID: "u3", plan: "internal", method: "GET", route: "/api/v1/dashboards",
+			wantCost: 1,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:u3:GET /api/v1/dashboards", Limit: 50, Window: time.Second},
+			},
+		},
+		{
+			name:   "unmatched route",
+			userID: "ip:192.0.2.1", method: "GET",
+			wantCost: 1,
+			wantBuckets: []Bucket{
+				{Key: "rate_limit:route:ip:192.0.2.1:GET unmatched", Limit: 100, Window: time.Minute},
+				{Key: "rate_limit:user:ip:192.0.2.1", Limit: 300, Window: time.Minute},
+			},
+		},
+		{
+			name:   "exempt user",
+			userID: "svc-reports", plan: "pro", method: "POST", route: "/api/v1/analytics/calculate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cost, buckets := policy.Buckets(tt.userID, tt.plan, tt.method, tt.route)
+			if cost != tt.wantCost {
+				t.Errorf("cost %d, want %d", cost, tt.wantCost)
+			}
+			if !reflect.DeepEqual(buckets, tt.wantBuckets) {
+				t.Errorf("buckets %+v, want %+v", buckets, tt.wantBuckets)
+			}
+		})
+	}
+}
+
+func TestRateLimitPolicySetConfig(t *testing.T) {
+	policy := NewRateLimitPolicy(config.RateLimitConfig{Requests: 10, Window: time.Minute})
+	policy.SetConfig(config.RateLimitConfig{Requests: 20, Window: time.Hour})
+
+	_, buckets := policy.Buckets("u1", "", "GET", "/quotes")
+	if len(buckets) != 1 || buckets[0].Limit != 20 || buckets[0].Window != time.Hour {
+		t.Errorf("buckets %+v, want the reloaded limits", buckets)
+	}
+}
+
+func TestRateLimitPolicyAuthBuckets(t *testing.T) {
+	policy := NewRateLimitPolicy(config.RateLimitConfig{
+		Auth: config.AuthRateLimitConfig{IPRequests: 20, EmailRequests: 5, Window: time.Minute},
+	})
+
+	want := []Bucket{
+		{Key: "rate_limit:auth:ip:192.0.2.1", Limit: 20, Window: time.Minute},
+		{Key: "rate_limit:auth:email:user@example.com", Limit: 5, Window: time.Minute},
+	}
+	if got := policy.AuthBuckets("192.0.2.1", "user@example.com"); !reflect.DeepEqual(got, want) {
+		t.Errorf("login buckets %+v, want %+v", got, want)
+	}
+	if got := policy.AuthBuckets("192.0.2.1", ""); !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("refresh buckets %+v, want %+v", got, want[:1])
+	}
+}
@@ -3,75 +3,218 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"github.com/financial-analytics/api-gateway/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 )
 
+// RateLimiter spends quota from rate limit buckets.
 type RateLimiter interface {
-	Allow(key string) bool
+	// Allow spends cost from every bucket, or from none if any of them
+	// lacks the quota. The decision describes the bucket with the least
+	// quota left.
+	Allow(ctx context.Context, cost int, buckets ...Bucket) (Decision, error)
 }
 
-type RedisRateLimiter struct {
-	client *redis.Client
-	rate   int
-	window time.Duration
+// Bucket is a quota of Limit requests per Window, identified by Key.
+type Bucket struct {
+	Key    string
+	Limit  int
+	Window time.Duration
 }
 
-func NewRateLimiter(client *redis.Client) RateLimiter {
-	return &RedisRateLimiter{
-		client: client,
-		rate:   100, // 100 requests
-		window: time.Minute,
-	}
+// Decision is the outcome of a rate limit check and the quota left after
+// it.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests allowed per window, and Remaining
+	// how many more could be made right now.
+	Limit     int
+	Remaining int
+	// RetryAfter is how long a rejected caller must wait before the next
+	// request would be allowed; ResetAfter is how long until the full quota
+	// is available again.
+	RetryAfter time.Duration
+	ResetAfter time.Duration
 }
 
-func (r *RedisRateLimiter) Allow(key string) bool {
-	ctx := context.Background()
-	now := time.Now()
-	windowStart := now.Add(-r.window)
-
-	pipe := r.client.Pipeline()
-
-	// Remove old entries
-	pipe.ZRemRangeByScore(ctx, key, "0", fmt.Sprintf("%d", windowStart.UnixNano()))
+// gcraScript implements the generic cell rate algorithm. Each key stores a
+// single value, the theoretical arrival time (TAT) of the next request in
+// microseconds: requests are spaced one emission interval apart, and a
+// request is allowed while the TAT is at most one window ahead of now, so
+// a full window's quota can be spent in a burst. Running as a script makes
+// checking and updating all of a request's buckets atomic, and Redis's
+// clock is shared by all gateway replicas.
+//
+// ARGV[1] is the cost of the request, followed by the emission interval
+// and window of each key, both in microseconds. It returns {allowed,
+// remaining, retry after, reset after, index}, durations in microseconds,
+// where remaining and reset after are those of the key with the least
+// quota left, at index.
+var gcraScript = redis.NewScript(`
+local cost = tonumber(ARGV[1])
+
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+
+local allowed = 1
+local retry_after = 0
+local tats = {}
+for i, key in ipairs(KEYS) do
+	local emission = tonumber(ARGV[i * 2])
+	local window = tonumber(ARGV[i * 2 + 1])
+	local tat = tonumber(redis.call('GET', key))
+	if not tat or tat < now then
+		tat = now
+	end
+	tats[i] = tat
+	local allow_at = tat + emission * cost - window
+	if allow_at > now then
+		allowed = 0
+		retry_after = math.max(retry_after, allow_at - now)
+	end
+end
+
+local remaining, reset_after, index
+for i, key in ipairs(KEYS) do
+	local emission = tonumber(ARGV[i * 2])
+	local window = tonumber(ARGV[i * 2 + 1])
+	local tat = tats[i]
+	if allowed == 1 then
+		tat = tat + emission * cost
+		redis.call('SET', key, string.format('%.0f', tat), 'PX', math.max(1, math.ceil((tat - now) / 1000)))
+	end
+	local left = math.floor((window - (tat - now)) / emission)
+	if not remaining or left < remaining then
+		remaining, reset_after, index = left, tat - now, i
+	end
+end
+
+return {allowed, remaining, retry_after, reset_after, index}
+`)
+
+// RedisRateLimiter keeps buckets in Redis, so they are shared by all
+// gateway replicas. It returns an error whenever Redis does.
+type RedisRateLimiter struct {
+	client *redis.Client
+}
 
-	// Count current window
-	count := pipe.ZCard(ctx, key)
+func NewRateLimiter(client *redis.Client) *RedisRateLimiter {
+	return &RedisRateLimiter{client: client}
+}
 
-	// Add current request
-	pipe.ZAdd(ctx, key, redis.Z{
-		Score:  float64(now.UnixNano()),
-		Member: now.UnixNano(),
-	})
+func (r *RedisRateLimiter) Allow(ctx context.Context, cost int, buckets ...Bucket) (Decision, error) {
+	if len(buckets) == 0 {
+		return Decision{Allowed: true}, nil
+	}
 
-	// Set expiration
-	pipe.Expire(ctx, key, r.window)
+	keys := make([]string, len(buckets))
+	args := make([]interface{}, 0, 1+2*len(buckets))
+	args = append(args, cost)
+	for i, b := range buckets {
+		keys[i] = b.Key
+		window := float64(b.Window.Microseconds())
+		args = append(args,
+			strconv.FormatFloat(window/float64(b.Limit), 'f', -1, 64),
+			strconv.FormatFloat(window, 'f', -1, 64),
+		)
+	}
 
-	_, err := pipe.Exec(ctx)
+	res, err := gcraScript.Run(ctx, r.client, keys, args...).Int64Slice()
 	if err != nil {
-		return false
+		return Decision{}, fmt.Errorf("rate limit: %w", err)
+	}
+	if len(res) != 5 || res[4] < 1 || int(res[4]) > len(buckets) {
+		return Decision{}, fmt.Errorf("rate limit: unexpected reply %v", res)
 	}
 
-	return count.Val() < int64(r.rate)
+	return Decision{
+		Allowed:    res[0] == 1,
+		Limit:      buckets[res[4]-1].Limit,
+		Remaining:  int(max(res[1], 0)),
+		RetryAfter: time.Duration(res[2]) * time.Microsecond,
+		ResetAfter: time.Duration(res[3]) * time.Microsecond,
+	}, nil
 }
 
-func RateLimit(limiter RateLimiter) gin.HandlerFunc {
+// RateLimit rejects requests over the caller's quota with 429, applying
+// policy to the authenticated user, or to the client address. Every
+// response carries X-RateLimit-Limit, X-RateLimit-Remaining and
+// X-RateLimit-Reset, the Unix time at which the full quota is available
+// again; rejections also carry Retry-After in seconds. If the limiter
+// fails, the request is rejected with 503; wrap it in a FailoverRateLimiter
+// to choose how Redis outages are handled.
+func RateLimit(limiter RateLimiter, policy *RateLimitPolicy) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userID := c.GetString("user_id")
-		key := fmt.Sprintf("rate_limit:%s:%s", userID, c.Request.URL.Path)
-
-		if !limiter.Allow(key) {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":       "Rate limit exceeded",
-				"retry_after": 60,
-			})
-			c.Abort()
+		var plan string
+		if claims, ok := c.Get("user_claims"); ok {
+			plan = claims.(*services.Claims).Plan
+		}
+		// Unauthenticated requests are limited by client address.
+		id := c.GetString("user_id")
+		if id == "" {
+			id = "ip:" + c.ClientIP()
+		}
+		cost, buckets := policy.Buckets(id, plan, c.Request.Method, c.FullPath())
+		if len(buckets) == 0 {
+			c.Next()
 			return
 		}
 
+		if !enforce(c, limiter, cost, buckets) {
+			return
+		}
 		c.Next()
 	}
 }
+
+// enforce charges cost to buckets and sets the quota headers. If the
+// request is over quota, or the limiter fails, it responds and aborts, and
+// reports false.
+func enforce(c *gin.Context, limiter RateLimiter, cost int, buckets []Bucket) bool {
+	decision, err := limiter.Allow(c.Request.Context(), cost, buckets...)
+	if err != nil {
+		_ = c.Error(err)
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       "Rate limiting unavailable",
+			"retry_after": 1,
+		})
+		return false
+	}
+	// A limiter that is failing open reports no quota.
+	if decision.Limit > 0 {
+		h := c.Writer.Header()
+		h.Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		h.Set("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(decision.ResetAfter).Unix(), 10))
+	}
+	if !decision.Allowed {
+		rejectRateLimited(c, decision.RetryAfter, "Rate limit exceeded")
+		return false
+	}
+	return true
+}
+
+// rejectRateLimited responds 429, telling the client to wait retryAfter.
+func rejectRateLimited(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := ceilSeconds(retryAfter)
+	metrics.RateLimitRejections.WithLabelValues(c.FullPath()).Inc()
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       message,
+		"retry_after": seconds,
+	})
+}
+
+// ceilSeconds rounds d up to whole seconds, and to at least one, so a
+// client honouring Retry-After does not retry too early.
+func ceilSeconds(d time.Duration) int {
+	return max(int(math.Ceil(d.Seconds())), 1)
+}
@@ -0,0 +1,158 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis starts a miniredis whose clock, used by the GCRA script's
+// TIME call, is fixed until the test moves it.
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Unix(1700000000, 0))
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	return mr, client
+}
+
+func TestRedisRateLimiterBurstAndRefill(t *testing.T) {
+	mr, client := newTestRedis(t)
+	limiter := NewRateLimiter(client)
+	ctx := context.Background()
+	// 5 per minute: one request is earned back every 12s.
+	bucket := Bucket{Key: "rate_limit:test", Limit: 5, Window: time.Minute}
+
+	for i := 0; i < 5; i++ {
+		d, err := limiter.Allow(ctx, 1, bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Allowed || d.Remaining != 4-i || d.Limit != 5 {
+			t.Fatalf("request %d: got %+v, want allowed with %d remaining", i, d, 4-i)
+		}
+	}
+
+	d, err := limiter.Allow(ctx, 1, bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Allowed || d.Remaining != 0 {
+		t.Fatalf("request over the burst: got %+v, want denied", d)
+	}
+	if d.RetryAfter != 12*time.Second {
+		t.Errorf("retry after: got %v, want 12s", d.RetryAfter)
+	}
+	if d.ResetAfter != time.Minute {
+		t.Errorf("reset after: got %v, want 1m", d.ResetAfter)
+	}
+
+	mr.SetTime(time.Unix(1700000000, 0).Add(12 * time.Second))
+	if d, err = limiter.Allow(ctx, 1, bucket); err != nil || !d.Allowed {
+		t.Fatalf("after one emission interval: got %+v, %v, want allowed", d, err)
+	}
+	if d, err = limiter.Allow(ctx, 1, bucket); err != nil || d.Allowed {
+		t.Fatalf("second request after one interval: got %+v, %v, want denied", d, err)
+	}
+}
+
+func TestRedisRateLimiterCost(t *testing.T) {
+	_, client := newTestRedis(t)
+	limiter := NewRateLimiter(client)
+	ctx := context.Background()
+	bucket := Bucket{Key: "rate_limit:test", Limit: 10, Window: time.Minute}
+
+	d, err := limiter.Allow(ctx, 8, bucket)
+	if err != nil || !d.Allowed || d.Remaining != 2 {
+		t.Fatalf("cost 8: got %+v, %v, want allowed with 2 remaining", d, err)
+	}
+	d, err = limiter.Allow(ctx, 3, bucket)
+	if err != nil || d.Allowed || d.RetryAfter != 6*time.Second {
+		t.Fatalf("cost 3 with 2 left: got %+v, %v, want denied for 6s", d, err)
+	}
+}
+
+// A request costing nothing leaves the TAT at now; the key's expiry must
+// still be positive, which Redis requires of PX.
+func TestRedisRateLimiterZeroCost(t *testing.T) {
+	_, client := newTestRedis(t)
+	limiter := NewRateLimiter(client)
+	bucket := Bucket{Key: "rate_limit:test", Limit: 10, Window: time.Minute}
+
+	d, err := limiter.Allow(context.Background(), 0, bucket)
+	if err != nil {
+		t.Fatalf("cost 0: %v", err)
+	}
+	if !d.Allowed || d.Remaining != 10 {
+		t.Fatalf("cost 0: got %+v, want allowed with 10 remaining", d)
+	}
+}
+
+func TestRedisRateLimiterSpendsAllBucketsOrNone(t *testing.T) {
+	_, client := newTestRedis(t)
+	limiter := NewRateLimiter(client)
+	ctx := context.Background()
+	route := Bucket{Key: "rate_limit:route", Limit: 1, Window: time.Minute}
+	user := Bucket{Key: "rate_limit:user", Limit: 10, Window: time.Minute}
+
+	if d, err := limiter.Allow(ctx, 1, route, user); err != nil || !d.Allowed {
+		t.Fatalf("first request: got %+v, %v", d, err)
+	}
+	d, err := limiter.Allow(ctx, 1, route, user)
+	if err != nil || d.Allowed || d.Limit != 1 {
+		t.Fatalf("second request: got %+v, %v, want denied by the route bucket", d, err)
+	}
+
+	// The denied request must not have been charged to the user bucket.
+	d, err = limiter.Allow(ctx, 1, user)
+	if err != nil || d.Remaining != 8 {
+		t.Fatalf("user bucket: got %+v, %v, want 8 remaining", d, err)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	_, client := newTestRedis(t)
+	policy := NewRateLimitPolicy(config.RateLimitConfig{Requests: 2, Window: time.Minute})
+
+	router := gin.New()
+	router.GET("/quotes", RateLimit(NewRateLimiter(client), policy), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/quotes", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		w := get()
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: got %d, want 200", i, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit %q, want 2", i, got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != remaining {
+			t.Errorf("request %d: X-RateLimit-Remaining %q, want %s", i, got, remaining)
+		}
+	}
+
+	w := get()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over quota: got %d, want 429", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After %q, want 30", got)
+	}
+}
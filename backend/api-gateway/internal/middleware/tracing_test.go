@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordSpans installs a tracer provider recording every span for the
+// rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	prevProvider, prevPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prevProvider)
+		otel.SetTextMapPropagator(prevPropagator)
+	})
+	return recorder
+}
+
+func TestTracing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := recordSpans(t)
+
+	var handlerSpan trace.SpanContext
+	router := gin.New()
+	router.Use(Tracing())
+	router.GET("/quotes/:symbol", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Set("user_id", "u1")
+		c.Status(http.StatusOK)
+	})
+	router.GET("/fail", func(c *gin.Context) {
+		_ = c.Error(errors.New("upstream timed out"))
+		c.Status(http.StatusBadGateway)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/quotes/AAPL", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("%d spans, want 2", len(spans))
+	}
+
+	quote := spans[0]
+	if quote.Name() != "GET /quotes/:symbol" || quote.SpanKind() != trace.SpanKindServer {
+		t.Errorf("span %q of kind %v, want a server span named after the route", quote.Name(), quote.SpanKind())
+	}
+	if got := quote.Parent().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" || !quote.Parent().IsRemote() {
+		t.Errorf("parent trace %s, want the caller's trace continued", got)
+	}
+	if handlerSpan.SpanID() != quote.SpanContext().SpanID() {
+		t.Error("handler does not see the request span in its context")
+	}
+	attrs := attribute.NewSet(quote.Attributes()...)
+	for key, want := range map[attribute.Key]attribute.Value{
+		"http.route":       attribute.StringValue("/quotes/:symbol"),
+		"http.target":      attribute.StringValue("/quotes/AAPL"),
+		"http.status_code": attribute.IntValue(http.StatusOK),
+		"enduser.id":       attribute.StringValue("u1"),
+	} {
+		if got, ok := attrs.Value(key); !ok || got != want {
+			t.Errorf("%s = %v, want %v", key, got.Emit(), want.Emit())
+		}
+	}
+	if quote.Status().Code != codes.Unset {
+		t.Errorf("successful request has status %v", quote.Status())
+	}
+
+	fail := spans[1]
+	if fail.Parent().IsValid() {
+		t.Error("request without a traceparent has a parent")
+	}
+	if fail.Status().Code != codes.Error || len(fail.Events()) != 1 || fail.Events()[0].Name != "exception" {
+		t.Errorf("failed request: status %v, events %v, want an error with the recorded exception", fail.Status(), fail.Events())
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	authv1 "github.com/financial-analytics/api-gateway/internal/proto/auth/v1"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+// ErrAuthUnavailable is returned when auth-service cannot be reached, as
+// opposed to a token that was checked and rejected.
+var ErrAuthUnavailable = errors.New("auth service unavailable")
+
+// GRPCAuthService verifies tokens by calling auth-service over gRPC. Calls are
+// spread over a small pool of connections and successful verifications are
+// cached briefly, so most requests never leave the gateway.
+type GRPCAuthService struct {
+	conns   []*grpc.ClientConn
+	clients []authv1.AuthServiceClient
+	next    atomic.Uint32
+	timeout time.Duration
+	cache   *verifyCache
+}
+
+func NewGRPCAuthService(cfg *config.Config) (*GRPCAuthService, error) {
+	poolSize := cfg.Auth.PoolSize
+	if poolSize < 1 {
+		poolSize = 1
+	}
+
+	s := &GRPCAuthService{
+		timeout: cfg.Auth.RequestTimeout,
+		cache:   newVerifyCache(cfg.Auth.CacheTTL, cfg.Auth.CacheSize),
+	}
+
+	for i := 0; i < poolSize; i++ {
+		conn, err := grpc.Dial(cfg.Auth.GRPCAddress,
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+			grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+			grpc.WithKeepaliveParams(keepalive.ClientParameters{
+				Time:                30 * time.Second,
+				Timeout:             5 * time.Second,
+				PermitWithoutStream: true,
+			}),
+		)
+		if err != nil {
+			_ = s.Close()
+			return nil, fmt.Errorf("dial auth service: %w", err)
+		}
+		s.conns = append(s.conns, conn)
+		s.clients = append(s.clients, authv1.NewAuthServiceClient(conn))
+	}
+
+	return s, nil
+}
+
+func (s *GRPCAuthService) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
+	key := sha256.Sum256([]byte(tokenString))
+	if claims, ok := s.cache.get(key); ok {
+		return claims, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	resp, err := s.client().VerifyToken(ctx, &authv1.VerifyTokenRequest{Token: tokenString})
+	if err != nil {
+		return nil, mapAuthError(err)
+	}
+
+	claims := &Claims{
+		UserID:    resp.GetUserId(),
+		Email:     resp.GetEmail(),
+		Plan:      resp.GetPlan(),
+		Role:      resp.GetRole(),
+		Scopes:    resp.GetScopes(),
+		SessionID: resp.GetSessionId(),
+	}
+	if resp.GetExpiresAt() != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(resp.GetExpiresAt().AsTime())
+	}
+
+	s.cache.put(key, claims)
+	return claims, nil
+}
+
+// ValidateAPIKey verifies an API key with auth-service. Verifications are
+// cached like tokens', so a revoked key keeps working for up to the cache
+// TTL.
+func (s *GRPCAuthService) ValidateAPIKey(ctx context.Context, apiKey string) (*Claims, error) {
+	// Keys and tokens share the cache; the prefix keeps their entries apart.
+	key := sha256.Sum256([]byte("apikey:" + apiKey))
+	if claims, ok := s.cache.get(key); ok {
+		return claims, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	resp, err := s.client().VerifyAPIKey(ctx, &authv1.VerifyAPIKeyRequest{Key: apiKey})
+	if err != nil {
+		return nil, mapAuthError(err)
+	}
+
+	claims := &Claims{
+		UserID:   resp.GetUserId(),
+		Email:    resp.GetEmail(),
+		Plan:     resp.GetPlan(),
+		APIKeyID: resp.GetKeyId(),
+		Scopes:   resp.GetScopes(),
+	}
+	if resp.GetExpiresAt() != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(resp.GetExpiresAt().AsTime())
+	}
+
+	s.cache.put(key, claims)
+	return claims, nil
+}
+
+// RevokeSession revokes a login session in auth-service and drops any cached
+// verifications for it from this replica. Other replicas keep honouring the
+// session's tokens for up to the cache TTL.
+func (s *GRPCAuthService) RevokeSession(ctx context.Context, sessionID, userID string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	_, err := s.client().RevokeSession(ctx, &authv1.RevokeSessionRequest{
+		SessionId: sessionID,
+		UserId:    userID,
+	})
+	if err != nil {
+		return mapAuthError(err)
+	}
+
+	s.cache.evictSession(sessionID)
+	return nil
+}
+
+func (s *GRPCAuthService) Close() error {
+	var errs []error
+	for _, conn := range s.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *GRPCAuthService) client() authv1.AuthServiceClient {
+	n := s.next.Add(1)
+	return s.clients[int(n)%len(s.clients)]
+}
+
+// refreshTokenReason is the ErrorInfo reason auth-service attaches when a
+// refresh token is presented as an access token.
+const refreshTokenReason = "REFRESH_TOKEN_USAGE"
+
+func mapAuthError(err error) error {
+	st := status.Convert(err)
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.GetReason() == refreshTokenReason {
+			return ErrRefreshTokenUsage
+		}
+	}
+
+	switch st.Code() {
+	case codes.Unauthenticated, codes.InvalidArgument, codes.NotFound:
+		return fmt.Errorf("%w: %s", ErrInvalidToken, st.Message())
+	default:
+		return fmt.Errorf("%w: %v", ErrAuthUnavailable, err)
+	}
+}
+
+type cacheEntry struct {
+	claims  *Claims
+	expires time.Time
+}
+
+// verifyCache holds successful verifications keyed by token hash. Entries
+// never outlive the token itself, and the cache is cleared when full rather
+// than tracking recency; at the default TTL it refills within seconds.
+type verifyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[[sha256.Size]byte]cacheEntry
+}
+
+func newVerifyCache(ttl time.Duration, size int) *verifyCache {
+	return &verifyCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+func (c *verifyCache) get(key [sha256.Size]byte) (*Claims, bool) {
+	if c.ttl <= 0 {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.claims, true
+}
+
+func (c *verifyCache) put(key [sha256.Size]byte, claims *Claims) {
+	if c.ttl <= 0 {
+		return
+	}
+
+	expires := time.Now().Add(c.ttl)
+	if claims.ExpiresAt != nil && claims.ExpiresAt.Before(expires) {
+		expires = claims.ExpiresAt.Time
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size > 0 && len(c.entries) >= c.size {
+		c.entries = make(map[[sha256.Size]byte]cacheEntry)
+	}
+	c.entries[key] = cacheEntry{claims: claims, expires: expires}
+}
+
+func (c *verifyCache) evictSession(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.claims.SessionID == sessionID {
+			delete(c.entries, key)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	authv1 "github.com/financial-analytics/api-gateway/internal/proto/auth/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// fakeAuthClient answers VerifyAPIKey for the keys it knows and counts
+// the calls it gets.
+type fakeAuthClient struct {
+	authv1.AuthServiceClient
+	keys  map[string]*authv1.VerifyAPIKeyResponse
+	err   error
+	calls int
+}
+
+func (f *fakeAuthClient) VerifyAPIKey(_ context.Context, in *authv1.VerifyAPIKeyRequest, _ ...grpc.CallOption) (*authv1.VerifyAPIKeyResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	resp, ok := f.keys[in.GetKey()]
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return resp, nil
+}
+
+func newTestGRPCAuthService(client authv1.AuthServiceClient) *GRPCAuthService {
+	return &GRPCAuthService{
+		clients: []authv1.AuthServiceClient{client},
+		timeout: time.Second,
+		cache:   newVerifyCache(time.Minute, 100),
+	}
+}
+
+func TestGRPCValidateAPIKey(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	client := &fakeAuthClient{keys: map[string]*authv1.VerifyAPIKeyResponse{
+		"fa_0a1b2c3d4e5f_secret": {
+			KeyId:     "k1",
+			UserId:    "u1",
+			Email:     "user@example.com",
+			Plan:      "pro",
+			Scopes:    []string{ScopeReadOnly, ScopeAnalytics},
+			ExpiresAt: timestamppb.New(expires),
+		},
+	}}
+	svc := newTestGRPCAuthService(client)
+	ctx := context.Background()
+
+	claims, err := svc.ValidateAPIKey(ctx, "fa_0a1b2c3d4e5f_secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.APIKeyID != "k1" || claims.UserID != "u1" || claims.Email != "user@example.com" || claims.Plan != "pro" {
+		t.Errorf("claims %+v", claims)
+	}
+	if !slices.Equal(claims.Scopes, []string{ScopeReadOnly, ScopeAnalytics}) {
+		t.Errorf("scopes %q", claims.Scopes)
+	}
+	if claims.Role != "" || claims.SessionID != "" {
+		t.Errorf("API key claims carry role %q, session %q", claims.Role, claims.SessionID)
+	}
+	if claims.ExpiresAt == nil || !claims.ExpiresAt.Equal(expires) {
+		t.Errorf("expires at %v, want %v", claims.ExpiresAt, expires)
+	}
+
+	if _, err := svc.ValidateAPIKey(ctx, "fa_0a1b2c3d4e5f_secret"); err != nil {
+		t.Fatal(err)
+	}
+	if client.calls != 1 {
+		t.Errorf("%d calls to auth-service, want the second answered from the cache", client.calls)
+	}
+}
+
+func TestGRPCValidateAPIKeyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "rejected", want: ErrInvalidToken},
+		{name: "auth-service down", err: status.Error(codes.Unavailable, "connection refused"), want: ErrAuthUnavailable},
+		{name: "timed out", err: status.Error(codes.DeadlineExceeded, "deadline exceeded"), want: ErrAuthUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeAuthClient{err: tt.err}
+			svc := newTestGRPCAuthService(client)
+
+			for i := 0; i < 2; i++ {
+				if _, err := svc.ValidateAPIKey(context.Background(), "fa_0a1b2c3d4e5f_guess"); !errors.Is(err, tt.want) {
+					t.Fatalf("error %v, want %v", err, tt.want)
+				}
+			}
+			if client.calls != 2 {
+				t.Errorf("%d calls to auth-service, want failures never cached", client.calls)
+			}
+		})
+	}
+}
+
+// A token and an API key with the same text must not share a cache entry.
+func TestGRPCValidateAPIKeyCacheIsSeparateFromTokens(t *testing.T) {
+	svc := newTestGRPCAuthService(&fakeAuthClient{})
+	svc.cache.put(sha256.Sum256([]byte("same")), &Claims{UserID: "token-user", Role: RoleAdmin})
+
+	if claims, err := svc.ValidateAPIKey(context.Background(), "same"); err == nil {
+		t.Errorf("API key validated from a token's cache entry: %+v", claims)
+	}
+}
@@ -1,40 +1,137 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"slices"
 
 	"github.com/financial-analytics/api-gateway/internal/config"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// tokenTypeRefresh marks refresh tokens issued by auth-service. They may only
+// be exchanged for new access tokens, never used to call the API.
+const tokenTypeRefresh = "refresh"
+
+var (
+	ErrInvalidToken      = errors.New("invalid token")
+	ErrRefreshTokenUsage = errors.New("refresh token used as access token")
+)
+
 type AuthService interface {
-	ValidateToken(token string) (*Claims, error)
+	ValidateToken(ctx context.Context, token string) (*Claims, error)
+	// ValidateAPIKey authenticates a user-managed API key, returning the
+	// key's owner and scopes.
+	ValidateAPIKey(ctx context.Context, key string) (*Claims, error)
 }
 
+// Scopes grant access to groups of routes. Access tokens carry every scope;
+// API keys carry those their owner chose, from read-only, analytics and
+// dashboards:write.
+const (
+	ScopeReadOnly        = "read-only"
+	ScopeAnalytics       = "analytics"
+	ScopeDashboardsWrite = "dashboards:write"
+	ScopeProfileWrite    = "profile:write"
+	ScopeWatchlistsWrite = "watchlists:write"
+	ScopeAlertsWrite     = "alerts:write"
+	ScopeAPIKeys         = "api-keys"
+)
+
+// SessionScopes are the scopes of every access token, mirroring
+// auth-service.
+var SessionScopes = []string{
+	ScopeReadOnly,
+	ScopeAnalytics,
+	ScopeDashboardsWrite,
+	ScopeProfileWrite,
+	ScopeWatchlistsWrite,
+	ScopeAlertsWrite,
+	ScopeAPIKeys,
+}
+
+// Roles of users. API keys carry no role.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    string   `json:"user_id"`
+	Email     string   `json:"email"`
+	Plan      string   `json:"plan,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+	Type      string   `json:"type,omitempty"`
+	Role      string   `json:"role,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+	// APIKeyID is set when the caller used an API key.
+	APIKeyID string `json:"api_key_id,omitempty"`
 	jwt.RegisteredClaims
 }
 
 type authService struct {
-	cfg *config.Config
+	cfg    *config.Config
+	key    []byte
+	parser *jwt.Parser
 }
 
 func NewAuthService(cfg *config.Config) AuthService {
-	return &authService{cfg: cfg}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithLeeway(cfg.Auth.ClockSkew),
+	}
+	if cfg.Auth.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(cfg.Auth.Issuer))
+	}
+	if cfg.Auth.Audience != "" {
+		opts = append(opts, jwt.WithAudience(cfg.Auth.Audience))
+	}
+
+	return &authService{
+		cfg:    cfg,
+		key:    []byte(cfg.Auth.JWTSecret),
+		parser: jwt.NewParser(opts...),
+	}
 }
 
-func (s *authService) ValidateToken(tokenString string) (*Claims, error) {
-	// TODO: Implement actual token validation via Auth Service (gRPC/REST)
-	// For now, this is a skeleton implementation to satisfy build requirements
+// ValidateToken verifies an HS256 access token issued by auth-service. The
+// signature, expiry, not-before, issuer and audience are all checked.
+// Tokens issued before roles existed are authorized as a user's, as
+// auth-service does when verifying them.
+func (s *authService) ValidateToken(_ context.Context, tokenString string) (*Claims, error) {
+	if len(s.key) == 0 {
+		return nil, fmt.Errorf("%w: no signing key configured", ErrInvalidToken)
+	}
+
+	claims := &Claims{}
+	_, err := s.parser.ParseWithClaims(tokenString, claims, func(*jwt.Token) (interface{}, error) {
+		return s.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
 
-	if tokenString == "valid-token" {
-		return &Claims{
-			UserID: "user-123",
-			Email:  "user@example.com",
-		}, nil
+	// The parser only validates exp when present; access tokens must expire.
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("%w: missing exp claim", ErrInvalidToken)
 	}
+	if claims.UserID == "" {
+		return nil, fmt.Errorf("%w: missing user_id claim", ErrInvalidToken)
+	}
+	if claims.Type == tokenTypeRefresh {
+		return nil, ErrRefreshTokenUsage
+	}
+	if claims.Role == "" || claims.Scopes == nil {
+		claims.Role = RoleUser
+		claims.Scopes = slices.Clone(SessionScopes)
+	}
+
+	return claims, nil
+}
 
-	return nil, errors.New("invalid token")
+// ValidateAPIKey always fails: API keys are stored by auth-service, so only
+// the gRPC client can check them.
+func (s *authService) ValidateAPIKey(context.Context, string) (*Claims, error) {
+	return nil, fmt.Errorf("%w: API keys require auth-service", ErrInvalidToken)
 }
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestValidateTokenAuthorization(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Auth.JWTSecret = "secret"
+	svc := NewAuthService(cfg)
+
+	tests := []struct {
+		name       string
+		claims     jwt.MapClaims
+		wantRole   string
+		wantScopes []string
+	}{
+		{
+			name:       "token issued before roles is a user's session",
+			claims:     jwt.MapClaims{},
+			wantRole:   RoleUser,
+			wantScopes: SessionScopes,
+		},
+		{
+			name:       "token with a role but no scopes is a user's session",
+			claims:     jwt.MapClaims{"role": RoleAdmin},
+			wantRole:   RoleUser,
+			wantScopes: SessionScopes,
+		},
+		{
+			name:       "role and scopes are kept",
+			claims:     jwt.MapClaims{"role": RoleAdmin, "scopes": []string{ScopeReadOnly}},
+			wantRole:   RoleAdmin,
+			wantScopes: []string{ScopeReadOnly},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.claims["user_id"] = "user-1"
+			tt.claims["exp"] = time.Now().Add(time.Minute).Unix()
+			token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, tt.claims).SignedString([]byte("secret"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := svc.ValidateToken(context.Background(), token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Role != tt.wantRole || !slices.Equal(claims.Scopes, tt.wantScopes) {
+				t.Errorf("got role %q, scopes %q, want %q, %q", claims.Role, claims.Scopes, tt.wantRole, tt.wantScopes)
+			}
+		})
+	}
+}
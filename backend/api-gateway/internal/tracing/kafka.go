@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"github.com/segmentio/kafka-go"
+)
+
+// MessageCarrier lets a propagator read and write trace context in Kafka
+// message headers.
+type MessageCarrier struct {
+	Message *kafka.Message
+}
+
+func (c MessageCarrier) Get(key string) string {
+	for _, h := range c.Message.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func (c MessageCarrier) Set(key, value string) {
+	for i, h := range c.Message.Headers {
+		if h.Key == key {
+			c.Message.Headers[i].Value = []byte(value)
+			return
+		}
+	}
+	c.Message.Headers = append(c.Message.Headers, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+func (c MessageCarrier) Keys() []string {
+	keys := make([]string, len(c.Message.Headers))
+	for i, h := range c.Message.Headers {
+		keys[i] = h.Key
+	}
+	return keys
+}
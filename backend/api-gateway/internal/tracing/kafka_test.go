@@ -0,0 +1,41 @@
+package tracing
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMessageCarrier(t *testing.T) {
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+	propagator := propagation.TraceContext{}
+
+	msg := kafka.Message{Headers: []kafka.Header{
+		{Key: "content-type", Value: []byte("application/json")},
+		{Key: "traceparent", Value: []byte("stale")},
+	}}
+	propagator.Inject(ctx, MessageCarrier{Message: &msg})
+
+	// The stale header is replaced rather than repeated.
+	carrier := MessageCarrier{Message: &msg}
+	if keys := carrier.Keys(); !slices.Equal(keys, []string{"content-type", "traceparent"}) {
+		t.Errorf("headers %q", keys)
+	}
+
+	got := trace.SpanContextFromContext(propagator.Extract(context.Background(), carrier))
+	if got.TraceID() != parent.TraceID() || got.SpanID() != parent.SpanID() || !got.IsSampled() || !got.IsRemote() {
+		t.Errorf("extracted %+v, want the injected span context", got)
+	}
+	if carrier.Get("missing") != "" {
+		t.Error("missing header is not empty")
+	}
+}
@@ -0,0 +1,64 @@
+// Package tracing sets up OpenTelemetry for the gateway and carries W3C
+// trace context across HTTP and Kafka.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// InstrumentationName names the tracer used for the gateway's own spans.
+const InstrumentationName = "github.com/financial-analytics/api-gateway"
+
+// Setup installs the W3C traceparent propagator and, when cfg.Endpoint is
+// set, a tracer provider exporting spans over OTLP/HTTP. Without an
+// endpoint the default no-op provider stays in place, so local runs need
+// no collector but still pass trace context on to upstream services. The
+// returned function flushes buffered spans.
+func Setup(ctx context.Context, cfg config.TracingConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse OTLP endpoint: %w", err)
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(endpoint.Host)}
+	if endpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if endpoint.Path != "" && endpoint.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create OTLP exporter: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName(cfg.ServiceName),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,168 @@
+package upstream
+
+import (
+	"sync"
+	"time"
+)
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets every request through.
+	Closed State = iota
+	// Open fails requests fast until its timeout has passed.
+	Open
+	// HalfOpen lets a limited number of trial requests through.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// Outcome is the result of a request the breaker let through.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Abandoned requests were cancelled by the client, which says nothing
+	// about the upstream.
+	Abandoned
+)
+
+// Breaker is a circuit breaker. It opens after a number of consecutive
+// failures, stays open for a timeout, and then closes once a number of
+// trial requests have all succeeded.
+type Breaker struct {
+	threshold   int
+	openTimeout time.Duration
+	trials      int
+	// onChange is called, with the breaker locked, whenever its state
+	// changes.
+	onChange func(from, to State)
+	// now is the breaker's clock.
+	now func() time.Time
+
+	mu        sync.Mutex
+	state     State
+	failures  int
+	openUntil time.Time
+	// inFlight and succeeded count the trial requests while half-open.
+	inFlight  int
+	succeeded int
+}
+
+func NewBreaker(threshold int, openTimeout time.Duration, trials int, onChange func(from, to State)) *Breaker {
+	return &Breaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		trials:      trials,
+		onChange:    onChange,
+		now:         time.Now,
+	}
+}
+
+// Allow reports whether a request may be sent and, if not, how long until
+// the breaker lets one through. Every allowed request must be followed by
+// a call to Done.
+func (b *Breaker) Allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == Open {
+		if wait := b.openUntil.Sub(b.now()); wait > 0 {
+			return false, wait
+		}
+		b.inFlight, b.succeeded = 0, 0
+		b.setState(HalfOpen)
+	}
+	if b.state == HalfOpen {
+		if b.inFlight >= b.trials {
+			return false, 0
+		}
+		b.inFlight++
+	}
+	return true, 0
+}
+
+// Done records the outcome of a request Allow let through.
+func (b *Breaker) Done(outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case Closed:
+		switch outcome {
+		case Success:
+			b.failures = 0
+		case Failure:
+			b.failures++
+			if b.failures >= b.threshold {
+				b.trip()
+			}
+		}
+	case HalfOpen:
+		if b.inFlight > 0 {
+			b.inFlight--
+		}
+		switch outcome {
+		case Success:
+			b.succeeded++
+			if b.succeeded >= b.trials {
+				b.failures = 0
+				b.setState(Closed)
+			}
+		case Failure:
+			b.trip()
+		}
+	}
+	// Requests sent before the breaker opened don't change it.
+}
+
+// BreakerStatus is a snapshot of a breaker.
+type BreakerStatus struct {
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	// OpenUntil is when an open breaker lets trial requests through.
+	OpenUntil *time.Time `json:"open_until,omitempty"`
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:               b.state.String(),
+		ConsecutiveFailures: b.failures,
+	}
+	if b.state == Open {
+		openUntil := b.openUntil
+		status.OpenUntil = &openUntil
+	}
+	return status
+}
+
+func (b *Breaker) trip() {
+	b.openUntil = b.now().Add(b.openTimeout)
+	b.setState(Open)
+}
+
+func (b *Breaker) setState(state State) {
+	if state == b.state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,150 @@
+package upstream
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestBreaker returns a breaker opening after 3 failures for 10s and
+// closing after 2 trials, and the transitions it makes.
+func newTestBreaker() (*Breaker, *fakeClock, *[]string) {
+	clock := &fakeClock{t: time.Unix(1700000000, 0)}
+	var changes []string
+	b := NewBreaker(3, 10*time.Second, 2, func(from, to State) {
+		changes = append(changes, fmt.Sprintf("%v->%v", from, to))
+	})
+	b.now = clock.now
+	return b, clock, &changes
+}
+
+// send runs one request with the given outcome through b, and reports
+// whether it was let through.
+func send(b *Breaker, outcome Outcome) bool {
+	ok, _ := b.Allow()
+	if ok {
+		b.Done(outcome)
+	}
+	return ok
+}
+
+func TestBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	b, _, changes := newTestBreaker()
+
+	send(b, Failure)
+	send(b, Failure)
+	send(b, Success) // resets the count
+	send(b, Failure)
+	send(b, Failure)
+	if got := b.Status().State; got != "closed" {
+		t.Fatalf("after non-consecutive failures: state %s, want closed", got)
+	}
+
+	send(b, Failure)
+	if got := b.Status().State; got != "open" {
+		t.Fatalf("after 3 consecutive failures: state %s, want open", got)
+	}
+	ok, wait := b.Allow()
+	if ok || wait != 10*time.Second {
+		t.Errorf("open breaker: Allow() = %v, %v, want false, 10s", ok, wait)
+	}
+	if !slices.Equal(*changes, []string{"closed->open"}) {
+		t.Errorf("transitions %q", *changes)
+	}
+}
+
+func TestBreakerHalfOpenCloses(t *testing.T) {
+	b, clock, changes := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		send(b, Failure)
+	}
+
+	clock.advance(9 * time.Second)
+	if ok, wait := b.Allow(); ok || wait != time.Second {
+		t.Fatalf("before the open timeout: Allow() = %v, %v, want false, 1s", ok, wait)
+	}
+
+	clock.advance(time.Second)
+	first, _ := b.Allow()
+	second, _ := b.Allow()
+	third, _ := b.Allow()
+	if !first || !second || third {
+		t.Fatalf("half-open: trials let through %v %v %v, want true true false", first, second, third)
+	}
+	b.Done(Success)
+	if got := b.Status().State; got != "half-open" {
+		t.Fatalf("after one of two trials: state %s, want half-open", got)
+	}
+	b.Done(Success)
+	if got := b.Status().State; got != "closed" {
+		t.Fatalf("after both trials: state %s, want closed", got)
+	}
+	if got := b.Status().ConsecutiveFailures; got != 0 {
+		t.Errorf("consecutive failures %d, want 0", got)
+	}
+	if want := []string{"closed->open", "open->half-open", "half-open->closed"}; !slices.Equal(*changes, want) {
+		t.Errorf("transitions %q, want %q", *changes, want)
+	}
+}
+
+func TestBreakerHalfOpenReopens(t *testing.T) {
+	b, clock, changes := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		send(b, Failure)
+	}
+	clock.advance(10 * time.Second)
+
+	send(b, Success)
+	send(b, Failure)
+	status := b.Status()
+	if status.State != "open" {
+		t.Fatalf("after a failed trial: state %s, want open", status.State)
+	}
+	if want := clock.now().Add(10 * time.Second); status.OpenUntil == nil || !status.OpenUntil.Equal(want) {
+		t.Errorf("open until %v, want %v", status.OpenUntil, want)
+	}
+	if want := []string{"closed->open", "open->half-open", "half-open->open"}; !slices.Equal(*changes, want) {
+		t.Errorf("transitions %q, want %q", *changes, want)
+	}
+}
+
+func TestBreakerAbandonedTrialFreesItsSlot(t *testing.T) {
+	b, clock, _ := newTestBreaker()
+	for i := 0; i < 3; i++ {
+		send(b, Failure)
+	}
+	clock.advance(10 * time.Second)
+
+	b.Allow()
+	b.Allow()
+	b.Done(Abandoned)
+	if ok, _ := b.Allow(); !ok {
+		t.Fatal("trial slot not freed by an abandoned request")
+	}
+	if got := b.Status().State; got != "half-open" {
+		t.Errorf("state %s, want half-open", got)
+	}
+}
+
+func TestBreakerIgnoresOutcomesWhileOpen(t *testing.T) {
+	b, _, _ := newTestBreaker()
+	// A request sent before the breaker opened finishes after it.
+	ok, _ := b.Allow()
+	if !ok {
+		t.Fatal("closed breaker refused a request")
+	}
+	for i := 0; i < 3; i++ {
+		send(b, Failure)
+	}
+	b.Done(Success)
+	if got := b.Status().State; got != "open" {
+		t.Errorf("state %s, want open", got)
+	}
+}
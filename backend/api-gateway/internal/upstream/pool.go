@@ -0,0 +1,368 @@
+package upstream
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/health"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.uber.org/zap"
+)
+
+// ErrNoHealthyEndpoints is returned when every instance of an upstream is
+// down, or none has been discovered.
+var ErrNoHealthyEndpoints = errors.New("no healthy endpoints")
+
+// Endpoint is one instance of an upstream service.
+type Endpoint struct {
+	// Host is the instance's host:port.
+	Host string
+
+	// target is the host:port requests to the instance are addressed to:
+	// Host for static instances, the service's for discovered ones.
+	target string
+	// conns holds the connections to the instance, and transport sends
+	// requests over them, tracing each one.
+	conns     *http.Transport
+	transport http.RoundTripper
+
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+	// failures counts consecutive failed probes. Only the prober uses it.
+	failures int
+}
+
+// Pool tracks the instances of an upstream service, probing each one, and
+// picks an instance for every request.
+type Pool struct {
+	name   string
+	scheme string
+	// host is the service URL's host:port, resolved by "dns" discovery.
+	host   string
+	policy config.UpstreamPolicy
+	// conns is cloned for each instance, so connections are pooled per
+	// instance.
+	conns  *http.Transport
+	logger *zap.Logger
+
+	mu        sync.RWMutex
+	endpoints []*Endpoint
+	next      atomic.Uint64
+}
+
+// NewPool creates the pool for the service at rawURL. Until Run discovers
+// otherwise, the pool holds the static endpoints, or the service URL.
+func NewPool(name, rawURL string, policy config.UpstreamPolicy, logger *zap.Logger) (*Pool, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: policy.ConnectTimeout, KeepAlive: 30 * time.Second}
+	conns := http.DefaultTransport.(*http.Transport).Clone()
+	conns.DialContext = dialer.DialContext
+	conns.ResponseHeaderTimeout = policy.ResponseTimeout
+
+	p := &Pool{
+		name:   name,
+		scheme: target.Scheme,
+		host:   target.Host,
+		policy: policy,
+		conns:  conns,
+		logger: logger,
+	}
+
+	hosts := []string{target.Host}
+	if policy.Discovery == "static" && len(policy.Endpoints) > 0 {
+		hosts = hosts[:0]
+		for _, raw := range policy.Endpoints {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, u.Host)
+		}
+	}
+	p.setEndpoints(hosts)
+	return p, nil
+}
+
+// Run discovers and probes the instances until ctx is done.
+func (p *Pool) Run(ctx context.Context) {
+	if p.policy.Discovery != "static" {
+		go p.runResolver(ctx)
+	}
+
+	ticker := time.NewTicker(p.policy.HealthInterval)
+	defer ticker.Stop()
+	for {
+		p.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pick returns the instance to send the next request to, counting it as in
+// flight until done is called.
+func (p *Pool) Pick() (endpoint *Endpoint, done func(), err error) {
+	p.mu.RLock()
+	endpoints := p.endpoints
+	p.mu.RUnlock()
+
+	n := len(endpoints)
+	start := int(p.next.Add(1) % uint64(max(n, 1)))
+	for i := 0; i < n; i++ {
+		e := endpoints[(start+i)%n]
+		if !e.healthy.Load() {
+			continue
+		}
+		if endpoint == nil {
+			endpoint = e
+			if p.policy.Balancer == "round-robin" {
+				break
+			}
+		} else if e.inFlight.Load() < endpoint.inFlight.Load() {
+			endpoint = e
+		}
+	}
+	if endpoint == nil {
+		return nil, nil, fmt.Errorf("upstream %s: %w", p.name, ErrNoHealthyEndpoints)
+	}
+
+	endpoint.inFlight.Add(1)
+	var once sync.Once
+	return endpoint, func() { once.Do(func() { endpoint.inFlight.Add(-1) }) }, nil
+}
+
+// Healthy reports an error if no instance can take requests.
+func (p *Pool) Healthy(context.Context) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, e := range p.endpoints {
+		if e.healthy.Load() {
+			return nil
+		}
+	}
+	return fmt.Errorf("upstream %s: %w", p.name, ErrNoHealthyEndpoints)
+}
+
+// EndpointStatus describes one instance.
+type EndpointStatus struct {
+	Host     string `json:"host"`
+	Healthy  bool   `json:"healthy"`
+	InFlight int64  `json:"in_flight"`
+}
+
+func (p *Pool) Status() []EndpointStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	statuses := make([]EndpointStatus, len(p.endpoints))
+	for i, e := range p.endpoints {
+		statuses[i] = EndpointStatus{Host: e.Host, Healthy: e.healthy.Load(), InFlight: e.inFlight.Load()}
+	}
+	return statuses
+}
+
+// setEndpoints replaces the instances with hosts. Instances that remain
+// keep their health and in-flight counts; new ones start healthy and are
+// probed on the next round. Connections to removed instances are closed
+// once idle.
+func (p *Pool) setEndpoints(hosts []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current := make(map[string]*Endpoint, len(p.endpoints))
+	for _, e := range p.endpoints {
+		current[e.Host] = e
+	}
+	endpoints := make([]*Endpoint, 0, len(hosts))
+	for _, host := range hosts {
+		e, ok := current[host]
+		if !ok {
+			e = p.newEndpoint(host)
+		}
+		delete(current, host)
+		endpoints = append(endpoints, e)
+	}
+	for _, e := range current {
+		e.conns.CloseIdleConnections()
+	}
+	p.endpoints = endpoints
+	p.updateMetrics()
+}
+
+// newEndpoint returns a healthy instance at host. Discovered instances are
+// dialed at their address, but requests to them keep the service's host
+// name, so TLS sends it and verifies the instance's certificate against
+// it.
+func (p *Pool) newEndpoint(host string) *Endpoint {
+	e := &Endpoint{Host: host, target: host, conns: p.conns.Clone()}
+	if p.policy.Discovery != "static" {
+		e.target = p.host
+		dial := e.conns.DialContext
+		e.conns.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dial(ctx, network, host)
+		}
+		if e.conns.TLSClientConfig == nil {
+			e.conns.TLSClientConfig = &tls.Config{}
+		}
+		e.conns.TLSClientConfig.ServerName = hostname(p.host)
+	}
+	e.transport = otelhttp.NewTransport(e.conns)
+	e.healthy.Store(true)
+	return e
+}
+
+// hostname strips the port, if any, from hostport.
+func hostname(hostport string) string {
+	if host, _, err := net.SplitHostPort(hostport); err == nil {
+		return host
+	}
+	return hostport
+}
+
+func (p *Pool) runResolver(ctx context.Context) {
+	ticker := time.NewTicker(p.policy.ResolveInterval)
+	defer ticker.Stop()
+	for {
+		hosts, err := p.resolve(ctx)
+		switch {
+		case err != nil:
+			p.logger.Warn("Upstream discovery failed, keeping previous endpoints",
+				zap.String("upstream", p.name), zap.Error(err))
+		case len(hosts) == 0:
+			p.logger.Warn("Upstream discovery found no endpoints, keeping previous ones",
+				zap.String("upstream", p.name))
+		default:
+			p.setEndpoints(hosts)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resolve looks up the instances' host:port pairs.
+func (p *Pool) resolve(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.policy.HealthTimeout)
+	defer cancel()
+
+	if p.policy.Discovery == "srv" {
+		_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", p.policy.SRVName)
+		if err != nil {
+			return nil, err
+		}
+		hosts := make([]string, len(records))
+		for i, r := range records {
+			hosts[i] = net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+		}
+		return hosts, nil
+	}
+
+	host, port, err := net.SplitHostPort(p.host)
+	if err != nil {
+		host, port = p.host, "80"
+		if p.scheme == "https" {
+			port = "443"
+		}
+	}
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	hosts := make([]string, len(addrs))
+	for i, addr := range addrs {
+		hosts[i] = net.JoinHostPort(addr, port)
+	}
+	return hosts, nil
+}
+
+func (p *Pool) probeAll(ctx context.Context) {
+	p.mu.RLock()
+	endpoints := p.endpoints
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *Endpoint) {
+			defer wg.Done()
+			p.probeOne(ctx, e)
+		}(e)
+	}
+	wg.Wait()
+
+	p.mu.RLock()
+	p.updateMetrics()
+	p.mu.RUnlock()
+}
+
+func (p *Pool) probeOne(ctx context.Context, e *Endpoint) {
+	ctx, cancel := context.WithTimeout(ctx, p.policy.HealthTimeout)
+	defer cancel()
+
+	probe := &http.Client{Transport: e.conns}
+	err := health.HTTPProbe(probe, p.scheme+"://"+e.target+p.policy.HealthPath)(ctx)
+	if ctx.Err() != nil && errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		e.failures = 0
+		if !e.healthy.Swap(true) {
+			p.logger.Info("Upstream endpoint is up", zap.String("upstream", p.name), zap.String("host", e.Host))
+		}
+		return
+	}
+
+	e.failures++
+	if e.failures >= p.policy.HealthFailures && e.healthy.Swap(false) {
+		p.logger.Warn("Upstream endpoint is down",
+			zap.String("upstream", p.name),
+			zap.String("host", e.Host),
+			zap.Int("failed_probes", e.failures),
+			zap.Error(err),
+		)
+	}
+}
+
+// updateMetrics must be called with p.mu held.
+func (p *Pool) updateMetrics() {
+	var healthy int
+	for _, e := range p.endpoints {
+		if e.healthy.Load() {
+			healthy++
+		}
+	}
+	metrics.UpstreamEndpoints.WithLabelValues(p.name, "healthy").Set(float64(healthy))
+	metrics.UpstreamEndpoints.WithLabelValues(p.name, "unhealthy").Set(float64(len(p.endpoints) - healthy))
+}
+
+// countedBody reports when the response body has been read and closed.
+type countedBody struct {
+	io.ReadCloser
+	done func()
+}
+
+func (b countedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done()
+	return err
+}
@@ -0,0 +1,53 @@
+package upstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// Discovered instances are dialed by address, but TLS must still see the
+// service's host name; httptest's certificate is valid for example.com.
+func TestPoolDiscoveredEndpointKeepsServiceHostName(t *testing.T) {
+	var serverName, host string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverName, host = r.TLS.ServerName, r.Host
+	}))
+	defer srv.Close()
+	port := srv.URL[strings.LastIndex(srv.URL, ":")+1:]
+
+	policy := config.UpstreamPolicy{Discovery: "dns", HealthPath: "/health", HealthTimeout: time.Second, HealthFailures: 1}
+	pool, err := NewPool("test", "https://example.com:"+port, policy, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.conns.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+	pool.setEndpoints([]string{srv.Listener.Addr().String()})
+
+	e := pool.endpoints[0]
+	pool.probeOne(context.Background(), e)
+	if !e.healthy.Load() {
+		t.Fatal("probe failed")
+	}
+	if serverName != "example.com" || host != "example.com:"+port {
+		t.Errorf("probe: server name %q, host %q, want example.com", serverName, host)
+	}
+
+	tr := &Transport{name: "test", policy: policy, pool: pool, breaker: NewBreaker(1, time.Second, 1, nil), budget: newRetryBudget(0), logger: zap.NewNop()}
+	req := httptest.NewRequest(http.MethodGet, "https://example.com:"+port+"/quotes", nil)
+	req.RequestURI = ""
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if serverName != "example.com" {
+		t.Errorf("request: server name %q, want example.com", serverName)
+	}
+}
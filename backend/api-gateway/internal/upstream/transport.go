@@ -0,0 +1,221 @@
+// Package upstream spreads the gateway's calls to upstream services over
+// their healthy instances, and bounds them with timeouts, a circuit breaker
+// and budgeted retries.
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"github.com/financial-analytics/api-gateway/internal/metrics"
+	"go.uber.org/zap"
+)
+
+// CircuitOpenError is returned for requests failed fast by an open
+// circuit breaker.
+type CircuitOpenError struct {
+	Upstream string
+	// RetryAfter is how long until the breaker lets a request through.
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("upstream %s: circuit open", e.Upstream)
+}
+
+// Transport is an http.RoundTripper that calls the instances of one
+// upstream service, at rawURL, under its policy. Each attempt goes to the
+// instance the pool picks and is traced as a separate client span.
+type Transport struct {
+	name    string
+	policy  config.UpstreamPolicy
+	pool    *Pool
+	breaker *Breaker
+	budget  *retryBudget
+	logger  *zap.Logger
+}
+
+func NewTransport(name, rawURL string, policy config.UpstreamPolicy, logger *zap.Logger) (*Transport, error) {
+	pool, err := NewPool(name, rawURL, policy, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Transport{
+		name:   name,
+		policy: policy,
+		pool:   pool,
+		budget: newRetryBudget(policy.RetryBudget),
+		logger: logger,
+	}
+	t.breaker = NewBreaker(policy.FailureThreshold, policy.OpenTimeout, policy.HalfOpenRequests, t.stateChanged)
+	metrics.UpstreamCircuitState.WithLabelValues(name).Set(float64(Closed))
+	return t, nil
+}
+
+// Run discovers and probes the upstream's instances until ctx is done.
+func (t *Transport) Run(ctx context.Context) {
+	t.pool.Run(ctx)
+}
+
+// Healthy reports an error if no instance of the upstream can take
+// requests.
+func (t *Transport) Healthy(ctx context.Context) error {
+	return t.pool.Healthy(ctx)
+}
+
+// Name is the upstream's name in configuration, logs and metrics.
+func (t *Transport) Name() string {
+	return t.name
+}
+
+// Status describes the upstream's circuit breaker and instances.
+type Status struct {
+	Name string `json:"name"`
+	BreakerStatus
+	Endpoints []EndpointStatus `json:"endpoints"`
+}
+
+func (t *Transport) Status() Status {
+	return Status{Name: t.name, BreakerStatus: t.breaker.Status(), Endpoints: t.pool.Status()}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	attempts := 1
+	if retryable(req) {
+		attempts = t.policy.MaxAttempts
+	}
+	t.budget.deposit()
+
+	for attempt := 1; ; attempt++ {
+		endpoint, release, err := t.pool.Pick()
+		if err != nil {
+			return nil, err
+		}
+		if ok, wait := t.breaker.Allow(); !ok {
+			release()
+			metrics.UpstreamRejections.WithLabelValues(t.name).Inc()
+			return nil, &CircuitOpenError{Upstream: t.name, RetryAfter: wait}
+		}
+
+		out := req.WithContext(ctx)
+		target := *req.URL
+		target.Host = endpoint.target
+		out.URL = &target
+
+		resp, err := endpoint.transport.RoundTrip(out)
+		if err != nil {
+			release()
+		} else {
+			resp.Body = countedBody{ReadCloser: resp.Body, done: release}
+		}
+		failed := err != nil || failedStatus(resp.StatusCode)
+		switch {
+		case ctx.Err() != nil:
+			t.breaker.Done(Abandoned)
+			return resp, err
+		case failed:
+			t.breaker.Done(Failure)
+		default:
+			t.breaker.Done(Success)
+		}
+
+		if !failed || attempt >= attempts || !t.budget.withdraw() {
+			return resp, err
+		}
+		if resp != nil {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+			resp.Body.Close()
+		}
+		metrics.UpstreamRetries.WithLabelValues(t.name).Inc()
+
+		// Full jitter: wait a random time up to the doubled backoff.
+		backoff := t.policy.RetryBackoff << (attempt - 1)
+		timer := time.NewTimer(time.Duration(rand.Int63n(int64(backoff)) + 1))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *Transport) stateChanged(from, to State) {
+	metrics.UpstreamCircuitState.WithLabelValues(t.name).Set(float64(to))
+	fields := []zap.Field{
+		zap.String("upstream", t.name),
+		zap.String("from", from.String()),
+		zap.String("to", to.String()),
+	}
+	if to == Open {
+		t.logger.Warn("Upstream circuit opened", append(fields, zap.Duration("open_timeout", t.policy.OpenTimeout))...)
+	} else {
+		t.logger.Info("Upstream circuit changed state", fields...)
+	}
+}
+
+// retryable reports whether req may safely be sent again: its method is
+// idempotent and it has no body to replay.
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return req.Body == nil || req.Body == http.NoBody
+	default:
+		return false
+	}
+}
+
+// failedStatus reports whether a response status means the upstream, or
+// the path to it, is failing rather than rejecting the request.
+func failedStatus(status int) bool {
+	return status == http.StatusBadGateway ||
+		status == http.StatusServiceUnavailable ||
+		status == http.StatusGatewayTimeout
+}
+
+// IsTimeout reports whether err is an upstream timing out.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// maxRetryTokens caps the retries saved up while an upstream is healthy.
+const maxRetryTokens = 10
+
+// retryBudget earns a fraction of a retry with every request and spends a
+// whole one per retry.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: maxRetryTokens}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+b.ratio, maxRetryTokens)
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,131 @@
+package upstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/financial-analytics/api-gateway/internal/config"
+	"go.uber.org/zap"
+)
+
+// roundTripFunc answers requests without a network.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestTransport returns a transport whose upstream answers every
+// attempt with status, and a count of the attempts made.
+func newTestTransport(t *testing.T, status int, policy config.UpstreamPolicy) (*Transport, *int) {
+	t.Helper()
+	tr, err := NewTransport("test", "http://test.internal", policy, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts := new(int)
+	tr.pool.endpoints[0].transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		*attempts++
+		if req.Body != nil {
+			_, _ = io.Copy(io.Discard, req.Body)
+		}
+		return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+	})
+	return tr, attempts
+}
+
+func testPolicy() config.UpstreamPolicy {
+	return config.UpstreamPolicy{
+		FailureThreshold: 1000,
+		OpenTimeout:      time.Second,
+		HalfOpenRequests: 1,
+		MaxAttempts:      3,
+		RetryBackoff:     time.Nanosecond,
+		RetryBudget:      0.2,
+		Discovery:        "static",
+		Balancer:         "round-robin",
+	}
+}
+
+func roundTrip(t *testing.T, tr *Transport, req *http.Request) {
+	t.Helper()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func TestTransportRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		body     string
+		status   int
+		attempts int
+	}{
+		{name: "GET failing is retried", method: http.MethodGet, status: http.StatusServiceUnavailable, attempts: 3},
+		{name: "DELETE failing is retried", method: http.MethodDelete, status: http.StatusBadGateway, attempts: 3},
+		{name: "GET succeeding is not retried", method: http.MethodGet, status: http.StatusOK, attempts: 1},
+		{name: "client errors are not retried", method: http.MethodGet, status: http.StatusNotFound, attempts: 1},
+		{name: "POST is never retried", method: http.MethodPost, status: http.StatusServiceUnavailable, attempts: 1},
+		{name: "PATCH is never retried", method: http.MethodPatch, status: http.StatusServiceUnavailable, attempts: 1},
+		{name: "PUT with a body is never retried", method: http.MethodPut, body: `{"a":1}`, status: http.StatusServiceUnavailable, attempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, attempts := newTestTransport(t, tt.status, testPolicy())
+			var body io.Reader
+			if tt.body != "" {
+				body = strings.NewReader(tt.body)
+			}
+			roundTrip(t, tr, httptest.NewRequest(tt.method, "http://test.internal/x", body))
+			if *attempts != tt.attempts {
+				t.Errorf("attempts %d, want %d", *attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestTransportRetryBudgetExhausted(t *testing.T) {
+	policy := testPolicy()
+	policy.RetryBudget = 0
+	tr, attempts := newTestTransport(t, http.StatusServiceUnavailable, policy)
+
+	// With nothing earned, only the retries saved up in the full budget
+	// are made, however many requests fail.
+	for i := 0; i < 20; i++ {
+		roundTrip(t, tr, httptest.NewRequest(http.MethodGet, "http://test.internal/x", nil))
+	}
+	if retries := *attempts - 20; retries != maxRetryTokens {
+		t.Errorf("retries %d, want %d", retries, maxRetryTokens)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	b := newRetryBudget(0.5)
+	for i := 0; i < 100; i++ {
+		b.deposit()
+	}
+	var withdrawn int
+	for b.withdraw() {
+		withdrawn++
+	}
+	if withdrawn != maxRetryTokens {
+		t.Fatalf("withdrew %d saved retries, want %d", withdrawn, maxRetryTokens)
+	}
+
+	b.deposit()
+	if b.withdraw() {
+		t.Error("withdrew a retry with half a token")
+	}
+	b.deposit()
+	if !b.withdraw() {
+		t.Error("could not withdraw a retry with a whole token")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/lib/pq"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so leaked keys are easy to spot
+	// in code and logs.
+	apiKeyPrefix = "fa_"
+
+	// maxAPIKeysPerUser bounds the active keys a user may hold.
+	maxAPIKeysPerUser = 20
+
+	defaultAPIKeyLifetime = 90 * 24 * time.Hour
+	maxAPIKeyLifetime     = 365 * 24 * time.Hour
+
+	// apiKeyUseInterval is how stale last_used_at may get before a use of
+	// the key is written to the database.
+	apiKeyUseInterval = time.Minute
+)
+
+// apiKeyScopes are the scopes a key may be granted. A key with "read-only"
+// may read dashboards, profiles, watchlists and alerts; "analytics" allows
+// indicators, calculations and historical data; "dashboards:write" allows
+// creating and editing dashboards.
+var apiKeyScopes = map[string]bool{
+	"read-only":        true,
+	"analytics":        true,
+	"dashboards:write": true,
+}
+
+var errInvalidAPIKey = errors.New("invalid API key")
+
+// APIKey is a user's API key as shown to its owner. Key, the secret, is
+// only returned when the key is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// apiKeyIdentity is the caller an API key authenticates.
+type apiKeyIdentity struct {
+	KeyID     string
+	UserID    string
+	Email     string
+	Plan      string
+	Scopes    []string
+	ExpiresAt *time.Time
+}
+
+// newAPIKey returns a random key and its public prefix. The key is
+// "fa_<prefix>_<secret>".
+func newAPIKey() (key, prefix string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secret), prefix, nil
+}
+
+// hashAPIKey returns the hex SHA-256 of key. Keys carry 256 bits of
+// randomness, so a fast hash is enough to make the stored hashes useless.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AuthService) handleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Name must be 1 to 100 characters", http.StatusBadRequest)
+		return
+	}
+	if len(req.Scopes) == 0 {
+		http.Error(w, "At least one scope is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range req.Scopes {
+		if !apiKeyScopes[scope] {
+			http.Error(w, "Unknown scope: "+scope, http.StatusBadRequest)
+			return
+		}
+	}
+	lifetime := defaultAPIKeyLifetime
+	if req.ExpiresInDays != 0 {
+		lifetime = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+	if lifetime <= 0 || lifetime > maxAPIKeyLifetime {
+		http.Error(w, "expires_in_days must be between 1 and 365", http.StatusBadRequest)
+		return
+	}
+
+	var active int
+	if err := s.db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM api_keys
+        WHERE user_id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+    `, userID).Scan(&active); err != nil {
+		log.Printf("Failed to count API keys for user %s: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if active >= maxAPIKeysPerUser {
+		http.Error(w, "Too many API keys", http.StatusConflict)
+		return
+	}
+
+	key, prefix, err := newAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := APIKey{Name: req.Name, Prefix: prefix, Scopes: req.Scopes, Key: key}
+	err = s.db.QueryRowContext(ctx, `
+        INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+        VALUES ($1, $2, $3, $4, $5, NOW() + make_interval(secs => $6))
+        RETURNING id, expires_at, created_at
+    `, userID, req.Name, prefix, hashAPIKey(key), pq.Array(req.Scopes), lifetime.Seconds(),
+	).Scan(&apiKey.ID, &apiKey.ExpiresAt, &apiKey.CreatedAt)
+	if err != nil {
+		log.Printf("Failed to create API key for user %s: %v", userID, err)
+		http.Error(w, "Failed to create API key", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(apiKey); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+func (s *AuthService) handleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT id, name, prefix, scopes, expires_at, last_used_at, created_at
+        FROM api_keys
+        WHERE user_id = $1 AND revoked_at IS NULL
+        ORDER BY created_at DESC
+    `, userID)
+	if err != nil {
+		log.Printf("Failed to list API keys for user %s: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, pq.Array(&k.Scopes), &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			log.Printf("Failed to scan API key: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to list API keys for user %s: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		log.Printf("Failed to encode response: %v", err)
+	}
+}
+
+// handleRevokeAPIKey revokes one of the caller's keys. Gateways may keep
+// accepting it for as long as they cache verifications.
+func (s *AuthService) handleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	userID := r.Header.Get("X-User-ID")
+	if userID == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Malformed IDs can't name a key, and would make Postgres fail the cast.
+	keyID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	result, err := s.db.ExecContext(ctx, `
+        UPDATE api_keys SET revoked_at = NOW()
+        WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+    `, keyID.String(), userID)
+	if err != nil {
+		log.Printf("Failed to revoke API key for user %s: %v", userID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// verifyAPIKey looks up the identity behind an active API key and records
+// its use.
+func (s *AuthService) verifyAPIKey(ctx context.Context, key string) (*apiKeyIdentity, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, errInvalidAPIKey
+	}
+
+	var id apiKeyIdentity
+	var lastUsed sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+        SELECT k.id, k.user_id, u.email, u.plan, k.scopes, k.expires_at, k.last_used_at
+        FROM api_keys k JOIN users u ON u.id = k.user_id
+        WHERE k.key_hash = $1 AND k.revoked_at IS NULL
+            AND (k.expires_at IS NULL OR k.expires_at > NOW())
+    `, hashAPIKey(key)).Scan(&id.KeyID, &id.UserID, &id.Email, &id.Plan, pq.Array(&id.Scopes), &id.ExpiresAt, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, errInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if !lastUsed.Valid || time.Since(lastUsed.Time) > apiKeyUseInterval {
+		if _, err := s.db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE id = $1", id.KeyID); err != nil {
+			log.Printf("Failed to record use of API key %s: %v", id.KeyID, err)
+		}
+	}
+	return &id, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+)
+
+func TestNewAPIKey(t *testing.T) {
+	format := regexp.MustCompile(`^fa_[0-9a-f]{12}_[A-Za-z0-9_-]{43}$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key, prefix, err := newAPIKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(key) {
+			t.Fatalf("key %q is not fa_<prefix>_<secret>", key)
+		}
+		if key[:len(prefix)+1] != prefix+"_" {
+			t.Fatalf("key %q does not start with its prefix %q", key, prefix)
+		}
+		if seen[key] {
+			t.Fatalf("key %q generated twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestHashAPIKey(t *testing.T) {
+	key, _, err := newAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := hashAPIKey(key)
+	if len(hash) != 64 || hash != hashAPIKey(key) {
+		t.Fatalf("hash %q is not a stable hex SHA-256", hash)
+	}
+	if hash == hashAPIKey(key+"x") {
+		t.Error("different keys hash alike")
+	}
+	if got, want := hashAPIKey("fa_"), "9a62c9993ef472fae57ad9dd4599756c3dd8b3a89b0d1afaacae86f302f3689e"; got != want {
+		t.Errorf("hashAPIKey(%q) = %q, want its SHA-256 %q", "fa_", got, want)
+	}
+}
+
+// Keys without the prefix are rejected before the database is consulted.
+func TestVerifyAPIKeyRejectsForeignKeys(t *testing.T) {
+	s := &AuthService{}
+	for _, key := range []string{"", "sk_live_abc", "Bearer fa_x"} {
+		if _, err := s.verifyAPIKey(context.Background(), key); !errors.Is(err, errInvalidAPIKey) {
+			t.Errorf("verifyAPIKey(%q): %v, want errInvalidAPIKey", key, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"time"
+
+	authv1 "github.com/financial-analytics/auth-service/proto/auth/v1"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// sessionLifetime matches the refresh token lifetime; a revoked session only
+// needs to be remembered until its last token has expired.
+const sessionLifetime = time.Hour * 24 * 30
+
+var (
+	errInvalidToken      = errors.New("invalid token")
+	errRefreshTokenUsage = errors.New("refresh token used as access token")
+)
+
+// refreshTokenReason is the ErrorInfo reason attached when a refresh token
+// is presented as an access token, so callers can tell it apart from an
+// invalid token.
+const refreshTokenReason = "REFRESH_TOKEN_USAGE"
+
+// grpcAuthServer implements the internal authv1.AuthService API.
+type grpcAuthServer struct {
+	authv1.UnimplementedAuthServiceServer
+	service *AuthService
+}
+
+func (g *grpcAuthServer) VerifyToken(ctx context.Context, req *authv1.VerifyTokenRequest) (*authv1.VerifyTokenResponse, error) {
+	claims, err := g.service.parseAccessToken(req.GetToken())
+	if errors.Is(err, errRefreshTokenUsage) {
+		st, detailErr := status.New(codes.Unauthenticated, err.Error()).WithDetails(&errdetails.ErrorInfo{
+			Reason: refreshTokenReason,
+			Domain: "auth.financial-analytics",
+		})
+		if detailErr != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return nil, st.Err()
+	}
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	userID, _ := claims["user_id"].(string)
+	email, _ := claims["email"].(string)
+	sessionID, _ := claims["sid"].(string)
+	plan, _ := claims["plan"].(string)
+	role, scopes := tokenAuthorization(claims)
+
+	revoked, err := g.service.isSessionRevoked(ctx, sessionID)
+	if err != nil {
+		log.Printf("Failed to check session %s: %v", sessionID, err)
+		return nil, status.Error(codes.Internal, "failed to check session")
+	}
+	if revoked {
+		return nil, status.Error(codes.Unauthenticated, "session revoked")
+	}
+
+	resp := &authv1.VerifyTokenResponse{
+		UserId:    userID,
+		Email:     email,
+		SessionId: sessionID,
+		Plan:      plan,
+		Role:      role,
+		Scopes:    scopes,
+	}
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		resp.ExpiresAt = timestamppb.New(exp.Time)
+	}
+	return resp, nil
+}
+
+func (g *grpcAuthServer) GetUser(ctx context.Context, req *authv1.GetUserRequest) (*authv1.GetUserResponse, error) {
+	var user User
+	err := g.service.db.QueryRowContext(ctx, `
+        SELECT id, email, provider, created_at
+        FROM users WHERE id = $1
+    `, req.GetUserId()).Scan(&user.ID, &user.Email, &user.Provider, &user.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	if err != nil {
+		log.Printf("Failed to load user %s: %v", req.GetUserId(), err)
+		return nil, status.Error(codes.Internal, "database error")
+	}
+
+	return &authv1.GetUserResponse{
+		User: &authv1.User{
+			Id:        user.ID,
+			Email:     user.Email,
+			Provider:  user.Provider,
+			CreatedAt: timestamppb.New(user.CreatedAt),
+		},
+	}, nil
+}
+
+func (g *grpcAuthServer) RevokeSession(ctx context.Context, req *authv1.RevokeSessionRequest) (*authv1.RevokeSessionResponse, error) {
+	if req.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+
+	if err := g.service.revokeSession(ctx, req.GetSessionId(), req.GetUserId()); err != nil {
+		log.Printf("Failed to revoke session %s: %v", req.GetSessionId(), err)
+		return nil, status.Error(codes.Internal, "failed to revoke session")
+	}
+	return &authv1.RevokeSessionResponse{}, nil
+}
+
+func (g *grpcAuthServer) VerifyAPIKey(ctx context.Context, req *authv1.VerifyAPIKeyRequest) (*authv1.VerifyAPIKeyResponse, error) {
+	id, err := g.service.verifyAPIKey(ctx, req.GetKey())
+	if errors.Is(err, errInvalidAPIKey) {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		log.Printf("Failed to verify API key: %v", err)
+		return nil, status.Error(codes.Internal, "failed to verify API key")
+	}
+
+	resp := &authv1.VerifyAPIKeyResponse{
+		KeyId:  id.KeyID,
+		UserId: id.UserID,
+		Email:  id.Email,
+		Plan:   id.Plan,
+		Scopes: id.Scopes,
+	}
+	if id.ExpiresAt != nil {
+		resp.ExpiresAt = timestamppb.New(*id.ExpiresAt)
+	}
+	return resp, nil
+}
+
+// parseAccessToken verifies an access token issued by this service and
+// returns its claims. Refresh tokens are rejected.
+func (s *AuthService) parseAccessToken(tokenString string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return s.jwtSecret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(s.jwtIssuer),
+		jwt.WithAudience(s.jwtAudience),
+	)
+	if err != nil || !token.Valid {
+		return nil, errInvalidToken
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["type"] == "refresh" {
+		return nil, errRefreshTokenUsage
+	}
+	if _, ok := claims["exp"]; !ok {
+		return nil, errInvalidToken
+	}
+	return claims, nil
+}
+
+// tokenAuthorization returns an access token's role and scopes. Tokens
+// issued before roles existed carry neither and are treated as a user's.
+func tokenAuthorization(claims jwt.MapClaims) (string, []string) {
+	role, _ := claims["role"].(string)
+	raw, ok := claims["scopes"].([]interface{})
+	if role == "" || !ok {
+		return roleUser, sessionScopes
+	}
+
+	scopes := make([]string, 0, len(raw))
+	for _, v := range raw {
+		if scope, ok := v.(string); ok {
+			scopes = append(scopes, scope)
+		}
+	}
+	return role, scopes
+}
+
+func (s *AuthService) isSessionRevoked(ctx context.Context, sessionID string) (bool, error) {
+	if sessionID == "" {
+		return false, nil
+	}
+
+	var revoked bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM revoked_sessions WHERE session_id = $1)", sessionID,
+	).Scan(&revoked)
+	return revoked, err
+}
+
+func (s *AuthService) revokeSession(ctx context.Context, sessionID, userID string) error {
+	_, err := s.db.ExecContext(ctx, `
+        INSERT INTO revoked_sessions (session_id, user_id, expires_at)
+        VALUES ($1, NULLIF($2, '')::uuid, $3)
+        ON CONFLICT (session_id) DO NOTHING
+    `, sessionID, userID, time.Now().Add(sessionLifetime))
+	return err
+}
+
+// newSessionID returns a random identifier shared by the access and refresh
+// tokens of one login.
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
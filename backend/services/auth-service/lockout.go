@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// loginLockout locks an email address for duration once maxFailures
+// password logins to it have failed within window. The API gateway
+// throttles attempts by client address; this stops a distributed attack on
+// a single account. Failures are counted by email whether or not an
+// account has it, so the lockout doesn't reveal which accounts exist.
+type loginLockout struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+}
+
+func loginLockoutFromEnv() loginLockout {
+	return loginLockout{
+		maxFailures: getEnvInt("LOGIN_MAX_FAILURES", 5),
+		window:      getEnvDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		duration:    getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute),
+	}
+}
+
+// dummyPasswordHash is compared against when no account has the email, so
+// a failed login takes as long whether or not the account exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
+// lockoutKey is the form of email failures are counted under.
+func lockoutKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLockedFor returns how long logins to email remain locked.
+func (s *AuthService) loginLockedFor(ctx context.Context, email string) (time.Duration, error) {
+	var seconds float64
+	err := s.db.QueryRowContext(ctx, `
+        SELECT COALESCE(GREATEST(EXTRACT(EPOCH FROM locked_until - NOW()), 0), 0)
+        FROM login_failures WHERE email = $1
+    `, lockoutKey(email)).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return time.Duration(seconds * float64(time.Second)), err
+}
+
+// recordLoginFailure counts a failed password login to email, locking it
+// when it reaches the limit.
+func (s *AuthService) recordLoginFailure(ctx context.Context, email string) error {
+	key := lockoutKey(email)
+	var failures int
+	err := s.db.QueryRowContext(ctx, `
+        INSERT INTO login_failures (email, failures, first_failed_at)
+        VALUES ($1, 1, NOW())
+        ON CONFLICT (email) DO UPDATE SET
+            failures = CASE WHEN login_failures.first_failed_at > NOW() - make_interval(secs => $2)
+                THEN login_failures.failures + 1 ELSE 1 END,
+            first_failed_at = CASE WHEN login_failures.first_failed_at > NOW() - make_interval(secs => $2)
+                THEN login_failures.first_failed_at ELSE NOW() END
+        RETURNING failures
+    `, key, s.lockout.window.Seconds()).Scan(&failures)
+	if err != nil || failures < s.lockout.maxFailures {
+		return err
+	}
+
+	if _, err := s.db.ExecContext(ctx, `
+        UPDATE login_failures SET
+            locked_until = NOW() + make_interval(secs => $2),
+            failures = 0,
+            first_failed_at = NULL
+        WHERE email = $1
+    `, key, s.lockout.duration.Seconds()); err != nil {
+		return err
+	}
+	accountLockouts.Inc()
+	log.Printf("Locked logins to an email for %v after %d failures", s.lockout.duration, failures)
+	return nil
+}
+
+// clearLoginFailures forgets the failed logins to email after a successful
+// one.
+func (s *AuthService) clearLoginFailures(ctx context.Context, email string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM login_failures WHERE email = $1", lockoutKey(email))
+	return err
+}
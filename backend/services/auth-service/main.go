@@ -5,16 +5,22 @@ import (
 	"database/sql"
 	"encoding/json"
 	"log"
+	"math"
 	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/auth"
+	authv1 "github.com/financial-analytics/auth-service/proto/auth/v1"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"golang.org/x/crypto/bcrypt"
 	"google.golang.org/grpc"
 )
@@ -23,6 +29,9 @@ type AuthService struct {
 	db           *sql.DB
 	firebaseAuth *auth.Client
 	jwtSecret    []byte
+	jwtIssuer    string
+	jwtAudience  string
+	lockout      loginLockout
 }
 
 type LoginRequest struct {
@@ -43,16 +52,29 @@ type User struct {
 	ID        string    `json:"id"`
 	Email     string    `json:"email"`
 	Provider  string    `json:"provider"`
+	Plan      string    `json:"plan"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
 func main() {
+	shutdownTracing, err := setupTracing(context.Background(), "auth-service")
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}()
+
 	// Initialize database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := openDB(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
+	registerDBMetrics(db)
 
 	// Initialize Firebase
 	ctx := context.Background()
@@ -70,16 +92,29 @@ func main() {
 		db:           db,
 		firebaseAuth: firebaseAuth,
 		jwtSecret:    []byte(os.Getenv("JWT_SECRET")),
+		jwtIssuer:    getEnv("JWT_ISSUER", "financial-analytics-auth"),
+		jwtAudience:  getEnv("JWT_AUDIENCE", "financial-analytics-api"),
+		lockout:      loginLockoutFromEnv(),
 	}
 
 	// Setup routes
 	router := mux.NewRouter()
+	router.Use(traceRoutes)
+	router.Use(instrumentRoutes)
 	router.HandleFunc("/login", service.handleLogin).Methods("POST")
 	router.HandleFunc("/register", service.handleRegister).Methods("POST")
 	router.HandleFunc("/refresh", service.handleRefreshToken).Methods("POST")
 	router.HandleFunc("/verify", service.handleVerifyToken).Methods("POST")
 	router.HandleFunc("/logout", service.handleLogout).Methods("POST")
+	router.HandleFunc("/api-keys", service.handleListAPIKeys).Methods("GET")
+	router.HandleFunc("/api-keys", service.handleCreateAPIKey).Methods("POST")
+	router.HandleFunc("/api-keys/{id}", service.handleRevokeAPIKey).Methods("DELETE")
 	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/livez", handleLive).Methods("GET")
+	router.HandleFunc("/readyz", readyHandler(
+		healthCheck{name: "postgres", critical: true, probe: db.PingContext},
+	)).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	// Start gRPC server for internal communication
 	go service.startGRPCServer()
@@ -89,35 +124,66 @@ func main() {
 }
 
 func (s *AuthService) handleLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
 		return
 	}
 
+	// Password logins are refused while the email is locked, whether or
+	// not an account has it.
+	passwordLogin := req.Provider == "" || req.Provider == "email"
+	if passwordLogin {
+		locked, err := s.loginLockedFor(ctx, req.Email)
+		if err != nil {
+			log.Printf("Failed to check login lockout: %v", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if locked > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(locked.Seconds()))))
+			http.Error(w, "Account temporarily locked", http.StatusLocked)
+			return
+		}
+	}
+
 	var user User
 	var hashedPassword string
 
 	// Check if user exists
-	err := s.db.QueryRow(`
-        SELECT id, email, provider, password_hash, created_at 
+	err := s.db.QueryRowContext(ctx, `
+        SELECT id, email, provider, plan, role, password_hash, created_at
         FROM users WHERE email = $1
-    `, req.Email).Scan(&user.ID, &user.Email, &user.Provider, &hashedPassword, &user.CreatedAt)
+    `, req.Email).Scan(&user.ID, &user.Email, &user.Provider, &user.Plan, &user.Role, &hashedPassword, &user.CreatedAt)
 
-	if err == sql.ErrNoRows {
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to look up user: %v", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err == sql.ErrNoRows && !passwordLogin {
 		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 		return
 	}
 
-	// Verify password for email/password login
-	if req.Provider == "" || req.Provider == "email" {
-		if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password)); err != nil {
+	// Verify password for email/password login. An unknown email fails
+	// like a wrong password, taking as long and counting toward the lockout.
+	if passwordLogin {
+		hash := []byte(hashedPassword)
+		if err == sql.ErrNoRows {
+			hash = dummyPasswordHash
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(req.Password)) != nil || err == sql.ErrNoRows {
+			if err := s.recordLoginFailure(ctx, req.Email); err != nil {
+				log.Printf("Failed to record failed login: %v", err)
+			}
 			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
 			return
 		}
 	} else {
 		// Verify OAuth token with Firebase
-		token, err := s.firebaseAuth.VerifyIDToken(context.Background(), req.Token)
+		token, err := s.firebaseAuth.VerifyIDToken(ctx, req.Token)
 		if err != nil {
 			http.Error(w, "Invalid OAuth token", http.StatusUnauthorized)
 			return
@@ -130,22 +196,33 @@ func (s *AuthService) handleLogin(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate JWT tokens
-	accessToken, err := s.generateAccessToken(user)
+	sessionID, err := newSessionID()
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	refreshToken, err := s.generateRefreshToken(user)
+	accessToken, err := s.generateAccessToken(user, sessionID)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	// Update last login
-	if _, err := s.db.Exec("UPDATE users SET last_login = NOW() WHERE id = $1", user.ID); err != nil {
+	refreshToken, err := s.generateRefreshToken(user, sessionID)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	// Update last login and forget earlier failures
+	if _, err := s.db.ExecContext(ctx, "UPDATE users SET last_login = NOW() WHERE id = $1", user.ID); err != nil {
 		log.Printf("Failed to update last login for user %s: %v", user.ID, err)
 	}
+	if passwordLogin {
+		if err := s.clearLoginFailures(ctx, req.Email); err != nil {
+			log.Printf("Failed to clear failed logins for user %s: %v", user.ID, err)
+		}
+	}
 
 	response := AuthResponse{
 		AccessToken:  accessToken,
@@ -161,6 +238,7 @@ func (s *AuthService) handleLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request", http.StatusBadRequest)
@@ -169,7 +247,7 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 
 	// Check if user already exists
 	var exists bool
-	if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", req.Email).Scan(&exists); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", req.Email).Scan(&exists); err != nil {
 		log.Printf("Failed to check user existence: %v", err)
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
@@ -187,12 +265,12 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create user
-	var userID string
-	err = s.db.QueryRow(`
+	var userID, plan, role string
+	err = s.db.QueryRowContext(ctx, `
         INSERT INTO users (email, provider, password_hash) 
         VALUES ($1, $2, $3) 
-        RETURNING id
-    `, req.Email, "email", string(hashedPassword)).Scan(&userID)
+        RETURNING id, plan, role
+    `, req.Email, "email", string(hashedPassword)).Scan(&userID, &plan, &role)
 
 	if err != nil {
 		http.Error(w, "Failed to create user", http.StatusInternalServerError)
@@ -200,7 +278,7 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create user preferences
-	if _, err := s.db.Exec("INSERT INTO user_preferences (user_id) VALUES ($1)", userID); err != nil {
+	if _, err := s.db.ExecContext(ctx, "INSERT INTO user_preferences (user_id) VALUES ($1)", userID); err != nil {
 		log.Printf("Failed to create user preferences for user %s: %v", userID, err)
 		// Non-critical, so we don't return error
 	}
@@ -210,11 +288,14 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 		ID:        userID,
 		Email:     req.Email,
 		Provider:  "email",
+		Plan:      plan,
+		Role:      role,
 		CreatedAt: time.Now(),
 	}
 
-	accessToken, _ := s.generateAccessToken(user)
-	refreshToken, _ := s.generateRefreshToken(user)
+	sessionID, _ := newSessionID()
+	accessToken, _ := s.generateAccessToken(user, sessionID)
+	refreshToken, _ := s.generateRefreshToken(user, sessionID)
 
 	response := AuthResponse{
 		AccessToken:  accessToken,
@@ -230,24 +311,38 @@ func (s *AuthService) handleRegister(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *AuthService) generateAccessToken(user User) (string, error) {
+func (s *AuthService) generateAccessToken(user User, sessionID string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": user.ID,
 		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour).Unix(),
-		"iat":     time.Now().Unix(),
+		"plan":    user.Plan,
+		"role":    user.Role,
+		"scopes":  sessionScopes,
+		"sid":     sessionID,
+		"type":    "access",
+		"iss":     s.jwtIssuer,
+		"aud":     s.jwtAudience,
+		"exp":     now.Add(time.Hour).Unix(),
+		"nbf":     now.Unix(),
+		"iat":     now.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(s.jwtSecret)
 }
 
-func (s *AuthService) generateRefreshToken(user User) (string, error) {
+func (s *AuthService) generateRefreshToken(user User, sessionID string) (string, error) {
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": user.ID,
+		"sid":     sessionID,
 		"type":    "refresh",
-		"exp":     time.Now().Add(time.Hour * 24 * 30).Unix(), // 30 days
-		"iat":     time.Now().Unix(),
+		"iss":     s.jwtIssuer,
+		"aud":     s.jwtAudience,
+		"exp":     now.Add(time.Hour * 24 * 30).Unix(), // 30 days
+		"nbf":     now.Unix(),
+		"iat":     now.Unix(),
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
@@ -255,6 +350,7 @@ func (s *AuthService) generateRefreshToken(user User) (string, error) {
 }
 
 func (s *AuthService) handleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	var req struct {
 		RefreshToken string `json:"refresh_token"`
 	}
@@ -280,12 +376,18 @@ func (s *AuthService) handleRefreshToken(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	sessionID, _ := claims["sid"].(string)
+	if revoked, err := s.isSessionRevoked(r.Context(), sessionID); err != nil || revoked {
+		http.Error(w, "Session revoked", http.StatusUnauthorized)
+		return
+	}
+
 	// Get user
 	var user User
-	err = s.db.QueryRow(`
-        SELECT id, email, provider, created_at 
+	err = s.db.QueryRowContext(ctx, `
+        SELECT id, email, provider, plan, role, created_at 
         FROM users WHERE id = $1
-    `, claims["user_id"]).Scan(&user.ID, &user.Email, &user.Provider, &user.CreatedAt)
+    `, claims["user_id"]).Scan(&user.ID, &user.Email, &user.Provider, &user.Plan, &user.Role, &user.CreatedAt)
 
 	if err != nil {
 		http.Error(w, "User not found", http.StatusNotFound)
@@ -293,7 +395,7 @@ func (s *AuthService) handleRefreshToken(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Generate new access token
-	accessToken, err := s.generateAccessToken(user)
+	accessToken, err := s.generateAccessToken(user, sessionID)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
@@ -343,8 +445,32 @@ func (s *AuthService) handleVerifyToken(w http.ResponseWriter, r *http.Request)
 	}
 }
 
+// handleLogout revokes the session of the access token in the
+// Authorization header, which also ends its refresh token. Gateways may
+// keep accepting the access token for as long as they cache verifications.
 func (s *AuthService) handleLogout(w http.ResponseWriter, r *http.Request) {
-	// In a real implementation, you might want to blacklist the token
+	tokenString, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	claims, err := s.parseAccessToken(tokenString)
+	if err != nil {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	sessionID, _ := claims["sid"].(string)
+	if sessionID == "" {
+		http.Error(w, "Token has no session to revoke", http.StatusBadRequest)
+		return
+	}
+	userID, _ := claims["user_id"].(string)
+	if err := s.revokeSession(r.Context(), sessionID, userID); err != nil {
+		log.Printf("Failed to revoke session %s: %v", sessionID, err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"}); err != nil {
 		log.Printf("Failed to encode response: %v", err)
@@ -357,8 +483,11 @@ func (s *AuthService) startGRPCServer() {
 		log.Fatal("Failed to listen:", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	// Register gRPC service here
+	grpcServer := grpc.NewServer(
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.UnaryInterceptor(instrumentGRPC),
+	)
+	authv1.RegisterAuthServiceServer(grpcServer, &grpcAuthServer{service: s})
 	log.Println("Auth gRPC server listening on :50051")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatal("Failed to serve:", err)
@@ -371,3 +500,24 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Failed to encode health response: %v", err)
 	}
 }
+
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func getEnvInt(key string, fallback int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_requests_total",
+		Help: "gRPC requests handled, by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_request_duration_seconds",
+		Help:    "gRPC request latency, by method.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method"})
+
+	accountLockouts = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "auth_account_lockouts_total",
+		Help: "Accounts locked after repeated failed logins.",
+	})
+)
+
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			route = "unmatched"
+		}
+		labels := []string{r.Method, route, strconv.Itoa(rec.status)}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+func instrumentGRPC(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	grpcRequestDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func registerDBMetrics(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "auth"))
+}
@@ -0,0 +1,20 @@
+package main
+
+// Roles of users.
+const (
+	roleUser  = "user"
+	roleAdmin = "admin"
+)
+
+// sessionScopes are the scopes of every access token: a logged-in user may
+// use every route group their role admits them to. API keys are granted a
+// subset, apiKeyScopes.
+var sessionScopes = []string{
+	"read-only",
+	"analytics",
+	"dashboards:write",
+	"profile:write",
+	"watchlists:write",
+	"alerts:write",
+	"api-keys",
+}
@@ -10,9 +10,14 @@ import (
 	"time"
 
 	"github.com/gorilla/mux"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type DashboardService struct {
@@ -46,12 +51,23 @@ type Permission struct {
 }
 
 func main() {
+	shutdownTracing, err := setupTracing(context.Background(), "dashboard-service")
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}()
+
 	// Initialize database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := openDB(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
+	registerDBMetrics(db)
 
 	// Initialize Redis
 	opt, err := redis.ParseURL(os.Getenv("REDIS_URL"))
@@ -59,10 +75,15 @@ func main() {
 		log.Fatal("Failed to parse Redis URL:", err)
 	}
 	redisClient := redis.NewClient(opt)
+	if err := redisotel.InstrumentTracing(redisClient); err != nil {
+		log.Fatal("Failed to instrument Redis:", err)
+	}
+	redisClient.AddHook(redisMetrics{})
 
 	// Initialize Kafka writer
+	kafkaBrokers := []string{os.Getenv("KAFKA_BROKERS")}
 	kafkaWriter := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{os.Getenv("KAFKA_BROKERS")},
+		Brokers: kafkaBrokers,
 		Topic:   "dashboard-events",
 	})
 
@@ -74,6 +95,8 @@ func main() {
 
 	// Setup routes
 	router := mux.NewRouter()
+	router.Use(traceRoutes)
+	router.Use(instrumentRoutes)
 
 	// Dashboard routes
 	router.HandleFunc("/dashboards", service.listDashboards).Methods("GET")
@@ -97,15 +120,28 @@ func main() {
 
 	// Health check
 	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/livez", handleLive).Methods("GET")
+	router.HandleFunc("/readyz", readyHandler(
+		healthCheck{name: "postgres", critical: true, probe: db.PingContext},
+		// Redis only caches dashboards, and events are best effort.
+		healthCheck{name: "redis", probe: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}},
+		healthCheck{name: "kafka", probe: kafkaProbe(kafkaBrokers)},
+	)).Methods("GET")
+
+	// Metrics
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	log.Println("Dashboard service listening on :8084")
 	log.Fatal(http.ListenAndServe(":8084", router))
 }
 
 func (s *DashboardService) listDashboards(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
-	rows, err := s.db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
         SELECT d.id, d.user_id, d.name, d.layout, d.is_public, d.created_at, d.updated_at
         FROM dashboards d
         LEFT JOIN dashboard_permissions dp ON d.id = dp.dashboard_id
@@ -128,7 +164,7 @@ func (s *DashboardService) listDashboards(w http.ResponseWriter, r *http.Request
 		}
 
 		// Load widgets for each dashboard
-		s.loadWidgets(&d)
+		s.loadWidgets(ctx, &d)
 		dashboards = append(dashboards, d)
 	}
 
@@ -139,6 +175,7 @@ func (s *DashboardService) listDashboards(w http.ResponseWriter, r *http.Request
 }
 
 func (s *DashboardService) createDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
 	var req struct {
@@ -153,7 +190,7 @@ func (s *DashboardService) createDashboard(w http.ResponseWriter, r *http.Reques
 	}
 
 	var dashboardID string
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
         INSERT INTO dashboards (user_id, name, layout, is_public)
         VALUES ($1, $2, $3, $4)
         RETURNING id
@@ -165,7 +202,7 @@ func (s *DashboardService) createDashboard(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Publish event
-	s.publishEvent("dashboard.created", map[string]interface{}{
+	s.publishEvent(ctx, "dashboard.created", map[string]interface{}{
 		"dashboard_id": dashboardID,
 		"user_id":      userID,
 		"name":         req.Name,
@@ -189,12 +226,12 @@ func (s *DashboardService) createDashboard(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *DashboardService) getDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
 	// Check cache first
-	ctx := r.Context()
 	cacheKey := "dashboard:" + dashboardID
 	cached, err := s.redis.Get(ctx, cacheKey).Result()
 	if err == nil {
@@ -206,7 +243,7 @@ func (s *DashboardService) getDashboard(w http.ResponseWriter, r *http.Request)
 	}
 
 	var dashboard Dashboard
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
         SELECT id, user_id, name, layout, is_public, created_at, updated_at
         FROM dashboards
         WHERE id = $1
@@ -227,7 +264,7 @@ func (s *DashboardService) getDashboard(w http.ResponseWriter, r *http.Request)
 
 	// Check permissions
 	if !dashboard.IsPublic && dashboard.UserID != userID {
-		hasPermission := s.checkPermission(dashboardID, userID)
+		hasPermission := s.checkPermission(ctx, dashboardID, userID)
 		if !hasPermission {
 			http.Error(w, "Access denied", http.StatusForbidden)
 			return
@@ -235,7 +272,7 @@ func (s *DashboardService) getDashboard(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Load widgets
-	s.loadWidgets(&dashboard)
+	s.loadWidgets(ctx, &dashboard)
 
 	// Cache the result
 	responseData, err := json.Marshal(dashboard)
@@ -253,13 +290,14 @@ func (s *DashboardService) getDashboard(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *DashboardService) updateDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -283,7 +321,7 @@ func (s *DashboardService) updateDashboard(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE dashboards
         SET name = $1, layout = $2, is_public = $3, updated_at = NOW()
         WHERE id = $4
@@ -295,13 +333,14 @@ func (s *DashboardService) updateDashboard(w http.ResponseWriter, r *http.Reques
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "dashboard:"+dashboardID)
 
 	// Publish event
-	s.publishEvent("dashboard.updated", map[string]interface{}{
-		"dashboard_id": dashboardID,
-		"user_id":      userID,
+	s.publishEvent(ctx, "dashboard.updated", map[string]interface{}{
+		"dashboard_id":  dashboardID,
+		"user_id":       userID,
+		"owner_id":      ownerID,
+		"collaborators": s.collaborators(ctx, dashboardID),
 	})
 
 	w.WriteHeader(http.StatusOK)
@@ -311,13 +350,14 @@ func (s *DashboardService) updateDashboard(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *DashboardService) deleteDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -330,20 +370,24 @@ func (s *DashboardService) deleteDashboard(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	_, err := s.db.Exec("DELETE FROM dashboards WHERE id = $1", dashboardID)
+	// Read collaborators before the delete cascades to their permissions
+	collaborators := s.collaborators(ctx, dashboardID)
+
+	_, err := s.db.ExecContext(ctx, "DELETE FROM dashboards WHERE id = $1", dashboardID)
 	if err != nil {
 		http.Error(w, "Failed to delete dashboard", http.StatusInternalServerError)
 		return
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "dashboard:"+dashboardID)
 
 	// Publish event
-	s.publishEvent("dashboard.deleted", map[string]interface{}{
-		"dashboard_id": dashboardID,
-		"user_id":      userID,
+	s.publishEvent(ctx, "dashboard.deleted", map[string]interface{}{
+		"dashboard_id":  dashboardID,
+		"user_id":       userID,
+		"owner_id":      ownerID,
+		"collaborators": collaborators,
 	})
 
 	w.WriteHeader(http.StatusOK)
@@ -353,13 +397,14 @@ func (s *DashboardService) deleteDashboard(w http.ResponseWriter, r *http.Reques
 }
 
 func (s *DashboardService) addWidget(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -379,7 +424,7 @@ func (s *DashboardService) addWidget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var widgetID string
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
         INSERT INTO dashboard_widgets (dashboard_id, widget_type, config, position)
         VALUES ($1, $2, $3, $4)
         RETURNING id
@@ -393,14 +438,16 @@ func (s *DashboardService) addWidget(w http.ResponseWriter, r *http.Request) {
 	widget.ID = widgetID
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "dashboard:"+dashboardID)
 
 	// Publish event
-	s.publishEvent("widget.added", map[string]interface{}{
-		"dashboard_id": dashboardID,
-		"widget_id":    widgetID,
-		"widget_type":  widget.Type,
+	s.publishEvent(ctx, "widget.added", map[string]interface{}{
+		"dashboard_id":  dashboardID,
+		"widget_id":     widgetID,
+		"widget_type":   widget.Type,
+		"user_id":       userID,
+		"owner_id":      ownerID,
+		"collaborators": s.collaborators(ctx, dashboardID),
 	})
 
 	w.Header().Set("Content-Type", "application/json")
@@ -411,6 +458,7 @@ func (s *DashboardService) addWidget(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *DashboardService) updateWidget(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	widgetID := vars["widgetId"]
@@ -418,7 +466,7 @@ func (s *DashboardService) updateWidget(w http.ResponseWriter, r *http.Request)
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -437,7 +485,7 @@ func (s *DashboardService) updateWidget(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE dashboard_widgets
         SET config = $1, position = $2, updated_at = NOW()
         WHERE id = $3 AND dashboard_id = $4
@@ -449,7 +497,6 @@ func (s *DashboardService) updateWidget(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "dashboard:"+dashboardID)
 
 	w.WriteHeader(http.StatusOK)
@@ -459,6 +506,7 @@ func (s *DashboardService) updateWidget(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *DashboardService) deleteWidget(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	widgetID := vars["widgetId"]
@@ -466,7 +514,7 @@ func (s *DashboardService) deleteWidget(w http.ResponseWriter, r *http.Request)
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -479,7 +527,7 @@ func (s *DashboardService) deleteWidget(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         DELETE FROM dashboard_widgets
         WHERE id = $1 AND dashboard_id = $2
     `, widgetID, dashboardID)
@@ -490,7 +538,6 @@ func (s *DashboardService) deleteWidget(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "dashboard:"+dashboardID)
 
 	w.WriteHeader(http.StatusOK)
@@ -500,13 +547,14 @@ func (s *DashboardService) deleteWidget(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *DashboardService) shareDashboard(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
 	// Check ownership
 	var ownerID string
-	if err := s.db.QueryRow("SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
 		if err == sql.ErrNoRows {
 			http.Error(w, "Dashboard not found", http.StatusNotFound)
 		} else {
@@ -531,7 +579,7 @@ func (s *DashboardService) shareDashboard(w http.ResponseWriter, r *http.Request
 
 	// Add permissions
 	for _, sharedUserID := range req.UserIDs {
-		_, err := s.db.Exec(`
+		_, err := s.db.ExecContext(ctx, `
             INSERT INTO dashboard_permissions (dashboard_id, user_id, permission_type)
             VALUES ($1, $2, $3)
             ON CONFLICT (dashboard_id, user_id) 
@@ -544,8 +592,9 @@ func (s *DashboardService) shareDashboard(w http.ResponseWriter, r *http.Request
 	}
 
 	// Publish event
-	s.publishEvent("dashboard.shared", map[string]interface{}{
+	s.publishEvent(ctx, "dashboard.shared", map[string]interface{}{
 		"dashboard_id": dashboardID,
+		"user_id":      userID,
 		"shared_with":  req.UserIDs,
 		"permission":   req.Permission,
 	})
@@ -556,8 +605,8 @@ func (s *DashboardService) shareDashboard(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (s *DashboardService) loadWidgets(dashboard *Dashboard) {
-	rows, err := s.db.Query(`
+func (s *DashboardService) loadWidgets(ctx context.Context, dashboard *Dashboard) {
+	rows, err := s.db.QueryContext(ctx, `
         SELECT id, widget_type, config, position
         FROM dashboard_widgets
         WHERE dashboard_id = $1
@@ -578,9 +627,9 @@ func (s *DashboardService) loadWidgets(dashboard *Dashboard) {
 	}
 }
 
-func (s *DashboardService) checkPermission(dashboardID, userID string) bool {
+func (s *DashboardService) checkPermission(ctx context.Context, dashboardID, userID string) bool {
 	var exists bool
-	if err := s.db.QueryRow(`
+	if err := s.db.QueryRowContext(ctx, `
         SELECT EXISTS(
             SELECT 1 FROM dashboard_permissions
             WHERE dashboard_id = $1 AND user_id = $2
@@ -591,7 +640,34 @@ func (s *DashboardService) checkPermission(dashboardID, userID string) bool {
 	return exists
 }
 
-func (s *DashboardService) publishEvent(eventType string, data map[string]interface{}) {
+// collaborators returns the users a dashboard is shared with, so events can
+// name everyone who should see the change.
+func (s *DashboardService) collaborators(ctx context.Context, dashboardID string) []string {
+	rows, err := s.db.QueryContext(ctx, `
+        SELECT user_id FROM dashboard_permissions
+        WHERE dashboard_id = $1
+    `, dashboardID)
+
+	if err != nil {
+		log.Printf("Failed to load collaborators for %s: %v", dashboardID, err)
+		return []string{}
+	}
+	defer rows.Close()
+
+	userIDs := []string{}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err == nil {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	return userIDs
+}
+
+// publishEvent writes an event to Kafka. The event carries the trace of the
+// request that caused it in its headers, but is written even if that
+// request is cancelled.
+func (s *DashboardService) publishEvent(ctx context.Context, eventType string, data map[string]interface{}) {
 	event := map[string]interface{}{
 		"type":      eventType,
 		"timestamp": time.Now().Unix(),
@@ -604,21 +680,49 @@ func (s *DashboardService) publishEvent(eventType string, data map[string]interf
 		return
 	}
 
-	ctx := context.Background()
-	err = s.kafka.WriteMessages(ctx, kafka.Message{
-		Value: message,
-	})
+	ctx, span := tracer.Start(ctx, "dashboard-events publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName("dashboard-events"),
+		),
+	)
+	defer span.End()
+
+	msg := kafka.Message{Value: message}
+	otel.GetTextMapPropagator().Inject(ctx, kafkaHeaders{&msg})
+	err = s.kafka.WriteMessages(context.WithoutCancel(ctx), msg)
 
 	if err != nil {
+		kafkaErrors.WithLabelValues("publish").Inc()
 		log.Printf("Failed to publish event: %v", err)
 	}
 }
 
+// getPermissions lists a dashboard's collaborators to its owner and
+// collaborators.
 func (s *DashboardService) getPermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	dashboardID := vars["id"]
+	userID := r.Header.Get("X-User-ID")
+
+	var ownerID string
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Dashboard not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if ownerID != userID && !s.checkPermission(ctx, dashboardID, userID) {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
 
-	rows, err := s.db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
         SELECT dp.user_id, dp.permission_type, u.email
         FROM dashboard_permissions dp
         JOIN users u ON u.id = dp.user_id
@@ -631,7 +735,7 @@ func (s *DashboardService) getPermissions(w http.ResponseWriter, r *http.Request
 	}
 	defer rows.Close()
 
-	var permissions []map[string]string
+	permissions := []map[string]string{}
 	for rows.Next() {
 		var userID, permission, email string
 		if err := rows.Scan(&userID, &permission, &email); err == nil {
@@ -649,12 +753,91 @@ func (s *DashboardService) getPermissions(w http.ResponseWriter, r *http.Request
 	}
 }
 
-func (s *DashboardService) updatePermissions(_ http.ResponseWriter, _ *http.Request) {
-	// Implementation similar to shareDashboard
+// permissionTypes are the levels of access a dashboard can be shared with.
+var permissionTypes = map[string]bool{"read": true, "write": true, "admin": true}
+
+// updatePermissions replaces a dashboard's collaborators. Only the owner
+// may change them.
+func (s *DashboardService) updatePermissions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	dashboardID := vars["id"]
+	userID := r.Header.Get("X-User-ID")
+
+	var ownerID string
+	if err := s.db.QueryRowContext(ctx, "SELECT user_id FROM dashboards WHERE id = $1", dashboardID).Scan(&ownerID); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Dashboard not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+	if ownerID != userID {
+		http.Error(w, "Access denied", http.StatusForbidden)
+		return
+	}
+
+	var req struct {
+		Permissions []Permission `json:"permissions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	userIDs := make([]string, 0, len(req.Permissions))
+	for _, p := range req.Permissions {
+		if p.UserID == "" || p.UserID == ownerID || !permissionTypes[p.Permission] {
+			http.Error(w, "Invalid permission", http.StatusBadRequest)
+			return
+		}
+		userIDs = append(userIDs, p.UserID)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `
+        DELETE FROM dashboard_permissions
+        WHERE dashboard_id = $1 AND NOT (user_id::text = ANY($2))
+    `, dashboardID, pq.Array(userIDs)); err != nil {
+		log.Printf("Failed to update permissions for %s: %v", dashboardID, err)
+		http.Error(w, "Failed to update permissions", http.StatusInternalServerError)
+		return
+	}
+	for _, p := range req.Permissions {
+		if _, err := tx.ExecContext(ctx, `
+            INSERT INTO dashboard_permissions (dashboard_id, user_id, permission_type)
+            VALUES ($1, $2, $3)
+            ON CONFLICT (dashboard_id, user_id)
+            DO UPDATE SET permission_type = $3
+        `, dashboardID, p.UserID, p.Permission); err != nil {
+			log.Printf("Failed to update permissions for %s: %v", dashboardID, err)
+			http.Error(w, "Failed to update permissions", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		http.Error(w, "Failed to update permissions", http.StatusInternalServerError)
+		return
+	}
+
+	// Invalidate cache
+	s.redis.Del(ctx, "dashboard:"+dashboardID)
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(map[string]string{"message": "Permissions updated successfully"}); err != nil {
+		log.Println("Failed to write response:", err)
+	}
 }
 
 func (s *DashboardService) listPublicDashboards(w http.ResponseWriter, r *http.Request) {
-	rows, err := s.db.Query(`
+	ctx := r.Context()
+	rows, err := s.db.QueryContext(ctx, `
         SELECT d.id, d.user_id, d.name, d.layout, d.created_at, d.updated_at, u.email
         FROM dashboards d
         JOIN users u ON u.id = d.user_id
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	redisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "redis_errors_total",
+		Help: "Failed Redis commands, by command.",
+	}, []string{"command"})
+
+	kafkaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_errors_total",
+		Help: "Kafka errors, by operation.",
+	}, []string{"operation"})
+)
+
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			route = "unmatched"
+		}
+		labels := []string{r.Method, route, strconv.Itoa(rec.status)}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func registerDBMetrics(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "dashboards"))
+}
+
+type redisMetrics struct{}
+
+func (redisMetrics) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			redisErrors.WithLabelValues("dial").Inc()
+		}
+		return conn, err
+	}
+}
+
+func (redisMetrics) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		// The client sets cmd's error only after the hooks return.
+		err := next(ctx, cmd)
+		countRedisError(cmd.Name(), err)
+		return err
+	}
+}
+
+func (redisMetrics) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			countRedisError(cmd.Name(), cmd.Err())
+		}
+		return err
+	}
+}
+
+func countRedisError(command string, err error) {
+	if err != nil && !errors.Is(err, redis.Nil) {
+		redisErrors.WithLabelValues(command).Inc()
+	}
+}
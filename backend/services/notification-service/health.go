@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+const healthCheckTimeout = 2 * time.Second
+
+type healthCheck struct {
+	name     string
+	critical bool
+	probe    func(ctx context.Context) error
+}
+
+type healthReport struct {
+	Status string         `json:"status"`
+	Checks []healthResult `json:"checks"`
+}
+
+type healthResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	Critical  bool    `json:"critical"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func handleLive(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func readyHandler(checks ...healthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		results := make([]healthResult, len(checks))
+		var wg sync.WaitGroup
+		for i, check := range checks {
+			wg.Add(1)
+			go func(i int, check healthCheck) {
+				defer wg.Done()
+				results[i] = runHealthCheck(r.Context(), check)
+			}(i, check)
+		}
+		wg.Wait()
+
+		report := healthReport{Status: "ok", Checks: results}
+		for _, result := range results {
+			if result.Status == "ok" {
+				continue
+			}
+			if result.Critical {
+				report.Status = "unavailable"
+			} else if report.Status == "ok" {
+				report.Status = "degraded"
+			}
+		}
+
+		status := http.StatusOK
+		if report.Status == "unavailable" {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealth(w, status, report)
+	}
+}
+
+func runHealthCheck(ctx context.Context, check healthCheck) healthResult {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.probe(ctx)
+	result := healthResult{
+		Name:      check.name,
+		Status:    "ok",
+		Critical:  check.critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func writeHealth(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to encode health response: %v", err)
+	}
+}
+
+func kafkaProbe(brokers []string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var errs []error
+		for _, broker := range brokers {
+			conn, err := kafka.DialContext(ctx, "tcp", broker)
+			if err == nil {
+				return conn.Close()
+			}
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+}
+
+func httpProbe(url string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return fmt.Errorf("%s returned %s", url, resp.Status)
+		}
+		return nil
+	}
+}
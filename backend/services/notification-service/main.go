@@ -1,12 +1,15 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"time"
 
 	"fmt"
 
@@ -14,13 +17,26 @@ import (
 	"firebase.google.com/go/v4/messaging"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/segmentio/kafka-go"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type NotificationService struct {
 	db    *sql.DB
 	fcm   *messaging.Client
 	kafka *kafka.Reader
+	// groupID is the Kafka consumer group, recorded on consumer spans.
+	groupID string
+
+	// gatewayURL and internalToken reach the API gateway's internal API,
+	// which pushes notifications to the recipient's open WebSockets.
+	gatewayURL    string
+	internalToken string
+	httpClient    *http.Client
 }
 
 type Notification struct {
@@ -35,12 +51,23 @@ type Notification struct {
 }
 
 func main() {
+	shutdownTracing, err := setupTracing(context.Background(), "notification-service")
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}()
+
 	// Initialize database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := openDB(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
+	registerDBMetrics(db)
 
 	// Initialize Firebase
 	ctx := context.Background()
@@ -55,16 +82,24 @@ func main() {
 	}
 
 	// Initialize Kafka reader
+	const groupID = "notification-service"
 	kafkaReader := kafka.NewReader(kafka.ReaderConfig{
 		Brokers: []string{os.Getenv("KAFKA_BROKERS")},
 		Topic:   "notifications",
-		GroupID: "notification-service",
+		GroupID: groupID,
 	})
 
 	service := &NotificationService{
-		db:    db,
-		fcm:   fcmClient,
-		kafka: kafkaReader,
+		db:            db,
+		fcm:           fcmClient,
+		kafka:         kafkaReader,
+		groupID:       groupID,
+		gatewayURL:    os.Getenv("GATEWAY_INTERNAL_URL"),
+		internalToken: os.Getenv("INTERNAL_API_TOKEN"),
+		httpClient: &http.Client{
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+			Timeout:   5 * time.Second,
+		},
 	}
 
 	// Start Kafka consumer
@@ -72,6 +107,8 @@ func main() {
 
 	// Setup HTTP routes
 	router := mux.NewRouter()
+	router.Use(traceRoutes)
+	router.Use(instrumentRoutes)
 
 	// Notification routes
 	router.HandleFunc("/notifications", service.getNotifications).Methods("GET")
@@ -89,6 +126,20 @@ func main() {
 
 	// Health check
 	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/livez", handleLive).Methods("GET")
+	// Without Kafka or the gateway, notifications queue up or go unpushed,
+	// but the notification API keeps working.
+	readyChecks := []healthCheck{
+		{name: "postgres", critical: true, probe: db.PingContext},
+		{name: "kafka", probe: kafkaProbe(kafkaReader.Config().Brokers)},
+	}
+	if service.gatewayURL != "" {
+		readyChecks = append(readyChecks, healthCheck{name: "api-gateway", probe: httpProbe(service.gatewayURL + "/livez")})
+	}
+	router.HandleFunc("/readyz", readyHandler(readyChecks...)).Methods("GET")
+
+	// Metrics
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	log.Println("Notification service listening on :8085")
 	log.Fatal(http.ListenAndServe(":8085", router))
@@ -98,37 +149,59 @@ func (s *NotificationService) consumeNotifications() {
 	for {
 		msg, err := s.kafka.ReadMessage(context.Background())
 		if err != nil {
+			kafkaErrors.WithLabelValues("fetch").Inc()
 			log.Printf("Failed to read message: %v", err)
 			continue
 		}
 
 		var event map[string]interface{}
 		if err := json.Unmarshal(msg.Value, &event); err != nil {
+			kafkaErrors.WithLabelValues("decode").Inc()
 			log.Printf("Failed to parse message: %v", err)
 			continue
 		}
 
-		s.processNotificationEvent(event)
+		s.handleMessage(msg, event)
 	}
 }
 
-func (s *NotificationService) processNotificationEvent(event map[string]interface{}) {
+// handleMessage processes one event in a consumer span that continues the
+// trace started by the producer.
+func (s *NotificationService) handleMessage(msg kafka.Message, event map[string]interface{}) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), kafkaHeaders{&msg})
+	ctx, span := tracer.Start(ctx, msg.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystem("kafka"),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingDestinationName(msg.Topic),
+			semconv.MessagingKafkaConsumerGroup(s.groupID),
+			semconv.MessagingKafkaDestinationPartition(msg.Partition),
+			semconv.MessagingKafkaMessageOffset(int(msg.Offset)),
+		),
+	)
+	defer span.End()
+
+	s.processNotificationEvent(ctx, event)
+}
+
+func (s *NotificationService) processNotificationEvent(ctx context.Context, event map[string]interface{}) {
 	eventType := event["type"].(string)
 	data := event["data"].(map[string]interface{})
 
 	switch eventType {
 	case "alert.triggered":
-		s.sendAlertNotification(data)
+		s.sendAlertNotification(ctx, data)
 	case "dashboard.shared":
-		s.sendShareNotification(data)
+		s.sendShareNotification(ctx, data)
 	case "price.threshold":
-		s.sendPriceNotification(data)
+		s.sendPriceNotification(ctx, data)
 	default:
 		log.Printf("Unknown event type: %s", eventType)
 	}
 }
 
-func (s *NotificationService) sendAlertNotification(data map[string]interface{}) {
+func (s *NotificationService) sendAlertNotification(ctx context.Context, data map[string]interface{}) {
 	userID := data["user_id"].(string)
 	symbol := data["symbol"].(string)
 	condition := data["condition"].(string)
@@ -138,11 +211,11 @@ func (s *NotificationService) sendAlertNotification(data map[string]interface{})
 		Body:  fmt.Sprintf("%s alert triggered for %s", condition, symbol),
 	}
 
-	s.sendPushNotification(userID, notification, data)
-	s.saveNotification(userID, "alert", notification.Title, notification.Body, data)
+	s.sendPushNotification(ctx, userID, notification, data)
+	s.saveNotification(ctx, userID, "alert", notification.Title, notification.Body, data)
 }
 
-func (s *NotificationService) sendShareNotification(data map[string]interface{}) {
+func (s *NotificationService) sendShareNotification(ctx context.Context, data map[string]interface{}) {
 	sharedWith := data["shared_with"].([]interface{})
 	dashboardName := data["dashboard_name"].(string)
 	sharedBy := data["shared_by"].(string)
@@ -153,12 +226,12 @@ func (s *NotificationService) sendShareNotification(data map[string]interface{})
 	}
 
 	for _, userID := range sharedWith {
-		s.sendPushNotification(userID.(string), notification, data)
-		s.saveNotification(userID.(string), "share", notification.Title, notification.Body, data)
+		s.sendPushNotification(ctx, userID.(string), notification, data)
+		s.saveNotification(ctx, userID.(string), "share", notification.Title, notification.Body, data)
 	}
 }
 
-func (s *NotificationService) sendPriceNotification(data map[string]interface{}) {
+func (s *NotificationService) sendPriceNotification(ctx context.Context, data map[string]interface{}) {
 	userID := data["user_id"].(string)
 	symbol := data["symbol"].(string)
 	price := data["price"].(float64)
@@ -169,13 +242,13 @@ func (s *NotificationService) sendPriceNotification(data map[string]interface{})
 		Body:  fmt.Sprintf("%s reached $%.2f (threshold: $%.2f)", symbol, price, threshold),
 	}
 
-	s.sendPushNotification(userID, notification, data)
-	s.saveNotification(userID, "price", notification.Title, notification.Body, data)
+	s.sendPushNotification(ctx, userID, notification, data)
+	s.saveNotification(ctx, userID, "price", notification.Title, notification.Body, data)
 }
 
-func (s *NotificationService) sendPushNotification(userID string, notification *messaging.Notification, data map[string]interface{}) {
+func (s *NotificationService) sendPushNotification(ctx context.Context, userID string, notification *messaging.Notification, data map[string]interface{}) {
 	// Get user's FCM tokens
-	rows, err := s.db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
         SELECT token FROM fcm_tokens
         WHERE user_id = $1 AND active = true
     `, userID)
@@ -195,6 +268,7 @@ func (s *NotificationService) sendPushNotification(userID string, notification *
 	}
 
 	if len(tokens) == 0 {
+		notificationSends.WithLabelValues("push", "no_tokens").Inc()
 		return
 	}
 
@@ -208,18 +282,21 @@ func (s *NotificationService) sendPushNotification(userID string, notification *
 		Tokens: tokens,
 	}
 
-	response, err := s.fcm.SendMulticast(context.Background(), message)
+	response, err := s.fcm.SendMulticast(ctx, message)
 	if err != nil {
+		notificationSends.WithLabelValues("push", "error").Inc()
 		log.Printf("Failed to send FCM message: %v", err)
 		return
 	}
+	notificationSends.WithLabelValues("push", "sent").Add(float64(response.SuccessCount))
+	notificationSends.WithLabelValues("push", "failed").Add(float64(response.FailureCount))
 
 	// Handle failed tokens
 	if response.FailureCount > 0 {
 		for i, result := range response.Responses {
 			if !result.Success {
 				// Mark token as inactive
-				s.db.Exec(`
+				s.db.ExecContext(ctx, `
                     UPDATE fcm_tokens
                     SET active = false
                     WHERE token = $1
@@ -229,21 +306,72 @@ func (s *NotificationService) sendPushNotification(userID string, notification *
 	}
 }
 
-func (s *NotificationService) saveNotification(userID, notifType, title, body string, data map[string]interface{}) {
-	_, err := s.db.Exec(`
+func (s *NotificationService) saveNotification(ctx context.Context, userID, notifType, title, body string, data map[string]interface{}) {
+	n := Notification{
+		UserID: userID,
+		Type:   notifType,
+		Title:  title,
+		Body:   body,
+		Data:   data,
+	}
+
+	err := s.db.QueryRowContext(ctx, `
         INSERT INTO notifications (user_id, type, title, body, data)
         VALUES ($1, $2, $3, $4, $5)
-    `, userID, notifType, title, body, mustMarshal(data))
+        RETURNING id, created_at
+    `, userID, notifType, title, body, mustMarshal(data)).Scan(&n.ID, &n.CreatedAt)
 
 	if err != nil {
 		log.Printf("Failed to save notification: %v", err)
+		return
+	}
+
+	s.pushRealtime(ctx, &n)
+}
+
+// pushRealtime sends a notification to the recipient's open WebSocket
+// connections through the API gateway. It is skipped when the gateway is
+// not configured; the notification is already stored either way.
+func (s *NotificationService) pushRealtime(ctx context.Context, n *Notification) {
+	if s.gatewayURL == "" {
+		return
+	}
+
+	payload := mustMarshal(map[string]interface{}{
+		"type": "notification",
+		"data": n,
+	})
+	endpoint := fmt.Sprintf("%s/internal/ws/users/%s/messages", s.gatewayURL, url.PathEscape(n.UserID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		log.Printf("Failed to build realtime push: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Token", s.internalToken)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		notificationSends.WithLabelValues("realtime", "error").Inc()
+		log.Printf("Failed to push notification to gateway: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		notificationSends.WithLabelValues("realtime", "rejected").Inc()
+		log.Printf("Gateway rejected notification push: %s", resp.Status)
+		return
 	}
+	notificationSends.WithLabelValues("realtime", "sent").Inc()
 }
 
 func (s *NotificationService) getNotifications(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
-	rows, err := s.db.Query(`
+	rows, err := s.db.QueryContext(ctx, `
         SELECT id, type, title, body, data, read, created_at
         FROM notifications
         WHERE user_id = $1
@@ -276,11 +404,12 @@ func (s *NotificationService) getNotifications(w http.ResponseWriter, r *http.Re
 }
 
 func (s *NotificationService) markAsRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	notifID := vars["id"]
 	userID := r.Header.Get("X-User-ID")
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE notifications
         SET read = true, read_at = NOW()
         WHERE id = $1 AND user_id = $2
@@ -296,9 +425,10 @@ func (s *NotificationService) markAsRead(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *NotificationService) markAllAsRead(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE notifications
         SET read = true, read_at = NOW()
         WHERE user_id = $1 AND read = false
@@ -314,6 +444,7 @@ func (s *NotificationService) markAllAsRead(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *NotificationService) registerToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
 	var req struct {
@@ -326,7 +457,7 @@ func (s *NotificationService) registerToken(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         INSERT INTO fcm_tokens (user_id, token, platform)
         VALUES ($1, $2, $3)
         ON CONFLICT (token) 
@@ -343,6 +474,7 @@ func (s *NotificationService) registerToken(w http.ResponseWriter, r *http.Reque
 }
 
 func (s *NotificationService) unregisterToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
 	var req struct {
@@ -354,7 +486,7 @@ func (s *NotificationService) unregisterToken(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE fcm_tokens
         SET active = false
         WHERE user_id = $1 AND token = $2
@@ -370,6 +502,7 @@ func (s *NotificationService) unregisterToken(w http.ResponseWriter, r *http.Req
 }
 
 func (s *NotificationService) getSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
 	var settings struct {
@@ -379,7 +512,7 @@ func (s *NotificationService) getSettings(w http.ResponseWriter, r *http.Request
 		NewsEnabled   bool `json:"news_enabled"`
 	}
 
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
         SELECT email_notifications, push_notifications, price_alerts, news_alerts
         FROM notification_settings
         WHERE user_id = $1
@@ -392,7 +525,7 @@ func (s *NotificationService) getSettings(w http.ResponseWriter, r *http.Request
 
 	if err == sql.ErrNoRows {
 		// Create default settings
-		s.db.Exec(`
+		s.db.ExecContext(ctx, `
             INSERT INTO notification_settings (user_id)
             VALUES ($1)
         `, userID)
@@ -410,6 +543,7 @@ func (s *NotificationService) getSettings(w http.ResponseWriter, r *http.Request
 }
 
 func (s *NotificationService) updateSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	userID := r.Header.Get("X-User-ID")
 
 	var settings struct {
@@ -424,7 +558,7 @@ func (s *NotificationService) updateSettings(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE notification_settings
         SET email_notifications = $1, push_notifications = $2, price_alerts = $3, news_alerts = $4, updated_at = NOW()
         WHERE user_id = $5
@@ -452,7 +586,7 @@ func (s *NotificationService) sendTestNotification(w http.ResponseWriter, r *htt
 		"time": "now",
 	}
 
-	s.sendPushNotification(userID, notification, data)
+	s.sendPushNotification(r.Context(), userID, notification, data)
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(map[string]string{"message": "Test notification sent"})
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "HTTP requests handled, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP request latency, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	kafkaErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "kafka_errors_total",
+		Help: "Kafka errors, by operation.",
+	}, []string{"operation"})
+
+	// Outcomes: sent, failed, rejected, error, no_tokens
+	notificationSends = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "notification_sends_total",
+		Help: "Notification deliveries, by channel and outcome.",
+	}, []string{"channel", "outcome"})
+)
+
+func instrumentRoutes(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		route, err := mux.CurrentRoute(r).GetPathTemplate()
+		if err != nil {
+			route = "unmatched"
+		}
+		labels := []string{r.Method, route, strconv.Itoa(rec.status)}
+		httpRequests.WithLabelValues(labels...).Inc()
+		httpRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func registerDBMetrics(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "notifications"))
+}
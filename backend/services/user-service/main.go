@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"log"
@@ -10,6 +11,8 @@ import (
 
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -28,12 +31,23 @@ type UserProfile struct {
 }
 
 func main() {
+	shutdownTracing, err := setupTracing(context.Background(), "user-service")
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Println("Failed to flush traces:", err)
+		}
+	}()
+
 	// Initialize database
-	db, err := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	db, err := openDB(os.Getenv("DATABASE_URL"))
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 	defer db.Close()
+	registerDBMetrics(db)
 
 	// Initialize Redis
 	opt, err := redis.ParseURL(os.Getenv("REDIS_URL"))
@@ -41,6 +55,10 @@ func main() {
 		log.Fatal("Failed to parse Redis URL:", err)
 	}
 	redisClient := redis.NewClient(opt)
+	if err := redisotel.InstrumentTracing(redisClient); err != nil {
+		log.Fatal("Failed to instrument Redis:", err)
+	}
+	redisClient.AddHook(redisMetrics{})
 
 	service := &UserService{
 		db:    db,
@@ -49,6 +67,8 @@ func main() {
 
 	// Setup routes
 	router := mux.NewRouter()
+	router.Use(traceRoutes)
+	router.Use(instrumentRoutes)
 
 	// User profile routes
 	router.HandleFunc("/users/{id}", service.getUser).Methods("GET")
@@ -68,17 +88,28 @@ func main() {
 
 	// Health check
 	router.HandleFunc("/health", handleHealth).Methods("GET")
+	router.HandleFunc("/livez", handleLive).Methods("GET")
+	router.HandleFunc("/readyz", readyHandler(
+		healthCheck{name: "postgres", critical: true, probe: db.PingContext},
+		// Redis only caches profiles.
+		healthCheck{name: "redis", probe: func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		}},
+	)).Methods("GET")
+
+	// Metrics
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	log.Println("User service listening on :8083")
 	log.Fatal(http.ListenAndServe(":8083", router))
 }
 
 func (s *UserService) getUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
 	// Check cache first
-	ctx := r.Context()
 	cacheKey := "user:" + userID
 	cached, err := s.redis.Get(ctx, cacheKey).Result()
 	if err == nil {
@@ -93,7 +124,7 @@ func (s *UserService) getUser(w http.ResponseWriter, r *http.Request) {
 	var profile UserProfile
 	var prefsJSON []byte
 
-	err = s.db.QueryRow(`
+	err = s.db.QueryRowContext(ctx, `
         SELECT u.id, u.email, u.display_name, u.avatar_url, u.created_at,
                p.settings
         FROM users u
@@ -141,6 +172,7 @@ func (s *UserService) getUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *UserService) updateUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
@@ -155,7 +187,7 @@ func (s *UserService) updateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update database
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE users 
         SET display_name = $1, avatar_url = $2, updated_at = NOW()
         WHERE id = $3
@@ -167,7 +199,6 @@ func (s *UserService) updateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "user:"+userID)
 
 	w.WriteHeader(http.StatusOK)
@@ -177,6 +208,7 @@ func (s *UserService) updateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *UserService) getPreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
@@ -188,7 +220,7 @@ func (s *UserService) getPreferences(w http.ResponseWriter, r *http.Request) {
 		Settings             json.RawMessage `json:"settings"`
 	}
 
-	err := s.db.QueryRow(`
+	err := s.db.QueryRowContext(ctx, `
         SELECT theme, timezone, notifications_enabled, default_dashboard_id, settings
         FROM user_preferences
         WHERE user_id = $1
@@ -202,7 +234,7 @@ func (s *UserService) getPreferences(w http.ResponseWriter, r *http.Request) {
 
 	if err == sql.ErrNoRows {
 		// Create default preferences
-		_, err = s.db.Exec(`
+		_, err = s.db.ExecContext(ctx, `
             INSERT INTO user_preferences (user_id) VALUES ($1)
         `, userID)
 		if err != nil {
@@ -226,6 +258,7 @@ func (s *UserService) getPreferences(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *UserService) updatePreferences(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
@@ -242,7 +275,7 @@ func (s *UserService) updatePreferences(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE user_preferences
         SET theme = $1, timezone = $2, notifications_enabled = $3, 
             default_dashboard_id = $4, settings = $5, updated_at = NOW()
@@ -256,7 +289,6 @@ func (s *UserService) updatePreferences(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "user:"+userID)
 
 	w.WriteHeader(http.StatusOK)
@@ -266,11 +298,12 @@ func (s *UserService) updatePreferences(w http.ResponseWriter, r *http.Request)
 }
 
 func (s *UserService) deleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
 	// Soft delete
-	_, err := s.db.Exec(`
+	_, err := s.db.ExecContext(ctx, `
         UPDATE users 
         SET deleted_at = NOW()
         WHERE id = $1
@@ -282,7 +315,6 @@ func (s *UserService) deleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "user:"+userID)
 
 	w.WriteHeader(http.StatusOK)
@@ -292,6 +324,7 @@ func (s *UserService) deleteUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *UserService) uploadAvatar(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	vars := mux.Vars(r)
 	userID := vars["id"]
 
@@ -313,7 +346,7 @@ func (s *UserService) uploadAvatar(w http.ResponseWriter, r *http.Request) {
 	// For now, we'll just save the filename
 	avatarURL := "/avatars/" + userID + "_" + header.Filename
 
-	_, err = s.db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
         UPDATE users 
         SET avatar_url = $1, updated_at = NOW()
         WHERE id = $2
@@ -325,7 +358,6 @@ func (s *UserService) uploadAvatar(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Invalidate cache
-	ctx := r.Context()
 	s.redis.Del(ctx, "user:"+userID)
 
 	w.Header().Set("Content-Type", "application/json")
@@ -0,0 +1,10 @@
+-- Revoked login sessions. Every access and refresh token carries the session
+-- ID of the login that issued it in its "sid" claim.
+CREATE TABLE revoked_sessions (
+    session_id VARCHAR(64) PRIMARY KEY,
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    revoked_at TIMESTAMP DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_revoked_sessions_expires ON revoked_sessions (expires_at);
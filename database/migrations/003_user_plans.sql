@@ -0,0 +1,3 @@
+-- Subscription plan of each user. Access tokens carry it in their "plan"
+-- claim, and the API gateway applies the plan's rate limits.
+ALTER TABLE users ADD COLUMN plan VARCHAR(50) NOT NULL DEFAULT 'free';
@@ -0,0 +1,7 @@
+-- Failed password logins, for locking accounts under brute-force attack.
+-- failed_logins counts the failures since first_failed_login_at; when they
+-- reach the auth service's limit the account is locked until locked_until.
+ALTER TABLE users
+    ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN first_failed_login_at TIMESTAMP,
+    ADD COLUMN locked_until TIMESTAMP;
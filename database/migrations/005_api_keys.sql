@@ -0,0 +1,18 @@
+-- API keys for programmatic clients. Only the SHA-256 hash of each key is
+-- stored; prefix is the key's public part, shown to its owner so keys can be
+-- told apart. scopes limit what a key may do, and revoked or expired keys
+-- are rejected.
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    prefix VARCHAR(32) UNIQUE NOT NULL,
+    key_hash CHAR(64) UNIQUE NOT NULL,
+    scopes TEXT[] NOT NULL,
+    expires_at TIMESTAMP,
+    last_used_at TIMESTAMP,
+    revoked_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys (user_id);
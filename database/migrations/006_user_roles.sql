@@ -0,0 +1,4 @@
+-- Role of each user. Access tokens carry it in their "role" claim, and the
+-- API gateway admits only admins to administration routes.
+ALTER TABLE users ADD COLUMN role VARCHAR(50) NOT NULL DEFAULT 'user'
+    CHECK (role IN ('user', 'admin'));
@@ -0,0 +1,16 @@
+-- Failed password logins by email address rather than by user, so emails
+-- with no account are locked exactly like those with one and the lockout
+-- doesn't reveal which accounts exist. failures counts the failures since
+-- first_failed_at; when they reach the auth service's limit logins to the
+-- email are refused until locked_until.
+CREATE TABLE login_failures (
+    email VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    first_failed_at TIMESTAMP,
+    locked_until TIMESTAMP
+);
+
+ALTER TABLE users
+    DROP COLUMN failed_logins,
+    DROP COLUMN first_failed_login_at,
+    DROP COLUMN locked_until;
@@ -19,6 +19,12 @@ class WebSocketService {
   bool _intentionalClose = false;
   int _reconnectAttempts = 0;
 
+  // Last stream position received; sent as resume_from on reconnect so
+  // the gateway replays anything missed while disconnected.
+  int _lastSeq = 0;
+
+  static const _closeTokenExpired = 4001;
+
   Stream<Map<String, dynamic>> get messages => _messageController.stream;
   Stream<ConnectionStatus> get connectionStatus => _connectionController.stream;
 
@@ -29,7 +35,9 @@ class WebSocketService {
       final token = await GetIt.instance<AuthService>().getAccessToken();
       if (token == null) throw Exception('No auth token available');
 
-      final wsUrl = Uri.parse('${ApiConstants.wsBaseUrl}/ws?token=$token');
+      var query = 'token=${Uri.encodeQueryComponent(token)}';
+      if (_lastSeq > 0) query += '&resume_from=$_lastSeq';
+      final wsUrl = Uri.parse('${ApiConstants.wsBaseUrl}/ws?$query');
       _channel = WebSocketChannel.connect(wsUrl);
 
       _connectionController.add(ConnectionStatus.connecting);
@@ -52,6 +60,10 @@ class WebSocketService {
   void _handleMessage(dynamic message) {
     try {
       final data = json.decode(message as String);
+      final seq = data['seq'];
+      if (seq is int && seq > _lastSeq) _lastSeq = seq;
+      // A 'resync' message means missed updates could not be replayed;
+      // listeners should reload their state.
       _messageController.add(data);
     } catch (e) {
       debugPrint('Error parsing WebSocket message: $e');
@@ -59,13 +71,21 @@ class WebSocketService {
   }
 
   void _handleDisconnect() {
+    final closeCode = _channel?.closeCode;
     _channel = null;
     _connectionController.add(ConnectionStatus.disconnected);
     _stopPingTimer();
 
-    if (!_intentionalClose) {
-      _scheduleReconnect();
+    if (_intentionalClose) return;
+
+    // The gateway closes with 4001 when the access token expires; reconnect
+    // straight away so a fresh token is fetched.
+    if (closeCode == _closeTokenExpired) {
+      _reconnectAttempts = 0;
+      connect();
+      return;
     }
+    _scheduleReconnect();
   }
 
   void _handleError(error) {
@@ -0,0 +1,33 @@
+#!/bin/bash
+set -e
+
+# Regenerates the Go protobuf and gRPC stubs for backend/proto. Each Go module
+# gets its own copy because the Docker build contexts are per service.
+#
+# Requires protoc, protoc-gen-go v1.31.0 and protoc-gen-go-grpc v1.3.0.
+
+cd "$(dirname "$0")/.."
+
+PROTO_DIR=backend/proto
+
+generate() {
+    local out=$1
+    local pkg=$2
+    local file=$3
+
+    mkdir -p "$out"
+    protoc -I "$PROTO_DIR" \
+        --go_out="$out" --go_opt=paths=source_relative --go_opt="M$file=$pkg" \
+        --go-grpc_out="$out" --go-grpc_opt=paths=source_relative --go-grpc_opt="M$file=$pkg" \
+        "$PROTO_DIR/$file"
+}
+
+echo "Generating auth/v1..."
+generate backend/services/auth-service/proto \
+    "github.com/financial-analytics/auth-service/proto/auth/v1;authv1" \
+    auth/v1/auth.proto
+generate backend/api-gateway/internal/proto \
+    "github.com/financial-analytics/api-gateway/internal/proto/auth/v1;authv1" \
+    auth/v1/auth.proto
+
+echo "Done!"